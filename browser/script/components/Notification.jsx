@@ -91,7 +91,7 @@ var Notification = React.createClass({
                     message = (<p key="msg">{T(this.props.item.message, {url: this.state.URL})}</p>);
                 }
             } else {
-                message = (<p key="msg">{T(this.props.item.message)}</p>);
+                message = (<p key="msg">{T(this.props.item.message, this.props.item.messageArgs)}</p>);
             }
         }
         if (hidden) {
@@ -291,6 +291,7 @@ var Settings = React.createClass({
             countryCode: "",
             clientAutoUpdate: false,
             blocklistAutoUpdate: false,
+            httpBodyCapture: false,
             statusSummary: {},
             // notificationsDisplay: "none",
             notificationsDisplay: "block",
@@ -304,6 +305,7 @@ var Settings = React.createClass({
             languageOptions: item.languageOptions,
             clientAutoUpdate: item.clientAutoUpdate,
             blocklistAutoUpdate: item.blocklistAutoUpdate,
+            httpBodyCapture: item.httpBodyCapture,
             countryCode: item.countryCode,
         });
     },
@@ -340,6 +342,12 @@ var Settings = React.createClass({
         });
     },
 
+    handleChangehttpBodyCapture: function(e) {
+        this.setState({
+            httpBodyCapture: e.target.checked,
+        });
+    },
+
     handleChangeCountry: function(e) {
         this.setState({
             countryCode: e.target.value,
@@ -365,6 +373,7 @@ var Settings = React.createClass({
                 countryCode: this.state.countryCode,
                 language: this.state.language,
                 blocklistAutoUpdate: this.state.blocklistAutoUpdate,
+                httpBodyCapture: this.state.httpBodyCapture,
             });
         }
     },
@@ -419,6 +428,13 @@ var Settings = React.createClass({
                   </Input>
                   <SelectCountry value={this.state.countryCode}
                                  onChange={this.handleChangeCountry} />
+                  <Input label={T( "options")}
+                         wrapperClassName="wrapper">
+                  <Input type="checkbox"
+                         checked={this.state.httpBodyCapture}
+                         onChange={this.handleChangehttpBodyCapture}
+                         label={T( "http_body_capture")} />
+                  </Input>
             </MaybePanel>);
         }
 
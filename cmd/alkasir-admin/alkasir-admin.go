@@ -17,12 +17,15 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alkasir/alkasir/pkg/central/db"
 	"github.com/alkasir/alkasir/pkg/debugexport"
+	"github.com/alkasir/alkasir/pkg/measure"
 	"github.com/alkasir/alkasir/pkg/nexus"
+	"github.com/alkasir/alkasir/pkg/shared"
 	"github.com/alkasir/alkasir/pkg/upgradebin"
 	"github.com/alkasir/alkasir/pkg/upgradebin/makepatch"
 	"github.com/davecgh/go-spew/spew"
@@ -49,17 +52,142 @@ var rootCommand = &Command{
 			Func: downloadSnapshot,
 			Help: "artifact-id - Download latest snapshot from nexus",
 		},
+		{
+			Name: "blockpage",
+			Subs: Commands{
+				{
+					Name: "list",
+					Func: listBlockPageSignatures,
+					Help: "[countrycode] - List block page signatures.",
+				},
+				{
+					Name: "add",
+					Func: addBlockPageSignature,
+					Help: "[-cc countrycode] [-distance n] [-file html] [-comment text] kind [pattern] - Add a title, body or simhash block page signature.",
+				},
+				{
+					Name: "delete",
+					Func: deleteBlockPageSignature,
+					Help: "id - Delete a block page signature.",
+				},
+			},
+		},
+		{
+			Name: "hosts",
+			Subs: Commands{
+				{
+					Name: "list",
+					Func: listPublishedHosts,
+					Help: "[countrycode] - List published hosts and host rules.",
+				},
+				{
+					Name: "add",
+					Func: addHostRule,
+					Help: "countrycode asn rule - Publish a host rule, see the HostsFile format.",
+				},
+				{
+					Name: "remove",
+					Func: removeHostRule,
+					Help: "countrycode asn rule - Remove a published host or host rule.",
+				},
+			},
+		},
+		{
+			Name: "db",
+			Subs: Commands{
+				{
+					Name: "migrate",
+					Func: dbMigrate,
+					Help: "Apply pending schema migrations.",
+				},
+				{
+					Name: "status",
+					Func: dbMigrationStatus,
+					Help: "List applied and pending schema migrations.",
+				},
+			},
+		},
 		{
 			Name: "export-api",
 			Subs: Commands{
 				{
 					Name: "insert",
 					Func: insertExportAPIAuth,
-					Help: "username password - set username/password for export api authentication.",
+					Help: "[-scopes scope,...] username password - set username/password for export api authentication.",
+				},
+				{
+					Name: "list",
+					Func: listExportAPIAuth,
+					Help: "List export api users and their scopes.",
+				},
+				{
+					Name: "disable",
+					Func: setExportAPIAuthEnabled(false),
+					Help: "username - Disable an export api user, issued tokens are rejected.",
+				},
+				{
+					Name: "enable",
+					Func: setExportAPIAuthEnabled(true),
+					Help: "username - Enable a disabled export api user.",
+				},
+				{
+					Name: "rotate",
+					Func: rotateExportAPIAuth,
+					Help: "[-scopes scope,...] username - Set a new random password and revoke issued tokens.",
+				},
+				{
+					Name: "scopes",
+					Func: setExportAPIScopes,
+					Help: "username scope,... - Replace the scopes of an export api user, issued tokens are revoked.",
+				},
+				{
+					Name: "audit",
+					Func: listExportAPIAudit,
+					Help: "[-n count] [username] - List the latest export api requests.",
+				},
+			},
+		},
+		{
+			Name: "webhooks",
+			Subs: Commands{
+				{
+					Name: "insert",
+					Func: insertWebhook,
+					Help: "[-cc country code] url - Subscribe an https url to publish events, prints the signing secret.",
+				},
+				{
+					Name: "list",
+					Func: listWebhooks,
+					Help: "List webhook subscriptions and their delivery state.",
+				},
+				{
+					Name: "disable",
+					Func: setWebhookEnabled(false),
+					Help: "id - Stop delivering events to a webhook.",
+				},
+				{
+					Name: "enable",
+					Func: setWebhookEnabled(true),
+					Help: "id - Resume delivering events to a webhook from the last delivered event.",
+				},
+				{
+					Name: "delete",
+					Func: deleteWebhook,
+					Help: "id - Remove a webhook subscription.",
 				},
 			},
 		},
 
+		{
+			Name: "blocklist",
+			Subs: Commands{
+				{
+					Name: "makekeys",
+					Func: makeBlocklistKeys,
+					Help: " - Generate a key pair for hosts list signing",
+				},
+			},
+		},
 		{
 			Name: "upgrade",
 			Subs: Commands{
@@ -142,8 +270,10 @@ func printHelp() {
 
 // flags
 var (
-	pgConnFlag   string
-	nWorkersFlag int
+	pgConnFlag     string
+	dbBackendFlag  string
+	sqlitePathFlag string
+	nWorkersFlag   int
 
 	debugPublicKey string
 	debugSecretKey string
@@ -163,6 +293,8 @@ func init() {
 	flag.StringVar(&pgConnFlag, "pgconn",
 		"user=alkasir_central password=alkasir_central dbname=alkasir_central port=39558 sslmode=disable",
 		"postgresql connection string")
+	flag.StringVar(&dbBackendFlag, "db", "postgres", "database backend, postgres or sqlite")
+	flag.StringVar(&sqlitePathFlag, "sqlitePath", "", "sqlite database file used by the sqlite backend")
 
 	flag.StringVar(&debugSecretKey, "debug_sk", "", "Secret key used to decrypt debug logs")
 	flag.StringVar(&debugPublicKey, "debug_pk", "", "Public key used to decrypt debug logs")
@@ -275,6 +407,17 @@ var sqlDB *db.DB
 // InitDBopens a connection to the database.
 func OpenDB() error {
 	var err error
+	switch dbBackendFlag {
+	case "postgres":
+	case "sqlite":
+		if sqlitePathFlag == "" {
+			return fmt.Errorf("sqlitePath flag is required by the sqlite backend")
+		}
+		sqlDB, err = db.OpenSQLite(sqlitePathFlag)
+		return err
+	default:
+		return fmt.Errorf("unknown db backend: %s", dbBackendFlag)
+	}
 	sqlDB, err = db.Open(pgConnFlag)
 	if err != nil {
 		return err
@@ -393,18 +536,28 @@ func insertUpgrades([]string) error {
 }
 
 func makeUpgradeKeys([]string) error {
+	return makeKeys("upgrades", upgradebin.UpgradesKeyName)
+}
+
+func makeBlocklistKeys([]string) error {
+	return makeKeys("blocklist", upgradebin.BlocklistKeyName)
+}
+
+// makeKeys generates a ED25519 key pair of the PEM key type name and writes
+// it to prefix-private-key.pem and prefix-public-key.pem.
+func makeKeys(prefix, name string) error {
 	priv, pub := upgradebin.GenerateKeys(rand.Reader)
-	privPem, pubPem := upgradebin.EncodeKeys(priv, pub)
+	privPem, pubPem := upgradebin.EncodeNamedKeys(name, priv, pub)
 	fmt.Println("")
 	fmt.Println(string(privPem))
 	fmt.Println("")
 	fmt.Println(string(pubPem))
 
-	privFile, err1 := os.OpenFile("upgrades-private-key.pem",
+	privFile, err1 := os.OpenFile(prefix+"-private-key.pem",
 		os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 	defer privFile.Close()
 
-	pubFile, err2 := os.OpenFile("upgrades-public-key.pem",
+	pubFile, err2 := os.OpenFile(prefix+"-public-key.pem",
 		os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 
 	defer pubFile.Close()
@@ -607,19 +760,38 @@ func downloadSnapshot(args []string) error {
 	return nil
 }
 
+// exportAPIScopesFlag adds the -scopes flag to fs.
+func exportAPIScopesFlag(fs *flag.FlagSet, value string) *string {
+	return fs.String("scopes", value,
+		"comma separated export api scopes, known scopes are "+strings.Join(db.ExportAPIScopes, ","))
+}
+
 func insertExportAPIAuth(args []string) error {
-	if err := OpenDB(); err != nil {
+	fs := flag.NewFlagSet("export-api insert", flag.ContinueOnError)
+	scopesFlag := exportAPIScopesFlag(fs, db.ScopeBlockedRead+","+db.ScopeSamplesRead)
+	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	args = fs.Args()
 	if len(args) != 2 {
 		fmt.Println("need [username] and [password]")
 		return errNoValue
 	}
+	scopes, err := db.ParseScopes(*scopesFlag)
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
 
 	creds := db.APICredentials{
 		Username: args[0],
+		Scopes:   scopes,
+	}
+	if err := creds.SetPassword(args[1]); err != nil {
+		return err
 	}
-	creds.SetPassword(args[1])
 
 	if err := sqlDB.InsertExportAPICredentials(creds); err != nil {
 		return err
@@ -628,6 +800,447 @@ func insertExportAPIAuth(args []string) error {
 	return nil
 }
 
+func listExportAPIAuth(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	creds, err := sqlDB.ListExportAPICredentials()
+	if err != nil {
+		return err
+	}
+	for _, v := range creds {
+		enabled := "enabled"
+		if !v.Enabled {
+			enabled = "disabled"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\tgeneration:%d\t%s\n",
+			v.ID, v.Username, enabled, strings.Join(v.Scopes, ","),
+			v.Generation, v.CreatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func setExportAPIAuthEnabled(enabled bool) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			fmt.Println("need [username]")
+			return errNoValue
+		}
+		if err := OpenDB(); err != nil {
+			return err
+		}
+		ok, err := sqlDB.SetExportAPICredentialsEnabled(args[0], enabled)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no export api user %s", args[0])
+		}
+		return nil
+	}
+}
+
+func rotateExportAPIAuth(args []string) error {
+	fs := flag.NewFlagSet("export-api rotate", flag.ContinueOnError)
+	scopesFlag := exportAPIScopesFlag(fs, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		fmt.Println("need [username]")
+		return errNoValue
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	ok, creds, err := sqlDB.GetExportAPIAuthCredentials(args[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no export api user %s", args[0])
+	}
+	if *scopesFlag != "" {
+		creds.Scopes, err = db.ParseScopes(*scopesFlag)
+		if err != nil {
+			return err
+		}
+	}
+	password, err := shared.SecureRandomString(24)
+	if err != nil {
+		return err
+	}
+	if err := creds.SetPassword(password); err != nil {
+		return err
+	}
+	if _, err := sqlDB.UpdateExportAPICredentials(creds); err != nil {
+		return err
+	}
+	fmt.Printf("new password for %s: %s\n", creds.Username, password)
+	return nil
+}
+
+func setExportAPIScopes(args []string) error {
+	if len(args) != 2 {
+		fmt.Println("need [username] and [scopes]")
+		return errNoValue
+	}
+	scopes, err := db.ParseScopes(args[1])
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	ok, creds, err := sqlDB.GetExportAPIAuthCredentials(args[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no export api user %s", args[0])
+	}
+	creds.Scopes = scopes
+	_, err = sqlDB.UpdateExportAPICredentials(creds)
+	return err
+}
+
+func listExportAPIAudit(args []string) error {
+	fs := flag.NewFlagSet("export-api audit", flag.ContinueOnError)
+	countFlag := fs.Int("n", 50, "number of requests to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var username string
+	if fs.NArg() > 0 {
+		username = fs.Arg(0)
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	audit, err := sqlDB.GetExportAPIAudit(username, *countFlag)
+	if err != nil {
+		return err
+	}
+	for _, v := range audit {
+		fmt.Printf("%s\t%s\t%s\t%d\t%s\n",
+			v.CreatedAt.Format(time.RFC3339), v.Username, v.Route, v.Rows, v.Filters)
+	}
+	return nil
+}
+
+func insertWebhook(args []string) error {
+	fs := flag.NewFlagSet("webhooks insert", flag.ContinueOnError)
+	ccFlag := fs.String("cc", "", "country code, events for all countries if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		fmt.Println("need [url]")
+		return errNoValue
+	}
+	if err := db.ValidateWebhookURL(args[0]); err != nil {
+		return err
+	}
+	secret, err := shared.SecureRandomString(32)
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	id, err := sqlDB.InsertWebhookSubscription(db.WebhookSubscription{
+		URL:         args[0],
+		Secret:      secret,
+		CountryCode: *ccFlag,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added webhook %d with secret: %s\n", id, secret)
+	return nil
+}
+
+func listWebhooks(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	subs, err := sqlDB.ListWebhookSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, v := range subs {
+		enabled := "enabled"
+		if !v.Enabled {
+			enabled = "disabled"
+		}
+		cc := v.CountryCode
+		if cc == "" {
+			cc = "*"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\tlast event:%d\tfailures:%d\t%s\n",
+			v.ID, v.URL, cc, enabled, v.LastEventID, v.Failures, v.LastError)
+	}
+	return nil
+}
+
+func setWebhookEnabled(enabled bool) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			fmt.Println("need [id]")
+			return errNoValue
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := OpenDB(); err != nil {
+			return err
+		}
+		ok, err := sqlDB.SetWebhookSubscriptionEnabled(id, enabled)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no webhook with id %d", id)
+		}
+		return nil
+	}
+}
+
+func deleteWebhook(args []string) error {
+	if len(args) != 1 {
+		fmt.Println("need [id]")
+		return errNoValue
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	ok, err := sqlDB.DeleteWebhookSubscription(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no webhook with id %d", id)
+	}
+	return nil
+}
+
+func listBlockPageSignatures(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	signatures, err := sqlDB.GetBlockPageSignatures()
+	if err != nil {
+		return err
+	}
+	for _, v := range signatures {
+		if len(args) > 0 && v.CountryCode != "" && v.CountryCode != strings.ToUpper(args[0]) {
+			continue
+		}
+		cc := v.CountryCode
+		if cc == "" {
+			cc = "*"
+		}
+		fmt.Printf("%d\t%s\t%s\t%q\t%d\t%s\n",
+			v.ID, cc, v.Kind, v.Pattern, v.MaxDistance, v.Comment)
+	}
+	return nil
+}
+
+func addBlockPageSignature(args []string) error {
+	var (
+		ccFlag       string
+		distanceFlag int
+		fileFlag     string
+		commentFlag  string
+	)
+	fs := flag.NewFlagSet("blockpage add", flag.ContinueOnError)
+	fs.StringVar(&ccFlag, "cc", "", "country code, matches all countries if empty")
+	fs.IntVar(&distanceFlag, "distance", 3, "max hamming distance for simhash signatures")
+	fs.StringVar(&fileFlag, "file", "", "calculate a simhash pattern from a saved block page")
+	fs.StringVar(&commentFlag, "comment", "", "free text comment")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 1 {
+		fmt.Println("need [kind] and [pattern]")
+		return errNoValue
+	}
+
+	sig := db.BlockPageSignature{
+		CountryCode: strings.ToUpper(ccFlag),
+		Kind:        args[0],
+		Comment:     commentFlag,
+	}
+	switch sig.Kind {
+	case db.BlockPageTitle, db.BlockPageBody:
+		if len(args) != 2 {
+			fmt.Println("need [pattern]")
+			return errNoValue
+		}
+		sig.Pattern = args[1]
+	case db.BlockPageSimHash:
+		sig.MaxDistance = distanceFlag
+		switch {
+		case fileFlag != "":
+			data, err := ioutil.ReadFile(fileFlag)
+			if err != nil {
+				return err
+			}
+			sig.Pattern = measure.NewSimHash(strings.ToValidUTF8(string(data), "")).String()
+		case len(args) == 2:
+			h, err := measure.ParseSimHash(args[1])
+			if err != nil {
+				return err
+			}
+			sig.Pattern = h.String()
+		default:
+			fmt.Println("need [pattern] or -file")
+			return errNoValue
+		}
+	default:
+		return fmt.Errorf("unknown signature kind: %s", sig.Kind)
+	}
+
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	id, err := sqlDB.InsertBlockPageSignature(sig)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added block page signature %d\n", id)
+	return nil
+}
+
+func deleteBlockPageSignature(args []string) error {
+	if len(args) != 1 {
+		fmt.Println("need [id]")
+		return errNoValue
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	ok, err := sqlDB.DeleteBlockPageSignature(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no block page signature with id %d", id)
+	}
+	return nil
+}
+
+func dbMigrate(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	applied, err := sqlDB.Migrate()
+	if err != nil {
+		return err
+	}
+	for _, v := range applied {
+		fmt.Printf("applied\t%d\t%s\n", v.Version, v.Description)
+	}
+	if len(applied) == 0 {
+		fmt.Println("no pending migrations")
+	}
+	return nil
+}
+
+func dbMigrationStatus(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	applied, pending, err := sqlDB.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, v := range applied {
+		fmt.Printf("applied\t%d\t%s\t%s\t%s\n",
+			v.Version, v.AppliedAt.Format(time.RFC3339), v.Checksum[:12], v.Description)
+	}
+	for _, v := range pending {
+		fmt.Printf("pending\t%d\t\t%s\t%s\n",
+			v.Version, v.Checksum()[:12], v.Description)
+	}
+	return nil
+}
+
+func listPublishedHosts(args []string) error {
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	hosts, err := sqlDB.GetPublishedHosts()
+	if err != nil {
+		return err
+	}
+	for _, v := range hosts {
+		if len(args) > 0 && v.CountryCode != strings.ToUpper(args[0]) {
+			continue
+		}
+		fmt.Printf("%d\t%s\t%d\t%s\tsticky:%v\n",
+			v.ID, v.CountryCode, v.ASN, v.Host, v.Sticky)
+	}
+	return nil
+}
+
+// parseHostRuleArgs parses the countrycode asn rule arguments.
+func parseHostRuleArgs(args []string) (string, int, string, error) {
+	if len(args) != 3 {
+		fmt.Println("need [countrycode] [asn] [rule]")
+		return "", 0, "", errNoValue
+	}
+	asn, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", 0, "", err
+	}
+	return strings.ToUpper(args[0]), asn, args[2], nil
+}
+
+func addHostRule(args []string) error {
+	cc, asn, rule, err := parseHostRuleArgs(args)
+	if err != nil {
+		return err
+	}
+	if _, err := shared.ParseHostRule(rule); err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	return sqlDB.PublishHostRule(rule, cc, asn)
+}
+
+func removeHostRule(args []string) error {
+	cc, asn, rule, err := parseHostRuleArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := OpenDB(); err != nil {
+		return err
+	}
+	ok, err := sqlDB.UnpublishHostRule(rule, cc, asn)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not published for %s %d", rule, cc, asn)
+	}
+	return nil
+}
+
 func debugImportDebug(files []string) error {
 	if len(files) == 0 {
 		fmt.Println("need argument: files...")
@@ -2,6 +2,7 @@ package analysis
 
 import (
 	"encoding/json"
+	"flag"
 	"time"
 
 	"github.com/alkasir/alkasir/pkg/central/db"
@@ -10,11 +11,16 @@ import (
 	"github.com/thomasf/lg"
 )
 
+var (
+	unpublishAfter = flag.Int("unpublishAfter", 3, "not blocked verdicts from independent sessions without a blocked verdict in between before a published host is removed")
+)
+
 var (
 	sessionFetchC   = make(chan shared.SuggestionToken, 0)
 	sampleAnalysisC = make(chan []db.Sample, 0)
-	hostPublishC    = make(chan db.Sample, 0)
+	hostPublishC    = make(chan publishRequest, 0)
 	hostDenyC       = make(chan db.Sample, 0)
+	analysisResultC = make(chan db.AnalysisResult, 0)
 )
 
 func sessionFetcher(clients db.Clients) {
@@ -39,28 +45,29 @@ loop:
 	for samples := range sampleAnalysisC {
 		var (
 			newTokenSample db.Sample
-			clientSamples  = make(map[string]db.Sample, 0)
-			centralSamples = make(map[string]db.Sample, 0)
+			clientSamples  = make(map[string][]db.Sample, 0)
+			centralSamples = make(map[string][]db.Sample, 0)
 		)
 
 		// organize input data
 		for _, s := range samples {
-			switch s.Type {
-			case "NewClientToken":
+			if s.Type == "NewClientToken" {
 				if newTokenSample.Token != "" {
 					lg.Error("got more than one newTokenSample, aborting")
 					continue loop
 				}
 				newTokenSample = s
-			case "HTTPHeader", "DNSQuery":
-				switch s.Origin {
-				case "Central":
-					centralSamples[s.Type] = s
-				case "Client":
-					clientSamples[s.Type] = s
-				}
-			default:
+				continue
+			}
+			if _, ok := getSampleScorer(s.Type); !ok {
 				lg.Errorf("dont know how to handle %d %s, skipping", s.ID, s.Type)
+				continue
+			}
+			switch s.Origin {
+			case "Central":
+				centralSamples[s.Type] = append(centralSamples[s.Type], s)
+			case "Client":
+				clientSamples[s.Type] = append(clientSamples[s.Type], s)
 			}
 		}
 
@@ -73,53 +80,145 @@ loop:
 			lg.Warningln("not accepted host id:", newTokenSample.ID, newTokenSample.Host)
 			continue loop
 		}
-		for _, smap := range []map[string]db.Sample{clientSamples, centralSamples} {
-			for _, stype := range []string{"HTTPHeader"} {
-				if _, ok := smap[stype]; !ok {
-					lg.Errorf("missing %s, cannot analyse", stype)
-					continue loop
-				}
-			}
-		}
 
-		// parse data
-		clientSample := clientSamples["HTTPHeader"]
-		var clientHeader measure.HTTPHeaderResult
-		centralSample := centralSamples["HTTPHeader"]
-		var centralHeader measure.HTTPHeaderResult
+		// score data
+		verdict, err := scoreSession(clientSamples, centralSamples)
+		if err != nil {
+			lg.Errorln(err)
+			continue loop
+		}
+		lg.V(10).Infof("session:%s %s", newTokenSample.Token, verdict)
 
-		if err := json.Unmarshal(clientSample.Data, &clientHeader); err != nil {
+		result, err := newAnalysisResult(newTokenSample, verdict)
+		if err != nil {
 			lg.Errorln(err)
+		}
+		persist := err == nil
+
+		switch {
+		case verdict.Publish():
+			lg.Infoln("publish candidate session", newTokenSample.Token)
+			// the result is persisted by the publisher once it is known
+			// if the host was published
+			hostPublishC <- publishRequest{
+				sample:  newTokenSample,
+				result:  result,
+				persist: persist,
+			}
 			continue loop
+		case centralReached(centralSamples["HTTPHeader"]):
+			lg.Infoln("not publishing session", newTokenSample.Token)
+			hostDenyC <- newTokenSample
+		default:
+			lg.Infof("not publishing session %s, central could not reach %s",
+				newTokenSample.Token, newTokenSample.Host)
+		}
+		if persist {
+			analysisResultC <- result
+		}
+	}
+}
+
+// centralReached returns true if central fetched the host without errors in
+// any of the HTTPHeader samples. A not blocked verdict for a host that is down
+// says nothing about blocking and must not unpublish it.
+func centralReached(samples []db.Sample) bool {
+	for _, s := range samples {
+		var r measure.HTTPHeaderResult
+		if err := json.Unmarshal(s.Data, &r); err != nil {
+			lg.Warningf("could not decode sample %d: %v", s.ID, err)
+			continue
 		}
-		if err := json.Unmarshal(centralSample.Data, &centralHeader); err != nil {
+		if r.Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// newAnalysisResult creates the persisted form of a session verdict.
+// Published is set by the publisher if the host was published.
+func newAnalysisResult(newTokenSample db.Sample, verdict Verdict) (db.AnalysisResult, error) {
+	scores, err := json.Marshal(verdict.Scores)
+	if err != nil {
+		return db.AnalysisResult{}, err
+	}
+	result := db.AnalysisResult{
+		Host:        newTokenSample.Host,
+		CountryCode: newTokenSample.CountryCode,
+		ASN:         newTokenSample.ASN,
+		Token:       newTokenSample.Token,
+		Score:       verdict.Score(),
+		Scores:      scores,
+	}
+	if h, ok := verdict.Scores["HTTPHeader"].(*HTTPHeaderScore); ok {
+		result.StatusCodeScore = h.StatusCode
+		result.RedirectsScore = h.Redirects
+		result.ErrorScore = h.Error
+	}
+	return result, nil
+}
+
+func resultPersister(clients db.Clients) {
+	for result := range analysisResultC {
+		err := clients.DB.InsertAnalysisResult(result)
+		if err != nil {
 			lg.Error(err)
-			continue loop
 		}
+	}
+}
 
-		// score data
-		score := scoreHTTPHeaders(clientHeader, centralHeader)
-		lg.V(10).Infof("session:%s %s", newTokenSample.Token, score)
+// publishRequest is a session with a blocked verdict.
+type publishRequest struct {
+	sample  db.Sample
+	result  db.AnalysisResult
+	persist bool // false if the analysis result could not be created
+}
 
-		if score.Score() >= 0.5 {
-			lg.Infoln("publishing session", newTokenSample.Token)
-			hostPublishC <- newTokenSample
-		} else {
-			lg.Infoln("not publishing session", newTokenSample.Token)
+func hostPublisher(clients db.Clients, thresholds reporterThresholds) {
+	for r := range hostPublishC {
+		result := publishSession(clients.DB, thresholds, r, time.Now())
+		if r.persist {
+			analysisResultC <- result
 		}
 	}
 }
 
-func hostPublisher(clients db.Clients) {
-	for sample := range hostPublishC {
-		err := clients.DB.PublishHost(sample)
+// publishSession adds the session as a publish candidate and returns its
+// analysis result with Published set if the host was published.
+func publishSession(d db.DBClient, thresholds reporterThresholds, r publishRequest, now time.Time) db.AnalysisResult {
+	result := r.result
+	published, err := publishCandidate(d, thresholds, r.sample, now)
+	if err != nil {
+		lg.Error(err)
+		return result
+	}
+	if published {
+		lg.Infof("published host %s for %s/%d", r.sample.Host, r.sample.CountryCode, r.sample.ASN)
+	}
+	result.Published = published
+	return result
+}
+
+// hostDenier records not blocked verdicts for published hosts.
+func hostDenier(clients db.Clients) {
+	for sample := range hostDenyC {
+		removed, err := unpublishCandidate(clients.DB, *unpublishAfter, sample, time.Now())
 		if err != nil {
 			lg.Error(err)
+			continue
+		}
+		if removed {
+			lg.Infof("unpublished host %s for %s/%d", sample.Host, sample.CountryCode, sample.ASN)
 		}
 	}
 }
 
 func StartAnalysis(clients db.Clients) {
+	thresholds, err := parseReporterThresholds(*minReporters, *minReportersByCountry)
+	if err != nil {
+		lg.Fatal(err)
+	}
 
 	tick := time.NewTicker(5 * time.Second)
 	lastID, err := clients.DB.GetLastProcessedSampleID()
@@ -130,8 +229,12 @@ func StartAnalysis(clients db.Clients) {
 	for n := 0; n < 4; n++ {
 		go sessionFetcher(clients)
 		go samplesAnalyzer()
-		go hostPublisher(clients)
+		go hostPublisher(clients, thresholds)
+		go resultPersister(clients)
+		go hostDenier(clients)
 	}
+	go startBlockPageLoader(clients)
+	go startCandidateExpirer(clients)
 
 	lg.Infof("starting analysis from sample ID %d", lastID)
 
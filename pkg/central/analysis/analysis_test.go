@@ -1,11 +1,17 @@
 package analysis
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"flag"
 
 	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
 )
 
 func TestAnalysis(t *testing.T) {
@@ -50,6 +56,156 @@ func TestAnalysis(t *testing.T) {
 
 }
 
+// TestUnpublishNotBlocked runs sessions where the client and central got the
+// same response through the analysis pipeline until the published host is
+// removed.
+func TestUnpublishNotBlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-analysis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	clients := db.Clients{DB: d}
+	go sessionFetcher(clients)
+	go samplesAnalyzer()
+	go hostDenier(clients)
+	go resultPersister(clients)
+
+	defer func(v time.Duration) { *reporterWindow = v }(*reporterWindow)
+	*reporterWindow = 0
+
+	host := db.Sample{Host: "www.youtube.com", CountryCode: "SE", ASN: 1}
+	if err := d.PublishHost(host); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < *unpublishAfter; i++ {
+		token := shared.SuggestionToken(fmt.Sprintf("clean-%d", i))
+		err := d.InsertSessionReporter(db.SessionReporter{
+			Token:    token,
+			UpdateID: fmt.Sprintf("u%d", i),
+			AddrHash: fmt.Sprintf("a%d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []struct{ typ, origin string }{
+			{"NewClientToken", "Central"}, {"HTTPHeader", "Central"}, {"HTTPHeader", "Client"},
+		} {
+			err := d.InsertSample(db.Sample{
+				Host:        host.Host,
+				CountryCode: host.CountryCode,
+				ASN:         host.ASN,
+				Origin:      v.origin,
+				Type:        v.typ,
+				Token:       token,
+				Data:        ytresponse,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		sessionFetchC <- token
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		hosts, err := d.GetPublishedHosts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hosts) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("host was not unpublished: %+v", hosts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	events, err := d.GetPublishEvents(0, shared.ExportFilter{Host: host.Host}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Action != "Remove" {
+		t.Errorf("unexpected publish events: %+v", events)
+	}
+}
+
+func TestCentralReached(t *testing.T) {
+	down := db.Sample{Data: []byte(`{"error":"dial tcp: lookup example.com: no such host"}`)}
+	if centralReached([]db.Sample{down}) {
+		t.Error("expected a host central could not fetch to not be reached")
+	}
+	if !centralReached([]db.Sample{down, {Data: ytresponse}}) {
+		t.Error("expected a host central fetched to be reached")
+	}
+}
+
+func TestNewAnalysisResult(t *testing.T) {
+	verdict := Verdict{
+		Scores: map[string]scorer{
+			"HTTPHeader": &HTTPHeaderScore{StatusCode: 1.0, Redirects: 0.5, Error: 0.5},
+		},
+		Weights: map[string]float64{"HTTPHeader": 1.0},
+	}
+	result, err := newAnalysisResult(db.Sample{
+		Token:       "1",
+		Host:        "youtube.com",
+		CountryCode: "SE",
+		ASN:         1234,
+	}, verdict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCodeScore != 1.0 || result.RedirectsScore != 0.5 || result.ErrorScore != 0.5 {
+		t.Errorf("wrong component scores: %+v", result)
+	}
+	if result.Score != verdict.Score() || result.Published {
+		t.Errorf("wrong final score: %+v", result)
+	}
+	if result.Host != "youtube.com" || result.CountryCode != "SE" || result.ASN != 1234 {
+		t.Errorf("wrong session fields: %+v", result)
+	}
+}
+
+func TestPublishSession(t *testing.T) {
+	d := &candidatesDB{
+		reporters: map[shared.SuggestionToken]db.SessionReporter{
+			"1": {UpdateID: "u1", AddrHash: "a"},
+			"2": {UpdateID: "u2", AddrHash: "b"},
+		},
+	}
+	thresholds := reporterThresholds{def: 2}
+	now := time.Now()
+	for i, v := range []struct {
+		token     shared.SuggestionToken
+		hours     int
+		published bool
+	}{
+		{"1", 0, false},
+		{"2", 4, true},
+	} {
+		sample := db.Sample{
+			Host:        "example.com",
+			CountryCode: "IR",
+			ASN:         1,
+			Token:       v.token,
+			CreatedAt:   now.Add(time.Duration(v.hours) * time.Hour),
+		}
+		result := publishSession(d, thresholds, publishRequest{
+			sample: sample,
+			result: db.AnalysisResult{Token: v.token, Score: 1.0},
+		}, now)
+		if result.Published != v.published || result.Token != v.token || result.Score != 1.0 {
+			t.Errorf("%d: unexpected result %+v", i, result)
+		}
+	}
+}
+
 var ytresponse = []byte(`{
   "url":"http://www.youtube.com/watch?v=wflisz67bpe&feature=share&bpctr=1345276397&has_verified=1",
   "response_header":{
@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+	"github.com/thomasf/lg"
+)
+
+var (
+	blockPageRefreshInterval = flag.Duration("blockPageRefreshInterval", 5*time.Minute, "interval between reloads of block page signatures from the database")
+)
+
+// blockPages holds the currently loaded block page signatures.
+var blockPages = &blockPageSignatures{}
+
+type blockPageSignatures struct {
+	sync.RWMutex
+	signatures []db.BlockPageSignature
+}
+
+func (b *blockPageSignatures) Set(signatures []db.BlockPageSignature) {
+	b.Lock()
+	defer b.Unlock()
+	b.signatures = signatures
+}
+
+// Match returns the first signature from countryCode or from all countries
+// which matches body.
+func (b *blockPageSignatures) Match(countryCode string, body *measure.HTTPBody) (db.BlockPageSignature, bool) {
+	if body == nil {
+		return db.BlockPageSignature{}, false
+	}
+	b.RLock()
+	defer b.RUnlock()
+	for _, s := range b.signatures {
+		if s.CountryCode != "" && s.CountryCode != countryCode {
+			continue
+		}
+		if matchBlockPage(s, body) {
+			return s, true
+		}
+	}
+	return db.BlockPageSignature{}, false
+}
+
+func matchBlockPage(s db.BlockPageSignature, body *measure.HTTPBody) bool {
+	switch s.Kind {
+	case db.BlockPageTitle:
+		return s.Pattern != "" &&
+			strings.Contains(strings.ToLower(body.Title), strings.ToLower(s.Pattern))
+	case db.BlockPageBody:
+		return s.Pattern != "" &&
+			strings.Contains(strings.ToLower(body.Prefix), strings.ToLower(s.Pattern))
+	case db.BlockPageSimHash:
+		if body.Length == 0 {
+			return false
+		}
+		h, err := measure.ParseSimHash(s.Pattern)
+		if err != nil {
+			lg.Warningf("invalid simhash signature %d: %v", s.ID, err)
+			return false
+		}
+		return body.SimHash.Distance(h) <= s.MaxDistance
+	}
+	return false
+}
+
+// startBlockPageLoader keeps the block page signatures in sync with the
+// database.
+func startBlockPageLoader(clients db.Clients) {
+	load := func() {
+		signatures, err := clients.DB.GetBlockPageSignatures()
+		if err != nil {
+			lg.Errorf("could not load block page signatures: %v", err)
+			return
+		}
+		blockPages.Set(signatures)
+		lg.V(10).Infof("loaded %d block page signatures", len(signatures))
+	}
+	load()
+	tick := time.NewTicker(*blockPageRefreshInterval)
+	defer tick.Stop()
+	for range tick.C {
+		load()
+	}
+}
@@ -0,0 +1,55 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+func TestBlockPageMatch(t *testing.T) {
+	page := "<html><title>Access denied</title><body>This site is blocked by order of the authorities</body></html>"
+	hash := measure.NewSimHash(page)
+	b := &blockPageSignatures{}
+	b.Set([]db.BlockPageSignature{
+		{ID: 1, CountryCode: "SE", Kind: db.BlockPageTitle, Pattern: "access DENIED"},
+		{ID: 2, Kind: db.BlockPageBody, Pattern: "blocked by order"},
+		{ID: 3, CountryCode: "IR", Kind: db.BlockPageSimHash, Pattern: hash.String(), MaxDistance: 3},
+	})
+	body := &measure.HTTPBody{
+		Length:  len(page),
+		Title:   "Access denied",
+		Prefix:  page,
+		SimHash: hash,
+	}
+	tests := []struct {
+		countryCode string
+		body        *measure.HTTPBody
+		id          uint64
+	}{
+		{"SE", body, 1},
+		{"NO", body, 2},
+		{"IR", &measure.HTTPBody{Length: 10, SimHash: hash}, 3},
+		{"NO", &measure.HTTPBody{Length: 10, SimHash: hash}, 0},
+		{"SE", nil, 0},
+	}
+	for _, tt := range tests {
+		sig, ok := b.Match(tt.countryCode, tt.body)
+		if ok != (tt.id != 0) || sig.ID != tt.id {
+			t.Errorf("%s: expected signature %d, got %d", tt.countryCode, tt.id, sig.ID)
+		}
+	}
+}
+
+func TestVerdictCertain(t *testing.T) {
+	v := Verdict{
+		Scores: map[string]scorer{
+			"HTTPHeader": &HTTPHeaderScore{StatusCode: 0.5, Redirects: 0.5, Error: 0.5, BlockPage: 1.0},
+			"DNSQuery":   &DNSQueryScore{Bogus: 0.5, Private: 0.5, Mismatch: 0.5, NXDomain: 0.5},
+		},
+		Weights: map[string]float64{"HTTPHeader": 1, "DNSQuery": 1},
+	}
+	if v.Score() != 1.0 {
+		t.Errorf("expected certain verdict, got %s", v)
+	}
+}
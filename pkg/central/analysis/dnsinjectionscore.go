@@ -0,0 +1,75 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+// DNSInjectionScore .
+type DNSInjectionScore struct {
+	// based on the client receiving answers from an address without a DNS
+	// server
+	Injected float64
+}
+
+func (d DNSInjectionScore) String() string {
+	return fmt.Sprintf("score:%.1f (injected:%.1f)", d.Score(), d.Injected)
+}
+
+func (d *DNSInjectionScore) Score() float64 {
+	return d.Injected
+}
+
+// Certain returns true if answers were injected into the client network
+// but not into the central network.
+func (d *DNSInjectionScore) Certain() bool {
+	return d.Injected >= 1.0
+}
+
+// scoreDNSInjections scores injected answers seen by the client. Injected
+// answers seen by central as well indicates a problem with the measurement
+// rather than with the client network so they are not counted.
+func scoreDNSInjections(client, central []measure.DNSInjectionResult) DNSInjectionScore {
+	score := DNSInjectionScore{Injected: 0.5}
+	for _, r := range central {
+		if r.Injected {
+			return score
+		}
+	}
+	for _, r := range client {
+		if r.Injected {
+			score.Injected = 1.0
+		}
+	}
+	return score
+}
+
+func decodeDNSInjectionResults(samples []db.Sample) ([]measure.DNSInjectionResult, error) {
+	var results []measure.DNSInjectionResult
+	for _, s := range samples {
+		var r measure.DNSInjectionResult
+		if err := json.Unmarshal(s.Data, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// scoreDNSInjectionSamples scores all client and central DNSInjection
+// samples of a session.
+func scoreDNSInjectionSamples(client, central []db.Sample) (scorer, error) {
+	clientResults, err := decodeDNSInjectionResults(client)
+	if err != nil {
+		return nil, err
+	}
+	centralResults, err := decodeDNSInjectionResults(central)
+	if err != nil {
+		return nil, err
+	}
+	score := scoreDNSInjections(clientResults, centralResults)
+	return &score, nil
+}
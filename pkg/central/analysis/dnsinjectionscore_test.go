@@ -0,0 +1,46 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+func TestScoreDNSInjections(t *testing.T) {
+	injected := measure.DNSInjectionResult{Injected: true, Responses: 1, Addrs: []string{"10.10.34.34"}}
+	clean := measure.DNSInjectionResult{}
+	tests := []struct {
+		name            string
+		client, central []measure.DNSInjectionResult
+		certain         bool
+	}{
+		{"clean", []measure.DNSInjectionResult{clean}, []measure.DNSInjectionResult{clean}, false},
+		{"injected", []measure.DNSInjectionResult{injected}, []measure.DNSInjectionResult{clean}, true},
+		{"central injected", []measure.DNSInjectionResult{injected}, []measure.DNSInjectionResult{injected}, false},
+	}
+	for _, tt := range tests {
+		score := scoreDNSInjections(tt.client, tt.central)
+		if score.Certain() != tt.certain {
+			t.Errorf("%s: expected certain=%v, got %s", tt.name, tt.certain, score)
+		}
+	}
+}
+
+func TestScoreSessionDNSInjection(t *testing.T) {
+	client := map[string][]db.Sample{
+		"HTTPHeader":   {{Data: []byte(`{"status_code":200}`)}},
+		"DNSInjection": {{Data: []byte(`{"injected":true,"responses":1}`)}},
+	}
+	central := map[string][]db.Sample{
+		"HTTPHeader":   {{Data: []byte(`{"status_code":200}`)}},
+		"DNSInjection": {{Data: []byte(`{"injected":false}`)}},
+	}
+	verdict, err := scoreSession(client, central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Score() != 1.0 || !verdict.Publish() {
+		t.Errorf("expected injected answers to publish, got %s", verdict)
+	}
+}
@@ -0,0 +1,254 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+var (
+	// bogusNets are networks that never should be returned as the address
+	// of a public host.
+	bogusNets []*net.IPNet
+
+	// privateNets are networks which are not routed on the public internet.
+	privateNets []*net.IPNet
+)
+
+// DNSQueryScore compares the client DNS answers with the central answers and
+// with the client's own answers from encrypted resolvers.
+type DNSQueryScore struct {
+	// based on client answers containing unspecified, loopback or reserved addresses
+	Bogus float64
+	// based on client answers containing private network addresses
+	Private float64
+	// based on client answers not overlapping the central answers
+	Mismatch float64
+	// based on the client getting no answers when central did
+	NXDomain float64
+	// based on client plaintext answers differing from client encrypted
+	// resolver answers
+	Encrypted float64
+}
+
+func (d DNSQueryScore) String() string {
+	return fmt.Sprintf(
+		"score:%.1f (bogus:%.1f private:%.1f mismatch:%.1f nxdomain:%.1f encrypted:%.1f)",
+		d.Score(), d.Bogus, d.Private, d.Mismatch, d.NXDomain, d.Encrypted)
+}
+
+// Score returns the strongest signal since any single kind of tampered DNS
+// answer is enough to consider a host blocked.
+func (d *DNSQueryScore) Score() float64 {
+	result := d.Bogus
+	for _, v := range []float64{d.Private, d.Mismatch, d.NXDomain, d.Encrypted} {
+		if v > result {
+			result = v
+		}
+	}
+	return result
+}
+
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBogusAddr(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return true
+	}
+	return ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || inNets(ip, bogusNets)
+}
+
+func isPrivateAddr(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return inNets(ip, privateNets)
+}
+
+// isNXDomain returns true if the result looks like a non existing domain
+// response.
+func isNXDomain(r measure.DNSQueryResult) bool {
+	if r.Rcode == "NXDOMAIN" {
+		return true
+	}
+	if r.Error == "" {
+		return len(r.Addrs) == 0
+	}
+	return strings.Contains(r.Error, "no such host")
+}
+
+func scoreBogus(client []string) float64 {
+	for _, v := range client {
+		if isBogusAddr(v) {
+			return 1.0
+		}
+	}
+	return 0.5
+}
+
+func scorePrivate(client []string, central map[string]bool) float64 {
+	for _, v := range client {
+		if isPrivateAddr(v) && !central[v] {
+			return 1.0
+		}
+	}
+	return 0.5
+}
+
+func scoreMismatch(client []string, central map[string]bool) float64 {
+	if len(client) == 0 || len(central) == 0 {
+		return 0.5
+	}
+	for _, v := range client {
+		if central[v] {
+			return 0.5
+		}
+	}
+	return 0.7
+}
+
+func scoreNXDomain(client measure.DNSQueryResult, central map[string]bool) float64 {
+	if len(central) > 0 && isNXDomain(client) {
+		return 1.0
+	}
+	return 0.5
+}
+
+// scoreEncrypted compares the client plaintext answers with the answers the
+// client got from encrypted resolvers, which are much harder to tamper with.
+func scoreEncrypted(client []measure.DNSQueryResult) float64 {
+	encrypted := make(map[string]bool, 0)
+	for _, r := range client {
+		if r.Encrypted() && r.Error == "" {
+			for _, a := range r.Addrs {
+				encrypted[a] = true
+			}
+		}
+	}
+	if len(encrypted) == 0 {
+		return 0.5
+	}
+	score := 0.5
+	for _, r := range client {
+		if r.Encrypted() {
+			continue
+		}
+		if isNXDomain(r) {
+			return 1.0
+		}
+		score = maxScore(score, scoreMismatch(r.Addrs, encrypted))
+	}
+	return score
+}
+
+// scoreDNSQueries compares every client result with the combined answers of
+// all central results. The highest score for each component is kept.
+func scoreDNSQueries(client, central []measure.DNSQueryResult) DNSQueryScore {
+	centralAddrs := make(map[string]bool, 0)
+	for _, r := range central {
+		if r.Error != "" {
+			continue
+		}
+		for _, a := range r.Addrs {
+			centralAddrs[a] = true
+		}
+	}
+	score := DNSQueryScore{
+		Bogus:    0.5,
+		Private:  0.5,
+		Mismatch: 0.5,
+		NXDomain: 0.5,
+	}
+	score.Encrypted = scoreEncrypted(client)
+	for _, r := range client {
+		score.Bogus = maxScore(score.Bogus, scoreBogus(r.Addrs))
+		score.Private = maxScore(score.Private, scorePrivate(r.Addrs, centralAddrs))
+		score.Mismatch = maxScore(score.Mismatch, scoreMismatch(r.Addrs, centralAddrs))
+		score.NXDomain = maxScore(score.NXDomain, scoreNXDomain(r, centralAddrs))
+	}
+	return score
+}
+
+func maxScore(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func decodeDNSQueryResults(samples []db.Sample) ([]measure.DNSQueryResult, error) {
+	var results []measure.DNSQueryResult
+	for _, s := range samples {
+		var r measure.DNSQueryResult
+		if err := json.Unmarshal(s.Data, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// scoreDNSQuerySamples scores all client and central DNSQuery samples of a
+// session.
+func scoreDNSQuerySamples(client, central []db.Sample) (scorer, error) {
+	clientResults, err := decodeDNSQueryResults(client)
+	if err != nil {
+		return nil, err
+	}
+	centralResults, err := decodeDNSQueryResults(central)
+	if err != nil {
+		return nil, err
+	}
+	score := scoreDNSQueries(clientResults, centralResults)
+	return &score, nil
+}
+
+func mustParseNets(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, v := range cidrs {
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+func init() {
+	bogusNets = mustParseNets(
+		"0.0.0.0/8",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"192.0.0.0/24",
+		"192.0.2.0/24",
+		"198.18.0.0/15",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"2001:db8::/32",
+	)
+	privateNets = mustParseNets(
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	)
+}
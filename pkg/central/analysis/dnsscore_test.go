@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+func TestScoreDNSQueries(t *testing.T) {
+	central := []measure.DNSQueryResult{
+		{Hostname: "example.com", Addrs: []string{"93.184.216.34"}},
+		{Hostname: "example.com", Addrs: []string{"93.184.216.34"}, Resolver: "8.8.8.8:53"},
+	}
+	tests := []struct {
+		name    string
+		client  []measure.DNSQueryResult
+		blocked bool
+	}{
+		{"equal", []measure.DNSQueryResult{
+			{Addrs: []string{"93.184.216.34"}},
+		}, false},
+		{"bogus", []measure.DNSQueryResult{
+			{Addrs: []string{"127.0.0.1"}},
+		}, true},
+		{"private", []measure.DNSQueryResult{
+			{Addrs: []string{"10.10.34.35"}},
+		}, true},
+		{"nxdomain", []measure.DNSQueryResult{
+			{Addrs: []string{}, Error: "lookup example.com: no such host"},
+		}, true},
+		{"empty", []measure.DNSQueryResult{
+			{Addrs: []string{}},
+		}, true},
+		{"one resolver tampered", []measure.DNSQueryResult{
+			{Addrs: []string{"93.184.216.34"}},
+			{Addrs: []string{"0.0.0.0"}, Resolver: "8.8.8.8:53"},
+		}, true},
+	}
+	for _, tt := range tests {
+		score := scoreDNSQueries(tt.client, central)
+		if blocked := score.Score() >= 1.0; blocked != tt.blocked {
+			t.Errorf("%s: expected blocked=%v, got %s", tt.name, tt.blocked, score)
+		}
+	}
+}
+
+func TestScoreDNSQueriesMismatch(t *testing.T) {
+	score := scoreDNSQueries(
+		[]measure.DNSQueryResult{{Addrs: []string{"93.184.216.35"}}},
+		[]measure.DNSQueryResult{{Addrs: []string{"93.184.216.34"}}},
+	)
+	if score.Mismatch <= 0.5 {
+		t.Errorf("expected mismatch score, got %s", score)
+	}
+	if score.Bogus != 0.5 || score.Private != 0.5 || score.NXDomain != 0.5 {
+		t.Errorf("expected neutral scores, got %s", score)
+	}
+}
+
+func TestScoreSession(t *testing.T) {
+	client := map[string][]db.Sample{
+		"HTTPHeader": {{Data: ytresponse}},
+		"DNSQuery":   {{Data: []byte(`{"addrs":["0.0.0.0"],"error":"","hostname":"youtube.com","resolver":""}`)}},
+	}
+	central := map[string][]db.Sample{
+		"HTTPHeader": {{Data: ytresponse}},
+		"DNSQuery":   {{Data: []byte(`{"addrs":["216.58.211.142"],"error":"","hostname":"youtube.com","resolver":""}`)}},
+	}
+	verdict, err := scoreSession(client, central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verdict.Scores) != 2 {
+		t.Fatalf("expected 2 scores, got %s", verdict)
+	}
+	if !verdict.Publish() {
+		t.Errorf("expected publish verdict, got %s", verdict)
+	}
+
+	// a session without differences is not blocked.
+	clean := map[string][]db.Sample{
+		"HTTPHeader": central["HTTPHeader"],
+		"DNSQuery":   central["DNSQuery"],
+	}
+	verdict, err = scoreSession(clean, central)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Score() != 0 || verdict.Publish() {
+		t.Errorf("expected not blocked verdict, got %s", verdict)
+	}
+
+	delete(client, "HTTPHeader")
+	_, err = scoreSession(client, central)
+	if err == nil {
+		t.Error("expected error when required HTTPHeader samples are missing")
+	}
+}
+
+func TestScoreDNSQueriesEncrypted(t *testing.T) {
+	encrypted := measure.DNSQueryResult{
+		Addrs:     []string{"93.184.216.34"},
+		Resolver:  "https://8.8.8.8/dns-query",
+		Transport: measure.DNSTransportHTTPS,
+	}
+	tests := []struct {
+		name      string
+		plaintext measure.DNSQueryResult
+		score     float64
+	}{
+		{"equal", measure.DNSQueryResult{Addrs: []string{"93.184.216.34"}}, 0.5},
+		{"mismatch", measure.DNSQueryResult{Addrs: []string{"93.184.216.35"}}, 0.7},
+		{"nxdomain", measure.DNSQueryResult{Addrs: []string{}, Rcode: "NXDOMAIN"}, 1.0},
+	}
+	for _, tt := range tests {
+		score := scoreDNSQueries([]measure.DNSQueryResult{tt.plaintext, encrypted}, nil)
+		if score.Encrypted != tt.score {
+			t.Errorf("%s: expected encrypted score %.1f, got %s", tt.name, tt.score, score)
+		}
+	}
+}
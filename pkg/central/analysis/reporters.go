@@ -0,0 +1,224 @@
+package analysis
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/thomasf/lg"
+)
+
+var (
+	minReporters          = flag.Int("publishMinReporters", 3, "independent sessions which must find a host blocked before it is published for a country and ASN, sessions are told apart by the update id and address reported by the client which a client can forge")
+	minReportersByCountry = flag.String("publishMinReportersByCountry", "", "per country publishMinReporters, comma separated country code=count pairs like IR=5,SE=1")
+	reporterWindow        = flag.Duration("publishReporterWindow", time.Hour, "sessions started closer in time than this are not independent")
+	candidateMaxAge       = flag.Duration("publishCandidateMaxAge", 14*24*time.Hour, "sessions older than this are not counted for publishing")
+)
+
+// reporterThresholds holds the number of independent sessions required to
+// publish a host.
+type reporterThresholds struct {
+	def       int
+	countries map[string]int
+}
+
+// parseReporterThresholds parses the publishMinReportersByCountry flag
+// format.
+func parseReporterThresholds(def int, s string) (reporterThresholds, error) {
+	t := reporterThresholds{def: def, countries: make(map[string]int, 0)}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return t, fmt.Errorf("invalid country threshold: %s", v)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return t, fmt.Errorf("invalid country threshold: %s", v)
+		}
+		t.countries[strings.ToUpper(strings.TrimSpace(parts[0]))] = n
+	}
+	return t, nil
+}
+
+// get returns the threshold for countryCode.
+func (t reporterThresholds) get(countryCode string) int {
+	if n, ok := t.countries[countryCode]; ok {
+		return n
+	}
+	return t.def
+}
+
+// independent returns true if a and b are from different reporters. Sessions
+// are independent when neither their update ids nor their hashed client
+// networks match and they were started at least window apart.
+func independent(a, b db.PublishCandidate, window time.Duration) bool {
+	if a.UpdateID == b.UpdateID {
+		return false
+	}
+	if a.AddrHash == b.AddrHash {
+		return false
+	}
+	d := a.CreatedAt.Sub(b.CreatedAt)
+	if d < 0 {
+		d = -d
+	}
+	return d >= window
+}
+
+// countIndependent returns the number of candidates which are independent
+// of all previously counted candidates, in candidates order.
+func countIndependent(candidates []db.PublishCandidate, window time.Duration) int {
+	var counted []db.PublishCandidate
+loop:
+	for _, c := range candidates {
+		for _, v := range counted {
+			if !independent(c, v, window) {
+				continue loop
+			}
+		}
+		counted = append(counted, c)
+	}
+	return len(counted)
+}
+
+// sessionReporter returns the reporter of the session of newTokenSample. It
+// returns false if the session can not be counted as a reporter, that is
+// sessions without a recorded reporter or update id.
+func sessionReporter(d db.DBClient, newTokenSample db.Sample) (db.SessionReporter, bool, error) {
+	reporter, ok, err := d.GetSessionReporter(newTokenSample.Token)
+	if err != nil {
+		return reporter, false, err
+	}
+	if !ok || reporter.UpdateID == "" {
+		lg.V(5).Infof("not counting session %s of %s without an update id as a reporter",
+			newTokenSample.Token, newTokenSample.Host)
+		return reporter, false, nil
+	}
+	return reporter, true, nil
+}
+
+// newCandidate returns the candidate for the session of newTokenSample.
+func newCandidate(newTokenSample db.Sample, reporter db.SessionReporter, notBlocked bool) db.PublishCandidate {
+	return db.PublishCandidate{
+		Host:        newTokenSample.Host,
+		CountryCode: newTokenSample.CountryCode,
+		ASN:         newTokenSample.ASN,
+		Token:       newTokenSample.Token,
+		UpdateID:    reporter.UpdateID,
+		AddrHash:    reporter.AddrHash,
+		NotBlocked:  notBlocked,
+		CreatedAt:   newTokenSample.CreatedAt,
+	}
+}
+
+// publishCandidate records the session of newTokenSample as a publish
+// candidate and publishes the host when enough independent sessions have
+// found it blocked. Every blocked verdict, also from re-verification
+// sessions which are not candidates, breaks the series of not blocked
+// verdicts of a published host. Returns true if the host was published.
+func publishCandidate(d db.DBClient, thresholds reporterThresholds, newTokenSample db.Sample, now time.Time) (bool, error) {
+	err := d.ResetNotBlocked(newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN)
+	if err != nil {
+		return false, err
+	}
+	reporter, ok, err := sessionReporter(d, newTokenSample)
+	if err != nil || !ok {
+		return false, err
+	}
+	if reporter.Reverify {
+		// re-verifications are made by clients which already list the host.
+		lg.V(5).Infof("not counting re-verification session %s of %s as a reporter",
+			newTokenSample.Token, newTokenSample.Host)
+		return false, nil
+	}
+	if err := d.InsertPublishCandidate(newCandidate(newTokenSample, reporter, false)); err != nil {
+		return false, err
+	}
+	candidates, err := d.GetPublishCandidates(
+		newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN, false,
+		now.Add(-*candidateMaxAge))
+	if err != nil {
+		return false, err
+	}
+	n := countIndependent(candidates, *reporterWindow)
+	required := thresholds.get(newTokenSample.CountryCode)
+	if n < required {
+		lg.Infof("%s pending for %s/%d, %d of %d independent sessions",
+			newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN, n, required)
+		return false, nil
+	}
+	if err := d.PublishHost(newTokenSample); err != nil {
+		return false, err
+	}
+	if err := d.DeletePublishCandidates(
+		newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// unpublishCandidate records a not blocked verdict of the session of
+// newTokenSample for a published host, including re-verification sessions.
+// The verdict only counts towards removing the host if the session is
+// independent of the sessions which already found it not blocked. Returns
+// true if the host was removed.
+func unpublishCandidate(d db.DBClient, unpublishAfter int, newTokenSample db.Sample, now time.Time) (bool, error) {
+	reporter, ok, err := sessionReporter(d, newTokenSample)
+	if err != nil || !ok {
+		return false, err
+	}
+	candidate := newCandidate(newTokenSample, reporter, true)
+	candidates, err := d.GetPublishCandidates(
+		newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN, true,
+		now.Add(-*candidateMaxAge))
+	if err != nil {
+		return false, err
+	}
+	for _, c := range candidates {
+		if c.Token == candidate.Token {
+			return false, nil
+		}
+		if !independent(candidate, c, *reporterWindow) {
+			lg.Infof("not counting not blocked session %s of %s/%d, not independent of %s",
+				candidate.Token, candidate.CountryCode, candidate.ASN, c.Token)
+			return false, nil
+		}
+	}
+	if err := d.InsertPublishCandidate(candidate); err != nil {
+		return false, err
+	}
+	removed, err := d.RecordNotBlocked(db.HostListEntry{
+		Host:        newTokenSample.Host,
+		CountryCode: newTokenSample.CountryCode,
+		ASN:         newTokenSample.ASN,
+	}, unpublishAfter)
+	if err != nil || !removed {
+		return false, err
+	}
+	if err := d.DeletePublishCandidates(
+		newTokenSample.Host, newTokenSample.CountryCode, newTokenSample.ASN); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// startCandidateExpirer deletes old candidates and session reporters.
+func startCandidateExpirer(clients db.Clients) {
+	tick := time.NewTicker(time.Hour)
+	defer tick.Stop()
+	for range tick.C {
+		n, err := clients.DB.ExpirePublishCandidates(time.Now().Add(-*candidateMaxAge))
+		if err != nil {
+			lg.Errorln(err)
+			continue
+		}
+		lg.V(5).Infof("expired %d publish candidates", n)
+	}
+}
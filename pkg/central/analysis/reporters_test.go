@@ -0,0 +1,221 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+func TestParseReporterThresholds(t *testing.T) {
+	th, err := parseReporterThresholds(3, "ir=5, SE=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cc, n := range map[string]int{"IR": 5, "SE": 1, "CN": 3} {
+		if th.get(cc) != n {
+			t.Errorf("%s: expected %d, got %d", cc, n, th.get(cc))
+		}
+	}
+	for _, v := range []string{"SE", "SE=x"} {
+		if _, err := parseReporterThresholds(3, v); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestCountIndependent(t *testing.T) {
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	c := func(updateID, addrHash string, hours int) db.PublishCandidate {
+		return db.PublishCandidate{
+			UpdateID:  updateID,
+			AddrHash:  addrHash,
+			CreatedAt: start.Add(time.Duration(hours) * time.Hour),
+		}
+	}
+	for i, v := range []struct {
+		candidates []db.PublishCandidate
+		expected   int
+	}{
+		{nil, 0},
+		{[]db.PublishCandidate{c("u1", "a", 0), c("u2", "b", 1), c("u3", "c", 2)}, 3},
+		// same network
+		{[]db.PublishCandidate{c("u1", "a", 0), c("u2", "a", 1), c("u3", "a", 2)}, 1},
+		// same update id from different networks
+		{[]db.PublishCandidate{c("u1", "a", 0), c("u1", "b", 1), c("u3", "c", 2)}, 2},
+		// same time window
+		{[]db.PublishCandidate{c("u1", "a", 0), c("u2", "b", 0), c("u3", "c", 0)}, 1},
+	} {
+		if n := countIndependent(v.candidates, time.Hour); n != v.expected {
+			t.Errorf("%d: expected %d independent, got %d", i, v.expected, n)
+		}
+	}
+}
+
+// candidatesDB is a DBClient which only implements the methods used by
+// publishCandidate and unpublishCandidate.
+type candidatesDB struct {
+	db.DBClient
+	reporters  map[shared.SuggestionToken]db.SessionReporter
+	candidates []db.PublishCandidate
+	published  []db.Sample
+	notBlocked int
+	removed    bool
+}
+
+func (d *candidatesDB) GetSessionReporter(token shared.SuggestionToken) (db.SessionReporter, bool, error) {
+	r, ok := d.reporters[token]
+	return r, ok, nil
+}
+
+func (d *candidatesDB) InsertPublishCandidate(c db.PublishCandidate) error {
+	d.candidates = append(d.candidates, c)
+	return nil
+}
+
+func (d *candidatesDB) GetPublishCandidates(host, countryCode string, ASN int, notBlocked bool, since time.Time) ([]db.PublishCandidate, error) {
+	var candidates []db.PublishCandidate
+	for _, c := range d.candidates {
+		if c.CountryCode == countryCode && c.NotBlocked == notBlocked {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+func (d *candidatesDB) DeletePublishCandidates(host, countryCode string, ASN int) error {
+	var candidates []db.PublishCandidate
+	for _, c := range d.candidates {
+		if c.CountryCode != countryCode {
+			candidates = append(candidates, c)
+		}
+	}
+	d.candidates = candidates
+	return nil
+}
+
+func (d *candidatesDB) ResetNotBlocked(host, countryCode string, ASN int) error {
+	d.notBlocked = 0
+	var candidates []db.PublishCandidate
+	for _, c := range d.candidates {
+		if c.CountryCode != countryCode || !c.NotBlocked {
+			candidates = append(candidates, c)
+		}
+	}
+	d.candidates = candidates
+	return nil
+}
+
+func (d *candidatesDB) PublishHost(sample db.Sample) error {
+	d.published = append(d.published, sample)
+	return nil
+}
+
+func (d *candidatesDB) RecordNotBlocked(host db.HostListEntry, unpublishAfter int) (bool, error) {
+	d.notBlocked++
+	if d.notBlocked >= unpublishAfter {
+		d.removed = true
+	}
+	return d.removed, nil
+}
+
+func TestPublishCandidate(t *testing.T) {
+	d := &candidatesDB{
+		reporters: map[shared.SuggestionToken]db.SessionReporter{
+			"1": {UpdateID: "u1", AddrHash: "a"},
+			"2": {UpdateID: "u2", AddrHash: "a"},
+			"3": {UpdateID: "u3", AddrHash: "b"},
+			"4": {UpdateID: "u4", AddrHash: "c", Reverify: true},
+			"5": {AddrHash: "d"},
+		},
+	}
+	thresholds := reporterThresholds{def: 2, countries: map[string]int{"SE": 1}}
+	now := time.Now()
+	for i, v := range []struct {
+		token     shared.SuggestionToken
+		cc        string
+		hours     int
+		published bool
+	}{
+		{"1", "IR", 0, false},
+		{"2", "IR", 2, false}, // same reporter network as 1
+		{"5", "IR", 3, false}, // no update id
+		{"3", "IR", 4, true},
+		{"4", "SE", 0, false}, // re-verification
+		{"1", "SE", 0, true},
+	} {
+		sample := db.Sample{
+			Host:        "example.com",
+			CountryCode: v.cc,
+			ASN:         1,
+			Token:       v.token,
+			CreatedAt:   now.Add(time.Duration(v.hours) * time.Hour),
+		}
+		published, err := publishCandidate(d, thresholds, sample, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != v.published {
+			t.Errorf("%d: expected published %v, got %v", i, v.published, published)
+		}
+	}
+	if len(d.published) != 2 || len(d.candidates) != 0 {
+		t.Errorf("unexpected state: %d published, %d candidates", len(d.published), len(d.candidates))
+	}
+}
+
+func TestUnpublishCandidate(t *testing.T) {
+	d := &candidatesDB{
+		reporters: map[shared.SuggestionToken]db.SessionReporter{
+			"1": {UpdateID: "u1", AddrHash: "a"},
+			"2": {UpdateID: "u2", AddrHash: "a"},
+			"3": {UpdateID: "u3", AddrHash: "b"},
+			"4": {UpdateID: "u4", AddrHash: "c", Reverify: true},
+			"5": {AddrHash: "d"},
+			"6": {UpdateID: "u6", AddrHash: "e"},
+			"7": {UpdateID: "u7", AddrHash: "f"},
+		},
+	}
+	now := time.Now()
+	sample := func(token shared.SuggestionToken, hours int) db.Sample {
+		return db.Sample{
+			Host:        "example.com",
+			CountryCode: "SE",
+			ASN:         1,
+			Token:       token,
+			CreatedAt:   now.Add(time.Duration(hours) * time.Hour),
+		}
+	}
+	for i, v := range []struct {
+		token      shared.SuggestionToken
+		hours      int
+		blocked    bool
+		notBlocked int
+	}{
+		{"1", 0, false, 1},
+		{"2", 2, false, 1}, // same reporter network as 1
+		{"5", 3, false, 1}, // no update id
+		{"4", 4, false, 2}, // re-verifications count
+		{"3", 5, true, 0},  // a blocked verdict resets the series
+		{"1", 6, false, 1},
+		{"6", 8, false, 2},
+	} {
+		var err error
+		if v.blocked {
+			_, err = publishCandidate(d, reporterThresholds{def: 10}, sample(v.token, v.hours), now)
+		} else {
+			_, err = unpublishCandidate(d, 3, sample(v.token, v.hours), now)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.notBlocked != v.notBlocked || d.removed {
+			t.Errorf("%d: expected %d not blocked verdicts, got %d", i, v.notBlocked, d.notBlocked)
+		}
+	}
+	removed, err := unpublishCandidate(d, 3, sample("7", 10), now)
+	if err != nil || !removed {
+		t.Errorf("expected the host to be removed: %v", err)
+	}
+}
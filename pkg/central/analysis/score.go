@@ -1,16 +1,158 @@
 package analysis
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/alkasir/alkasir/pkg/central/db"
 	"github.com/alkasir/alkasir/pkg/measure"
 	"github.com/thomasf/lg"
 )
 
+// neutralScore is the score of a sample type without any evidence of
+// blocking, sample type scores range from neutralScore to 1.0.
+const neutralScore = 0.5
+
+// publishThreshold is the lowest combined session score that results in a
+// host being published. It is reached by any sample type with conclusive
+// evidence, or by several weaker ones, regardless of how many sample types
+// are registered or could be scored for the session.
+const publishThreshold = 1.0
+
 type scorer interface {
 	Score() float64
 }
 
+// certainScorer is implemented by scores which can be conclusive on their
+// own, such as a matched block page.
+type certainScorer interface {
+	Certain() bool
+}
+
+// scoreFunc compares the client and central samples of one sample type from
+// a single suggestion session.
+type scoreFunc func(client, central []db.Sample) (scorer, error)
+
+// sampleScorer is a registry entry for scoring one sample type.
+type sampleScorer struct {
+	SampleType string    // samples.type value
+	Weight     float64   // weight in the combined session verdict
+	Required   bool      // if true, sessions without client and central samples of this type are not analysed
+	Score      scoreFunc // compares client and central samples
+}
+
+// sampleScorers is the registry of all sample types which are used in session
+// analysis.
+var sampleScorers = []sampleScorer{
+	{SampleType: "HTTPHeader", Weight: 1.0, Required: true, Score: scoreHTTPHeaderSamples},
+	{SampleType: "DNSQuery", Weight: 1.0, Score: scoreDNSQuerySamples},
+	{SampleType: "TLSHandshake", Weight: 1.0, Score: scoreTLSHandshakeSamples},
+	{SampleType: "TCPConnect", Weight: 1.0, Score: scoreTCPConnectSamples},
+	{SampleType: "DNSInjection", Weight: 1.0, Score: scoreDNSInjectionSamples},
+}
+
+// getSampleScorer returns the registered scorer for sampleType, if any.
+func getSampleScorer(sampleType string) (sampleScorer, bool) {
+	for _, v := range sampleScorers {
+		if v.SampleType == sampleType {
+			return v, true
+		}
+	}
+	return sampleScorer{}, false
+}
+
+// Verdict is the weighted combination of all per sample type scores for a
+// suggestion session.
+type Verdict struct {
+	Scores  map[string]scorer  // scores by sample type
+	Weights map[string]float64 // weights by sample type
+}
+
+// evidence rescales a sample type score so that no evidence is 0.0 and
+// conclusive evidence is 1.0.
+func evidence(score float64) float64 {
+	e := (score - neutralScore) / (1 - neutralScore)
+	switch {
+	case e < 0:
+		return 0
+	case e > 1:
+		return 1
+	}
+	return e
+}
+
+// Score returns the sum of the weighted evidence of all sample type scores
+// capped at 1.0, or 1.0 if any score is conclusive. Sample types without
+// evidence of blocking add nothing, a session without any evidence of
+// blocking scores 0.0.
+func (v Verdict) Score() float64 {
+	for _, s := range v.Scores {
+		if c, ok := s.(certainScorer); ok && c.Certain() {
+			return 1.0
+		}
+	}
+	var sum float64
+	for k, s := range v.Scores {
+		sum += evidence(s.Score()) * v.Weights[k]
+	}
+	if sum > 1 {
+		return 1
+	}
+	return sum
+}
+
+// Publish returns true if the verdict is that the host should be published.
+func (v Verdict) Publish() bool {
+	return v.Score() >= publishThreshold
+}
+
+func (v Verdict) String() string {
+	var keys []string
+	for k := range v.Scores {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s:[%s]", k, v.Scores[k]))
+	}
+	return fmt.Sprintf("verdict:%.2f %s", v.Score(), strings.Join(parts, " "))
+}
+
+// scoreSession runs all registered scorers for which both client and central
+// samples are available and combines the results into a Verdict.
+func scoreSession(client, central map[string][]db.Sample) (Verdict, error) {
+	verdict := Verdict{
+		Scores:  make(map[string]scorer, 0),
+		Weights: make(map[string]float64, 0),
+	}
+	for _, ss := range sampleScorers {
+		if len(client[ss.SampleType]) < 1 || len(central[ss.SampleType]) < 1 {
+			if ss.Required {
+				return verdict, fmt.Errorf("missing %s, cannot analyse", ss.SampleType)
+			}
+			continue
+		}
+		score, err := ss.Score(client[ss.SampleType], central[ss.SampleType])
+		if err != nil {
+			if ss.Required {
+				return verdict, err
+			}
+			lg.Warningf("skipping %s score: %v", ss.SampleType, err)
+			continue
+		}
+		verdict.Scores[ss.SampleType] = score
+		verdict.Weights[ss.SampleType] = ss.Weight
+	}
+	if len(verdict.Scores) == 0 {
+		return verdict, errors.New("no scoreable samples")
+	}
+	return verdict, nil
+}
+
 type HTTPHeaderScore struct {
 	// based on http measurement status code field
 	StatusCode float64
@@ -18,20 +160,29 @@ type HTTPHeaderScore struct {
 	Redirects float64
 	// based on http measurement error field
 	Error float64
+	// based on the client response body matching a known block page
+	BlockPage float64
+	// id of the matched block page signature
+	BlockPageSignature uint64 `json:",omitempty"`
 }
 
 func (h HTTPHeaderScore) String() string {
 	return fmt.Sprintf(
-		"score:%.1f (statusCode:%.1f redirects:%.1f error:%.1f)",
-		h.Score(), h.StatusCode, h.Redirects, h.Error)
+		"score:%.1f (statusCode:%.1f redirects:%.1f error:%.1f blockPage:%.1f)",
+		h.Score(), h.StatusCode, h.Redirects, h.Error, h.BlockPage)
 }
 
+// Score returns the strongest signal, or 1.0 if a block page was matched.
 func (h *HTTPHeaderScore) Score() float64 {
-	result := 1.0
-	result = (result + h.StatusCode) / 2
-	result = (result + h.Error) / 2
-	result = (result + h.Redirects) / 2
-	return result
+	if h.Certain() {
+		return 1.0
+	}
+	return maxScore(h.StatusCode, maxScore(h.Error, h.Redirects))
+}
+
+// Certain returns true if the client was served a known block page.
+func (h *HTTPHeaderScore) Certain() bool {
+	return h.BlockPage >= 1.0
 }
 
 func scoreStatusCode(client, central int) float64 {
@@ -74,3 +225,23 @@ func scoreHTTPHeaders(client, central measure.HTTPHeaderResult) HTTPHeaderScore
 	}
 	return score
 }
+
+// scoreHTTPHeaderSamples scores the first client and central HTTPHeader
+// samples of a session.
+func scoreHTTPHeaderSamples(client, central []db.Sample) (scorer, error) {
+	var clientHeader, centralHeader measure.HTTPHeaderResult
+	if err := json.Unmarshal(client[0].Data, &clientHeader); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(central[0].Data, &centralHeader); err != nil {
+		return nil, err
+	}
+	score := scoreHTTPHeaders(clientHeader, centralHeader)
+	score.BlockPage = 0.5
+	if sig, ok := blockPages.Match(client[0].CountryCode, clientHeader.Body); ok {
+		lg.Infof("block page signature %d matched for %s", sig.ID, client[0].Host)
+		score.BlockPage = 1.0
+		score.BlockPageSignature = sig.ID
+	}
+	return &score, nil
+}
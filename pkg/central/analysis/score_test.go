@@ -0,0 +1,56 @@
+package analysis
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testScore float64
+
+func (s testScore) Score() float64 {
+	return float64(s)
+}
+
+func testVerdict(scores ...float64) Verdict {
+	v := Verdict{
+		Scores:  make(map[string]scorer, 0),
+		Weights: make(map[string]float64, 0),
+	}
+	for i, s := range scores {
+		k := fmt.Sprintf("type%d", i)
+		v.Scores[k] = testScore(s)
+		v.Weights[k] = 1.0
+	}
+	return v
+}
+
+func TestVerdictPublish(t *testing.T) {
+	neutral := func(n int) []float64 {
+		s := make([]float64, n)
+		for i := range s {
+			s[i] = neutralScore
+		}
+		return s
+	}
+	tests := []struct {
+		name    string
+		scores  []float64
+		publish bool
+	}{
+		{"conclusive", []float64{1.0}, true},
+		{"conclusive and neutral", append([]float64{1.0}, neutral(4)...), true},
+		{"conclusive and many neutral", append([]float64{1.0}, neutral(20)...), true},
+		{"conclusive and weak", []float64{1.0, 0.6, 0.6, 0.55}, true},
+		{"weak", []float64{0.7}, false},
+		{"weak and neutral", append([]float64{0.7}, neutral(4)...), false},
+		{"several weak", []float64{0.6, 0.6, 0.6, 0.55}, false},
+		{"several stronger", []float64{0.75, 0.75}, true},
+		{"neutral", neutral(5), false},
+	}
+	for _, tt := range tests {
+		v := testVerdict(tt.scores...)
+		if v.Publish() != tt.publish {
+			t.Errorf("%s: expected publish=%v, got %v", tt.name, tt.publish, v)
+		}
+	}
+}
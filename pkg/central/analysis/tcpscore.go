@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+// TCPConnectScore .
+type TCPConnectScore struct {
+	// based on the client failing to connect to an address and port that
+	// central could connect to
+	Addr float64
+	// based on the client failing to connect to any address on a port that
+	// central could connect to, used when client and central resolved
+	// different addresses
+	Port float64
+}
+
+func (t TCPConnectScore) String() string {
+	return fmt.Sprintf(
+		"score:%.1f (addr:%.1f port:%.1f)",
+		t.Score(), t.Addr, t.Port)
+}
+
+// Score returns the strongest signal since blocking of a single address is
+// enough to consider a host blocked.
+func (t *TCPConnectScore) Score() float64 {
+	return maxScore(t.Addr, t.Port)
+}
+
+// scoreTCPAttempt scores a failed client connection attempt to a destination
+// that central could connect to.
+func scoreTCPAttempt(a measure.TCPConnectAttempt) float64 {
+	if a.Success {
+		return 0.5
+	}
+	switch a.ErrorClass {
+	case measure.ErrorClassReset, measure.ErrorClassRefused,
+		measure.ErrorClassTimeout, measure.ErrorClassUnreachable:
+		return 1.0
+	}
+	return 0.7
+}
+
+func tcpDestination(a measure.TCPConnectAttempt) string {
+	return net.JoinHostPort(a.Addr, strconv.Itoa(a.Port))
+}
+
+// tcpFamily returns "ip4" or "ip6" depending on the address of a.
+func tcpFamily(a measure.TCPConnectAttempt) string {
+	if ip := net.ParseIP(a.Addr); ip != nil && ip.To4() == nil {
+		return "ip6"
+	}
+	return "ip4"
+}
+
+// scoreTCPConnects compares every client connection attempt with the central
+// attempts. Address families which the client has no route to, typically
+// IPv6, are left out since they say nothing about blocking.
+func scoreTCPConnects(client, central []measure.TCPConnectResult) TCPConnectScore {
+	noRoute := make(map[string]bool, 0)
+	for _, r := range client {
+		for _, a := range r.Connects {
+			if a.ErrorClass == measure.ErrorClassNoRoute {
+				noRoute[tcpFamily(a)] = true
+			}
+		}
+	}
+	centralDests := make(map[string]bool, 0)
+	centralPorts := make(map[int]bool, 0)
+	for _, r := range central {
+		for _, a := range r.Connects {
+			if a.Success && !noRoute[tcpFamily(a)] {
+				centralDests[tcpDestination(a)] = true
+				centralPorts[a.Port] = true
+			}
+		}
+	}
+	score := TCPConnectScore{
+		Addr: 0.5,
+		Port: 0.5,
+	}
+	clientPorts := make(map[int]bool, 0)
+	for _, r := range client {
+		for _, a := range r.Connects {
+			if noRoute[tcpFamily(a)] {
+				continue
+			}
+			if a.Success {
+				clientPorts[a.Port] = true
+			}
+			if centralDests[tcpDestination(a)] {
+				score.Addr = maxScore(score.Addr, scoreTCPAttempt(a))
+			}
+		}
+	}
+	for _, r := range client {
+		for _, a := range r.Connects {
+			if noRoute[tcpFamily(a)] {
+				continue
+			}
+			if centralPorts[a.Port] && !clientPorts[a.Port] {
+				score.Port = 0.7
+			}
+		}
+	}
+	return score
+}
+
+func decodeTCPConnectResults(samples []db.Sample) ([]measure.TCPConnectResult, error) {
+	var results []measure.TCPConnectResult
+	for _, s := range samples {
+		var r measure.TCPConnectResult
+		if err := json.Unmarshal(s.Data, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// scoreTCPConnectSamples scores all client and central TCPConnect samples of
+// a session.
+func scoreTCPConnectSamples(client, central []db.Sample) (scorer, error) {
+	clientResults, err := decodeTCPConnectResults(client)
+	if err != nil {
+		return nil, err
+	}
+	centralResults, err := decodeTCPConnectResults(central)
+	if err != nil {
+		return nil, err
+	}
+	score := scoreTCPConnects(clientResults, centralResults)
+	return &score, nil
+}
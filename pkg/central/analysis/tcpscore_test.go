@@ -0,0 +1,52 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+func TestScoreTCPConnects(t *testing.T) {
+	central := []measure.TCPConnectResult{
+		{Hostname: "example.com", Addrs: []string{"93.184.216.34"}, Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 80, Success: true},
+			{Addr: "93.184.216.34", Port: 443, Success: true},
+			{Addr: "2606:2800:220:1:248:1893:25c8:1946", Port: 443, Success: true},
+		}},
+	}
+	tests := []struct {
+		name    string
+		client  []measure.TCPConnectResult
+		blocked bool
+	}{
+		{"equal", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 80, Success: true},
+			{Addr: "93.184.216.34", Port: 443, Success: true},
+		}}}, false},
+		{"reset on 443", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 80, Success: true},
+			{Addr: "93.184.216.34", Port: 443, ErrorClass: measure.ErrorClassReset},
+		}}}, true},
+		{"ip timeout", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 80, ErrorClass: measure.ErrorClassTimeout},
+			{Addr: "93.184.216.34", Port: 443, ErrorClass: measure.ErrorClassTimeout},
+		}}}, true},
+		{"other address unreachable", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "10.0.0.1", Port: 80, ErrorClass: measure.ErrorClassUnreachable},
+		}}}, false},
+		{"no ipv6 route", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 443, Success: true},
+			{Addr: "2606:2800:220:1:248:1893:25c8:1946", Port: 443, ErrorClass: measure.ErrorClassNoRoute},
+		}}}, false},
+		{"no ipv6 route and ipv4 reset", []measure.TCPConnectResult{{Connects: []measure.TCPConnectAttempt{
+			{Addr: "93.184.216.34", Port: 443, ErrorClass: measure.ErrorClassReset},
+			{Addr: "2606:2800:220:1:248:1893:25c8:1946", Port: 443, ErrorClass: measure.ErrorClassNoRoute},
+		}}}, true},
+	}
+	for _, tt := range tests {
+		score := scoreTCPConnects(tt.client, central)
+		if blocked := score.Score() >= 1.0; blocked != tt.blocked {
+			t.Errorf("%s: expected blocked=%v, got %s", tt.name, tt.blocked, score)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+// TLSHandshakeScore .
+type TLSHandshakeScore struct {
+	// based on the client handshake failing when central handshakes succeeded
+	Error float64
+	// based on the client certificate chain differing from the central chains
+	Certificate float64
+}
+
+func (t TLSHandshakeScore) String() string {
+	return fmt.Sprintf(
+		"score:%.1f (error:%.1f certificate:%.1f)",
+		t.Score(), t.Error, t.Certificate)
+}
+
+// Score returns the strongest signal since either an interrupted handshake or
+// a substituted certificate is enough to consider a host blocked.
+func (t *TLSHandshakeScore) Score() float64 {
+	return maxScore(t.Error, t.Certificate)
+}
+
+func scoreTLSError(client measure.TLSHandshakeResult, centralOK bool) float64 {
+	if client.Error == "" || !centralOK {
+		return 0.5
+	}
+	switch client.ErrorClass {
+	case measure.ErrorClassReset, measure.ErrorClassEOF:
+		return 1.0
+	case measure.ErrorClassTimeout:
+		return 0.8
+	}
+	return 0.7
+}
+
+// tlsChains holds the leaf fingerprints and issuers seen by central.
+type tlsChains struct {
+	verified     bool
+	fingerprints map[string]bool
+	issuers      map[string]bool
+}
+
+func newTLSChains(results []measure.TLSHandshakeResult) tlsChains {
+	c := tlsChains{
+		fingerprints: make(map[string]bool, 0),
+		issuers:      make(map[string]bool, 0),
+	}
+	for _, r := range results {
+		if r.Error != "" || len(r.Certificates) == 0 {
+			continue
+		}
+		if r.VerifyError == "" {
+			c.verified = true
+		}
+		c.fingerprints[r.Certificates[0].Fingerprint] = true
+		c.issuers[r.Certificates[0].Issuer] = true
+	}
+	return c
+}
+
+func scoreTLSCertificate(client measure.TLSHandshakeResult, central tlsChains) float64 {
+	if client.Error != "" || len(client.Certificates) == 0 || len(central.fingerprints) == 0 {
+		return 0.5
+	}
+	leaf := client.Certificates[0]
+	if central.fingerprints[leaf.Fingerprint] {
+		return 0.5
+	}
+	// Large sites rotate between several valid certificates so a differing
+	// but valid chain is not a signal on its own.
+	if client.VerifyError == "" {
+		return 0.5
+	}
+	if central.verified && !central.issuers[leaf.Issuer] {
+		return 1.0
+	}
+	return 0.7
+}
+
+// scoreTLSHandshakes compares every client result with all central results.
+// The highest score for each component is kept.
+func scoreTLSHandshakes(client, central []measure.TLSHandshakeResult) TLSHandshakeScore {
+	centralOK := false
+	for _, r := range central {
+		if r.Error == "" {
+			centralOK = true
+		}
+	}
+	chains := newTLSChains(central)
+	score := TLSHandshakeScore{
+		Error:       0.5,
+		Certificate: 0.5,
+	}
+	for _, r := range client {
+		score.Error = maxScore(score.Error, scoreTLSError(r, centralOK))
+		score.Certificate = maxScore(score.Certificate, scoreTLSCertificate(r, chains))
+	}
+	return score
+}
+
+func decodeTLSHandshakeResults(samples []db.Sample) ([]measure.TLSHandshakeResult, error) {
+	var results []measure.TLSHandshakeResult
+	for _, s := range samples {
+		var r measure.TLSHandshakeResult
+		if err := json.Unmarshal(s.Data, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// scoreTLSHandshakeSamples scores all client and central TLSHandshake samples
+// of a session.
+func scoreTLSHandshakeSamples(client, central []db.Sample) (scorer, error) {
+	clientResults, err := decodeTLSHandshakeResults(client)
+	if err != nil {
+		return nil, err
+	}
+	centralResults, err := decodeTLSHandshakeResults(central)
+	if err != nil {
+		return nil, err
+	}
+	score := scoreTLSHandshakes(clientResults, centralResults)
+	return &score, nil
+}
@@ -0,0 +1,54 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+func TestScoreTLSHandshakes(t *testing.T) {
+	valid := measure.TLSCertificate{Fingerprint: "aa", Issuer: "CN=Real CA"}
+	central := []measure.TLSHandshakeResult{
+		{Hostname: "example.com", Certificates: []measure.TLSCertificate{valid}},
+	}
+	tests := []struct {
+		name    string
+		client  []measure.TLSHandshakeResult
+		blocked bool
+	}{
+		{"equal", []measure.TLSHandshakeResult{
+			{Certificates: []measure.TLSCertificate{valid}},
+		}, false},
+		{"other valid certificate", []measure.TLSHandshakeResult{
+			{Certificates: []measure.TLSCertificate{{Fingerprint: "bb", Issuer: "CN=Other CA"}}},
+		}, false},
+		{"substituted certificate", []measure.TLSHandshakeResult{
+			{
+				Certificates: []measure.TLSCertificate{{Fingerprint: "cc", Issuer: "CN=Filter CA"}},
+				VerifyError:  "x509: certificate signed by unknown authority",
+			},
+		}, true},
+		{"reset", []measure.TLSHandshakeResult{
+			{Error: "read: connection reset by peer", ErrorClass: measure.ErrorClassReset},
+		}, true},
+		{"eof", []measure.TLSHandshakeResult{
+			{Error: "EOF", ErrorClass: measure.ErrorClassEOF},
+		}, true},
+	}
+	for _, tt := range tests {
+		score := scoreTLSHandshakes(tt.client, central)
+		if blocked := score.Score() >= 1.0; blocked != tt.blocked {
+			t.Errorf("%s: expected blocked=%v, got %s", tt.name, tt.blocked, score)
+		}
+	}
+}
+
+func TestScoreTLSHandshakesCentralFailed(t *testing.T) {
+	score := scoreTLSHandshakes(
+		[]measure.TLSHandshakeResult{{Error: "EOF", ErrorClass: measure.ErrorClassEOF}},
+		[]measure.TLSHandshakeResult{{Error: "EOF", ErrorClass: measure.ErrorClassEOF}},
+	)
+	if score.Score() != 0.5 {
+		t.Errorf("expected neutral score, got %s", score)
+	}
+}
@@ -112,6 +112,14 @@ func GetHosts(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request)
 			relh.update()
 		}
 	}()
+	countryHosts := &countryWideHosts{dbclients: dbclients}
+	countryHosts.update()
+	go func() {
+		for range time.NewTicker(10 * time.Minute).C {
+			countryHosts.update()
+		}
+	}()
+	revisions := newBlocklistRevisions()
 	return func(w rest.ResponseWriter, r *rest.Request) {
 
 		// HANDLE USERIP BEGIN
@@ -178,9 +186,29 @@ func GetHosts(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request)
 			lg.Errorf("error persisting simplesample %v", ss)
 		}
 
-		err = w.WriteJson(shared.UpdateHostlistResponse{
-			Hosts: relh.fill(hosts),
-		})
+		hosts, scopes := mergeHostScopes(relh.fill(hosts), relh.fill(countryHosts.get(countryCode)))
+		current, prev, ok := revisions.Update(countryCode, ASN, hosts, scopes, req.Revision)
+		response := shared.UpdateHostlistResponse{
+			Revision:    current.ID,
+			CountryCode: countryCode,
+			ASN:         ASN,
+		}
+		if ok {
+			response.Delta = true
+			response.BaseRevision = prev.ID
+			response.Added, response.Removed = diffRevisions(prev, current)
+			response.Scopes = hostScopes(response.Added, current.Scopes)
+		} else {
+			response.Hosts = current.Hosts
+			response.Scopes = hostScopes(response.Hosts, current.Scopes)
+		}
+		if blocklistKeys != nil {
+			if err := signHostlistResponse(&response, blocklistKeys); err != nil {
+				apiError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		err = w.WriteJson(response)
 		if err != nil {
 			lg.Error(err)
 			return
@@ -191,6 +219,12 @@ func GetHosts(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request)
 
 // SuggestionToken JSON API method.
 func SuggestionToken(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
+	limiter := newRateLimiter("suggestions", *rateLimitSuggestions, *rateLimitSuggestionsBurst)
+	go func() {
+		for range time.NewTicker(time.Minute).C {
+			limiter.expire(time.Now())
+		}
+	}()
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		// HANDLE USERIP BEGIN
 		req := shared.SuggestionTokenRequest{}
@@ -248,6 +282,11 @@ func SuggestionToken(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.R
 			lg.Warningf("no ASN lookup result for IP: %s ", shared.SafeClean(IP.String()))
 		}
 
+		if !limiter.check(w, rateLimitKey(IP, ASN)) {
+			return
+		}
+		addrHash := reporterAddrHash(IP)
+
 		// reoslve ip to country code.
 		countryCode := dbclients.Maxmind.IP2CountryCode(IP)
 
@@ -275,12 +314,29 @@ func SuggestionToken(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.R
 			}
 		}
 		// start new submission token session
-		token := db.SessionTokens.New(URL)
+		token, err := dbclients.Tokens.New(URL)
+		if err != nil {
+			lg.Errorln(err)
+			apiError(w, "error #20261018-231547-CEST", http.StatusInternalServerError)
+			return
+		}
+		err = dbclients.DB.InsertSessionReporter(db.SessionReporter{
+			Token:    token,
+			UpdateID: req.UpdateID,
+			AddrHash: addrHash,
+			Reverify: req.Reverify,
+		})
+		if err != nil {
+			lg.Errorln(err)
+			apiError(w, "error #20261019-001208-CEST", http.StatusInternalServerError)
+			return
+		}
 
 		// create newclienttoken sample data
 		sample := shared.NewClientTokenSample{
 			URL:         URL,
 			CountryCode: req.CountryCode,
+			Reverify:    req.Reverify,
 		}
 		sampleData, err := json.Marshal(sample)
 		if err != nil {
@@ -318,12 +374,18 @@ func SuggestionToken(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.R
 			}
 		}
 
-		// queue central measurements
+		// queue central measurements, re-verifications of the same URL
+		// share them.
 		measurements, err := measure.DefaultMeasurements(req.URL)
 		if err != nil {
 			lg.Warningf("could not create standard measurements: %s", err.Error())
 		} else {
-			queueMeasurements(token, measurements...)
+			measure.EnableBodyCapture(measurements)
+			shareKey := ""
+			if req.Reverify {
+				shareKey = URL
+			}
+			queueMeasurements(token, shareKey, measurements...)
 		}
 
 		// write json response
@@ -343,12 +405,22 @@ func SuggestionToken(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.R
 }
 
 var clientSampleTypes = map[string]bool{
-	"HTTPHeader": true,
-	"DNSQuery":   true,
+	"HTTPHeader":   true,
+	"DNSQuery":     true,
+	"TLSHandshake": true,
+	"TCPConnect":   true,
+	"DNSInjection": true,
+	"TTLProbe":     true,
 }
 
 // StoreSample JSON API method.
 func StoreSample(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
+	limiter := newRateLimiter("samples", *rateLimitSamples, *rateLimitSamplesBurst)
+	go func() {
+		for range time.NewTicker(time.Minute).C {
+			limiter.expire(time.Now())
+		}
+	}()
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		// HANDLE USERIP BEGIN
 		req := shared.StoreSampleRequest{}
@@ -365,7 +437,12 @@ func StoreSample(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Reque
 		}
 
 		// get/validate suggestion session token.
-		tokenData, validToken := db.SessionTokens.Get(req.Token)
+		tokenData, validToken, err := dbclients.Tokens.Get(req.Token)
+		if err != nil {
+			lg.Errorln(err)
+			apiError(w, "error #20261018-231612-CEST", http.StatusInternalServerError)
+			return
+		}
 		if !validToken {
 			apiError(w, "invalid token", http.StatusBadRequest)
 			return
@@ -405,12 +482,39 @@ func StoreSample(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Reque
 			lg.Warningf("no ASN lookup result for IP: %s ", shared.SafeClean(IP.String()))
 		}
 
+		if !limiter.check(w, rateLimitKey(IP, ASN)) {
+			return
+		}
+
 		countryCode := dbclients.Maxmind.IP2CountryCode(IP)
 
 		req.ClientAddr = ""
 		IP = net.IPv4zero
 		// HANDLE USERIP END
 
+		// enforce the per token sample caps.
+		samples, size, err := dbclients.Tokens.AddSample(req.Token, len(req.Data))
+		if err != nil {
+			lg.Errorln(err)
+			apiError(w, "error #20261018-235104-CEST", http.StatusInternalServerError)
+			return
+		}
+		if (*maxTokenSamples > 0 && samples > *maxTokenSamples) ||
+			(*maxTokenSampleBytes > 0 && size > *maxTokenSampleBytes) {
+			rateLimitedRequests.WithLabelValues("token").Inc()
+			writeTooManyRequests(w, "sample limit reached for suggestion token", 0)
+			return
+		}
+
+		data := []byte(req.Data)
+		if req.SampleType == "TTLProbe" {
+			data, err = anonymizeTTLProbe(dbclients.Internet, data)
+			if err != nil {
+				apiError(w, "invalid TTLProbe data", http.StatusBadRequest)
+				return
+			}
+		}
+
 		// insert into db
 		{
 			err := dbclients.DB.InsertSample(db.Sample{
@@ -420,7 +524,7 @@ func StoreSample(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Reque
 				Type:        req.SampleType,
 				Origin:      "Client",
 				Token:       req.Token,
-				Data:        []byte(req.Data),
+				Data:        data,
 			})
 			if err != nil {
 				lg.Errorln(err.Error())
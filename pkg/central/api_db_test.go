@@ -20,10 +20,17 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
+	internetClient := newInternetClient()
+	if l, ok := internetClient.(*db.LocalInternet); ok {
+		if err := refreshLocalInternet(l); err != nil {
+			panic(err)
+		}
+	}
 	clients := db.Clients{
 		DB:       sqlDB,
-		Internet: db.NewInternetClient(redisPool),
+		Internet: internetClient,
 		Maxmind:  db.NewMaxmindClient(mmCountryDB, mmCityDB),
+		Tokens:   newTokenStore(),
 	}
 	mux, err := apiMux(clients)
 	if err != nil {
@@ -38,7 +45,7 @@ func TestMain(m *testing.M) {
 
 func TestRequestToken(t *testing.T) {
 	suggestion := client.NewSuggestion("http://google.com")
-	resp, err := suggestion.RequestToken(testclient, net.IPv4(85, 225, 60, 122), "SE")
+	resp, err := suggestion.RequestToken(testclient, net.IPv4(85, 225, 60, 122), "SE", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -49,7 +56,7 @@ func TestRequestToken(t *testing.T) {
 
 func TestSendSample(t *testing.T) {
 	suggestion := client.NewSuggestion("http://google2.com")
-	resp, err := suggestion.RequestToken(testclient, net.IPv4(85, 225, 60, 122), "SE")
+	resp, err := suggestion.RequestToken(testclient, net.IPv4(85, 225, 60, 122), "SE", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,237 @@
+package central
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/upgradebin"
+	"github.com/thomasf/lg"
+)
+
+// maxBlocklistRevisions is the number of revisions kept per country and ASN
+// for calculating deltas. Clients with older revisions gets the full list.
+const maxBlocklistRevisions = 50
+
+// blocklistRevision is a snapshot of the hosts list sent to clients.
+type blocklistRevision struct {
+	ID     string
+	Hosts  []string          // sorted
+	Scopes map[string]string // scope by host, one of the shared.HostScope constants
+}
+
+// blocklistRevisions keeps recent host list revisions in memory so that
+// clients can be sent only the changes since their last update.
+type blocklistRevisions struct {
+	sync.Mutex
+	items map[string][]blocklistRevision // by country code and ASN
+}
+
+func newBlocklistRevisions() *blocklistRevisions {
+	return &blocklistRevisions{
+		items: make(map[string][]blocklistRevision, 0),
+	}
+}
+
+// revisionID returns a content based revision id, the same list for the
+// same country and ASN always gets the same id which keeps revisions valid
+// across central restarts.
+func revisionID(key string, hosts []string, scopes map[string]string) string {
+	h := sha256.New()
+	fmt.Fprintln(h, key)
+	for _, v := range hosts {
+		fmt.Fprintln(h, v, scopes[v])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Update records hosts as the current list for countryCode and ASN and
+// returns the current revision and the previous revision with prevID, if it
+// is known.
+func (b *blocklistRevisions) Update(countryCode string, ASN int, hosts []string, scopes map[string]string, prevID string) (blocklistRevision, blocklistRevision, bool) {
+	key := fmt.Sprintf("%s-%d", strings.ToUpper(countryCode), ASN)
+	sorted := make([]string, len(hosts))
+	copy(sorted, hosts)
+	sort.Strings(sorted)
+	current := blocklistRevision{
+		ID:     revisionID(key, sorted, scopes),
+		Hosts:  sorted,
+		Scopes: scopes,
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	revisions := b.items[key]
+	var prev blocklistRevision
+	var found bool
+	for _, v := range revisions {
+		if v.ID == prevID {
+			prev, found = v, true
+		}
+	}
+	if len(revisions) == 0 || revisions[len(revisions)-1].ID != current.ID {
+		revisions = append(revisions, current)
+		if len(revisions) > maxBlocklistRevisions {
+			revisions = revisions[len(revisions)-maxBlocklistRevisions:]
+		}
+		b.items[key] = revisions
+	}
+	return current, prev, found
+}
+
+// diffRevisions returns the hosts added and removed between two revisions.
+// Hosts which changed scope are included in added.
+func diffRevisions(prev, next blocklistRevision) (added, removed []string) {
+	added, removed = diffHosts(prev.Hosts, next.Hosts)
+	var changed bool
+	for _, h := range next.Hosts {
+		if s, ok := prev.Scopes[h]; ok && s != next.Scopes[h] {
+			added = append(added, h)
+			changed = true
+		}
+	}
+	if changed {
+		sort.Strings(added)
+	}
+	return added, removed
+}
+
+// hostScopes returns the scopes of hosts.
+func hostScopes(hosts []string, scopes map[string]string) map[string]string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(hosts))
+	for _, h := range hosts {
+		result[h] = scopes[h]
+	}
+	return result
+}
+
+// diffHosts returns the hosts added and removed between two sorted lists.
+func diffHosts(prev, next []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(prev) || j < len(next) {
+		switch {
+		case i == len(prev):
+			added = append(added, next[j])
+			j++
+		case j == len(next):
+			removed = append(removed, prev[i])
+			i++
+		case prev[i] == next[j]:
+			i++
+			j++
+		case prev[i] < next[j]:
+			removed = append(removed, prev[i])
+			i++
+		default:
+			added = append(added, next[j])
+			j++
+		}
+	}
+	return added, removed
+}
+
+// blocklistKeys signs hosts list responses, central does not start without
+// them.
+var blocklistKeys *upgradebin.KeyPair
+
+// loadBlocklistKeys reads the blocklist signing key pair from PEM files.
+func loadBlocklistKeys(privFile, pubFile string) (*upgradebin.KeyPair, error) {
+	privPem, err := ioutil.ReadFile(privFile)
+	if err != nil {
+		return nil, err
+	}
+	pubPem, err := ioutil.ReadFile(pubFile)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := upgradebin.DecodeNamedKeys(upgradebin.BlocklistKeyName, privPem, pubPem)
+	if err != nil {
+		return nil, err
+	}
+	// the second half of an ed25519 private key is its public key
+	if !bytes.Equal(keys.Private[32:], keys.Public[:]) {
+		return nil, fmt.Errorf("%s is not the public key of %s", pubFile, privFile)
+	}
+	return keys, nil
+}
+
+// signHostlistResponse timestamps and signs resp using keys.
+func signHostlistResponse(resp *shared.UpdateHostlistResponse, keys *upgradebin.KeyPair) error {
+	resp.Timestamp = time.Now().UTC().Truncate(time.Second)
+	data, err := resp.SignedData()
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(keys.Private, data)
+	resp.Signature = base64.RawURLEncoding.EncodeToString(sig[:])
+	return nil
+}
+
+var (
+	countryWideFraction     = flag.Float64("countryWideFraction", 0.3, "fraction of the ASNs in a country a host has to be published for to be blocked country wide")
+	countryWideMinASNs      = flag.Int("countryWideMinASNs", 3, "minimum number of ASNs a host has to be published for to be blocked country wide")
+	countryWideActivePeriod = flag.Duration("countryWideActivePeriod", 30*24*time.Hour, "clients which requested host lists within this period counts their ASN as part of the country")
+)
+
+// countryWideHosts holds the hosts which are inferred to be blocked in whole
+// countries, by country code.
+type countryWideHosts struct {
+	sync.RWMutex
+	items     map[string][]string
+	dbclients db.Clients
+}
+
+func (c *countryWideHosts) update() {
+	lg.V(19).Infoln("updating country wide hosts..")
+	hosts, err := c.dbclients.DB.GetCountryWideHosts(
+		*countryWideFraction, *countryWideMinASNs,
+		time.Now().Add(-*countryWideActivePeriod))
+	if err != nil {
+		lg.Errorf("could not update country wide hosts: %v", err)
+		return
+	}
+	c.Lock()
+	c.items = hosts
+	c.Unlock()
+}
+
+func (c *countryWideHosts) get(countryCode string) []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.items[countryCode]
+}
+
+// mergeHostScopes returns the union of ASN specific and country wide hosts
+// and the scope of each host. Hosts which are published for the clients ASN
+// keeps the ASN scope.
+func mergeHostScopes(asnHosts, countryHosts []string) ([]string, map[string]string) {
+	scopes := make(map[string]string, len(asnHosts)+len(countryHosts))
+	var hosts []string
+	for _, h := range asnHosts {
+		if _, ok := scopes[h]; !ok {
+			scopes[h] = shared.HostScopeASN
+			hosts = append(hosts, h)
+		}
+	}
+	for _, h := range countryHosts {
+		if _, ok := scopes[h]; !ok {
+			scopes[h] = shared.HostScopeCountry
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, scopes
+}
@@ -0,0 +1,173 @@
+package central
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/upgradebin"
+)
+
+func TestBlocklistRevisions(t *testing.T) {
+	b := newBlocklistRevisions()
+
+	first, _, ok := b.Update("se", 1, []string{"b.com", "a.com"}, nil, "")
+	if ok {
+		t.Fatal("expected no previous revision for empty revision id")
+	}
+	if !reflect.DeepEqual(first.Hosts, []string{"a.com", "b.com"}) {
+		t.Errorf("hosts not sorted: %v", first.Hosts)
+	}
+
+	same, prev, ok := b.Update("se", 1, []string{"a.com", "b.com"}, nil, first.ID)
+	if !ok || same.ID != first.ID || prev.ID != first.ID {
+		t.Errorf("expected unchanged revision, got %s (prev %s, %v)", same.ID, prev.ID, ok)
+	}
+
+	second, prev, ok := b.Update("se", 1, []string{"a.com", "c.com"}, nil, first.ID)
+	if !ok {
+		t.Fatal("expected previous revision to be known")
+	}
+	if second.ID == first.ID {
+		t.Error("expected a new revision id")
+	}
+	added, removed := diffHosts(prev.Hosts, second.Hosts)
+	if !reflect.DeepEqual(added, []string{"c.com"}) || !reflect.DeepEqual(removed, []string{"b.com"}) {
+		t.Errorf("unexpected delta +%v -%v", added, removed)
+	}
+
+	if _, _, ok := b.Update("se", 2, []string{"a.com", "b.com"}, nil, first.ID); ok {
+		t.Error("revisions must not be shared between ASNs")
+	}
+	if _, _, ok := b.Update("se", 1, []string{"a.com"}, nil, "unknown"); ok {
+		t.Error("expected unknown revision to not be found")
+	}
+}
+
+func TestBlocklistRevisionsLimit(t *testing.T) {
+	b := newBlocklistRevisions()
+	first, _, _ := b.Update("se", 1, []string{"0"}, nil, "")
+	for i := 1; i <= maxBlocklistRevisions; i++ {
+		b.Update("se", 1, []string{string(rune('a' + i%26)), string(rune('0' + i))}, nil, "")
+	}
+	if _, _, ok := b.Update("se", 1, []string{"x"}, nil, first.ID); ok {
+		t.Error("expected the oldest revision to be expired")
+	}
+}
+
+func TestBlocklistRevisionScopes(t *testing.T) {
+	b := newBlocklistRevisions()
+	hosts, scopes := mergeHostScopes([]string{"a.com", "b.com"}, []string{"b.com", "c.com"})
+	if !reflect.DeepEqual(scopes, map[string]string{
+		"a.com": shared.HostScopeASN,
+		"b.com": shared.HostScopeASN,
+		"c.com": shared.HostScopeCountry,
+	}) {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	first, _, _ := b.Update("se", 1, hosts, scopes, "")
+
+	// a.com becomes country wide
+	hosts, scopes = mergeHostScopes([]string{"b.com"}, []string{"a.com", "c.com"})
+	second, prev, ok := b.Update("se", 1, hosts, scopes, first.ID)
+	if !ok {
+		t.Fatal("expected previous revision to be known")
+	}
+	if second.ID == first.ID {
+		t.Error("expected a scope change to create a new revision")
+	}
+	added, removed := diffRevisions(prev, second)
+	if !reflect.DeepEqual(added, []string{"a.com"}) || len(removed) != 0 {
+		t.Errorf("unexpected delta +%v -%v", added, removed)
+	}
+	if s := hostScopes(added, second.Scopes); s["a.com"] != shared.HostScopeCountry {
+		t.Errorf("unexpected scopes for added hosts: %v", s)
+	}
+}
+
+func TestDiffHosts(t *testing.T) {
+	added, removed := diffHosts(nil, []string{"a.com"})
+	if !reflect.DeepEqual(added, []string{"a.com"}) || len(removed) != 0 {
+		t.Errorf("unexpected delta +%v -%v", added, removed)
+	}
+	added, removed = diffHosts([]string{"a.com", "b.com"}, nil)
+	if len(added) != 0 || !reflect.DeepEqual(removed, []string{"a.com", "b.com"}) {
+		t.Errorf("unexpected delta +%v -%v", added, removed)
+	}
+}
+
+func TestSignHostlistResponse(t *testing.T) {
+	priv, pub := upgradebin.GenerateKeys(rand.Reader)
+	resp := shared.UpdateHostlistResponse{
+		Hosts:    []string{"a.com", "b.com"},
+		Revision: "abc",
+		ASN:      3301,
+	}
+	if err := signHostlistResponse(&resp, &upgradebin.KeyPair{Private: priv, Public: pub}); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Timestamp.IsZero() {
+		t.Error("expected a signature timestamp")
+	}
+	sig, err := upgradebin.DecodeSignature(resp.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := resp.SignedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		t.Error("signature does not verify")
+	}
+	for _, modify := range []func(r *shared.UpdateHostlistResponse){
+		func(r *shared.UpdateHostlistResponse) { r.Hosts = append(r.Hosts, "c.com") },
+		func(r *shared.UpdateHostlistResponse) { r.ASN = 1257 },
+	} {
+		modified := resp
+		modify(&modified)
+		data, err = modified.SignedData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ed25519.Verify(pub, data, sig) {
+			t.Errorf("signature verifies for modified hosts list %+v", modified)
+		}
+	}
+}
+
+func TestLoadBlocklistKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) string {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	priv, pub := upgradebin.GenerateKeys(rand.Reader)
+	_, otherPub := upgradebin.GenerateKeys(rand.Reader)
+	privPem, pubPem := upgradebin.EncodeNamedKeys(upgradebin.BlocklistKeyName, priv, pub)
+	_, otherPubPem := upgradebin.EncodeNamedKeys(upgradebin.BlocklistKeyName, priv, otherPub)
+	upgradesPrivPem, upgradesPubPem := upgradebin.EncodeKeys(priv, pub)
+
+	keys, err := loadBlocklistKeys(write("priv.pem", privPem), write("pub.pem", pubPem))
+	if err != nil || *keys.Public != *pub {
+		t.Errorf("could not load blocklist keys: %v", err)
+	}
+	if _, err := loadBlocklistKeys(write("priv.pem", privPem), write("pub.pem", otherPubPem)); err == nil {
+		t.Error("expected mismatched key pair to fail")
+	}
+	if _, err := loadBlocklistKeys(write("priv.pem", upgradesPrivPem), write("pub.pem", upgradesPubPem)); err == nil {
+		t.Error("expected upgrade keys to fail")
+	}
+}
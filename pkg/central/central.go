@@ -3,7 +3,9 @@ package central
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof" // register pprof
 	"os"
@@ -35,9 +37,25 @@ var (
 	exportApiSecretKey  = flag.String("exportAPISecretKey", "", "Secret key for export api auth")
 	monitorBindAddr     = flag.String("monitorAddr", "localhost:8081", "port to bind monitor server to")
 	datadirFlag         = flag.String("datadir", "", "directory to store data")
+	blocklistPrivKey    = flag.String("blocklistPrivKey", "", "path to private key file for signing hosts lists")
+	blocklistPubKey     = flag.String("blocklistPubKey", "", "path to public key file for signing hosts lists")
+	internetBackend     = flag.String("internetBackend", "redis", "ip to asn lookup backend, redis or local (in process, no redis server needed)")
+	ip2asnFile          = flag.String("ip2asnFile", "", "MRT dump, routeviews prefix2as file or MaxMind ASN mmdb for the local internet backend, defaults to the latest RIPE RIS dump")
+	tokenStoreBackend   = flag.String("tokenStore", "memory", "suggestion token store, memory, redis or db (redis and db can be shared by several central processes)")
+	tokenTTL            = flag.Duration("tokenTTL", 30*time.Minute, "lifetime of suggestion tokens")
 	datadir             string
 )
 
+// abuse control flags
+var (
+	rateLimitSuggestions      = flag.Float64("rateLimitSuggestions", 60, "suggestion tokens per hour allowed for each client address and ASN, 0 disables the limit")
+	rateLimitSuggestionsBurst = flag.Int("rateLimitSuggestionsBurst", 10, "suggestion tokens allowed at once for each client address and ASN")
+	rateLimitSamples          = flag.Float64("rateLimitSamples", 1200, "samples per hour allowed for each client address and ASN, 0 disables the limit")
+	rateLimitSamplesBurst     = flag.Int("rateLimitSamplesBurst", 100, "samples allowed at once for each client address and ASN")
+	maxTokenSamples           = flag.Int("maxTokenSamples", 100, "maximum number of samples for a suggestion token, 0 disables the limit")
+	maxTokenSampleBytes       = flag.Int64("maxTokenSampleBytes", 4<<20, "maximum total size of the samples for a suggestion token, 0 disables the limit")
+)
+
 var mmCountryDB, mmCityDB *maxminddb.Reader
 
 // Init initializes the server.
@@ -70,7 +88,33 @@ func Init() error {
 		lg.Fatalln(err)
 		return err
 	}
-	redisPool = newRedisPool(*redisServer, *redisPassword)
+	reporterHashKey, err = sqlDB.GetReporterHashKey()
+	if err != nil {
+		lg.Fatalln(err)
+		return err
+	}
+	switch *internetBackend {
+	case "redis":
+	case "local":
+	default:
+		lg.Fatalf("unknown internetBackend: %s", *internetBackend)
+	}
+	switch *tokenStoreBackend {
+	case "memory", "redis", "db":
+	default:
+		lg.Fatalf("unknown tokenStore: %s", *tokenStoreBackend)
+	}
+	if *internetBackend == "redis" || *tokenStoreBackend == "redis" {
+		redisPool = newRedisPool(*redisServer, *redisPassword)
+	}
+
+	if *blocklistPrivKey == "" || *blocklistPubKey == "" {
+		lg.Fatal("blocklistPrivKey and blocklistPubKey are required, clients reject unsigned hosts lists")
+	}
+	blocklistKeys, err = loadBlocklistKeys(*blocklistPrivKey, *blocklistPubKey)
+	if err != nil {
+		lg.Fatalf("could not load blocklist signing keys: %v", err)
+	}
 
 	internet.SetDataDir(filepath.Join(datadir, "internet"))
 
@@ -113,54 +157,69 @@ func Run() {
 		_ = shared.GetPublicIPAddr()
 	}()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		lg.V(2).Infoln("Loading recent sessions from postgres...")
-		recents, err := sqlDB.RecentSuggestionSessions(20000)
-		if err != nil {
-			lg.Fatal(err)
-		}
-		db.SessionTokens.Reset(recents)
-		lg.V(2).Infof("Loaded %d sessions from postgres...", len(recents))
-		lg.Flush()
-
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		conn := redisPool.Get()
-		defer conn.Close()
-		lg.V(2).Infoln("BGPDump refresh started...")
-		n, err := internet.RefreshBGPDump(conn)
-		lg.V(2).Infof("BGPDump refresh ended, %d items added.", n)
-		lg.Flush()
-		if err != nil {
-			if *offline {
-				lg.Infoln("offline", err)
-			} else {
+	tokenStore := newTokenStore()
+	if memoryTokens, ok := tokenStore.(*db.MemoryTokenStore); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lg.V(2).Infoln("Loading recent sessions from postgres...")
+			recents, err := sqlDB.RecentSuggestionSessions(20000)
+			if err != nil {
 				lg.Fatal(err)
 			}
-		}
-	}()
+			memoryTokens.Reset(recents)
+			lg.V(2).Infof("Loaded %d sessions from postgres...", len(recents))
+			lg.Flush()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		conn := redisPool.Get()
-		defer conn.Close()
-		lg.V(2).Infoln("CIDRReport refresh started...")
-		n, err := internet.RefreshCIDRReport(conn)
-		lg.V(2).Infof("CIDRReport refresh ended, %d items added", n)
-		if err != nil {
-			if *offline {
-				lg.Infoln("offline", err)
-			} else {
+		}()
+	}
+
+	internetClient := newInternetClient()
+	if localInternet, ok := internetClient.(*db.LocalInternet); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := refreshLocalInternet(localInternet)
+			if err != nil {
 				lg.Fatal(err)
 			}
-		}
-	}()
+		}()
+	} else {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := redisPool.Get()
+			defer conn.Close()
+			lg.V(2).Infoln("BGPDump refresh started...")
+			n, err := internet.RefreshBGPDump(conn)
+			lg.V(2).Infof("BGPDump refresh ended, %d items added.", n)
+			lg.Flush()
+			if err != nil {
+				if *offline {
+					lg.Infoln("offline", err)
+				} else {
+					lg.Fatal(err)
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := redisPool.Get()
+			defer conn.Close()
+			lg.V(2).Infoln("CIDRReport refresh started...")
+			n, err := internet.RefreshCIDRReport(conn)
+			lg.V(2).Infof("CIDRReport refresh ended, %d items added", n)
+			if err != nil {
+				if *offline {
+					lg.Infoln("offline", err)
+				} else {
+					lg.Fatal(err)
+				}
+			}
+		}()
+	}
 	wg.Wait()
 
 	// start signal handling
@@ -172,13 +231,13 @@ func Run() {
 		wg.Done()
 	}()
 
-	internetClient := db.NewInternetClient(redisPool)
 	maxmindClient := db.NewMaxmindClient(mmCountryDB, mmCityDB)
 
 	clients := db.Clients{
 		DB:       sqlDB,
 		Internet: internetClient,
 		Maxmind:  maxmindClient,
+		Tokens:   tokenStore,
 	}
 
 	// start http json api server
@@ -219,14 +278,102 @@ func Run() {
 	}(*exportApiBindAddr, clients)
 
 	go analysis.StartAnalysis(clients)
+	go startStatsRefresher(clients, *statsRefreshInterval)
+	go startWebhookDispatcher(clients, *webhookInterval)
 	startMeasurer(clients)
 
 	wg.Wait()
 }
 
+// newInternetClient returns the InternetClient selected by the
+// internetBackend flag.
+func newInternetClient() db.InternetClient {
+	if *internetBackend == "local" {
+		return db.NewLocalInternetClient()
+	}
+	return db.NewInternetClient(redisPool)
+}
+
+// newTokenStore returns the suggestion token store selected by the
+// tokenStore flag and starts expiring tokens when the store needs it.
+func newTokenStore() db.TokenStore {
+	switch *tokenStoreBackend {
+	case "redis":
+		return db.NewRedisTokenStore(redisPool, *tokenTTL)
+	case "db":
+		s := db.NewDBTokenStore(sqlDB, *tokenTTL)
+		go func() {
+			for range time.Tick(time.Minute) {
+				n, err := s.Expire()
+				if err != nil {
+					lg.Errorln(err)
+					continue
+				}
+				lg.V(3).Infof("expired %d sessions", n)
+			}
+		}()
+		return s
+	}
+	s := db.NewMemoryTokenStore(*tokenTTL)
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			s.Expire()
+		}
+	}()
+	return s
+}
+
+// refreshLocalInternet loads the ip2asnFile or the latest RIPE RIS dump into
+// l. Dumps are only downloaded when not offline, a previously downloaded
+// dump is used if available. The previous day's dump is used if today's
+// dump can not be downloaded or loaded.
+func refreshLocalInternet(l *db.LocalInternet) error {
+	if *ip2asnFile != "" {
+		fi, err := os.Stat(*ip2asnFile)
+		if err != nil {
+			return err
+		}
+		return loadLocalInternet(l, *ip2asnFile, fi.ModTime())
+	}
+	for _, b := range []internet.BGPDump{
+		{Date: time.Now()},
+		{Date: time.Now().Add(-time.Duration(time.Hour * 24))},
+	} {
+		if !*offline {
+			if err := b.Download(); err != nil {
+				lg.Warningf("could not download bgp dump of %s: %v", b.Date.Format("2006-01-02"), err)
+			}
+		}
+		if !b.IsDownloaded() {
+			continue
+		}
+		if err := loadLocalInternet(l, b.Path(), b.Date); err != nil {
+			lg.Warningf("could not load bgp dump %s: %v", b.Path(), err)
+			continue
+		}
+		return nil
+	}
+	return errors.New("no bgp dump available, use -ip2asnFile when offline")
+}
+
+// loadLocalInternet loads the prefix file at path into l.
+func loadLocalInternet(l *db.LocalInternet, path string, date time.Time) error {
+	lg.V(2).Infof("Loading ip2asn table from %s...", path)
+	trie, err := db.LoadPrefixFile(path)
+	if err != nil {
+		return err
+	}
+	l.Replace(trie, date)
+	lg.V(2).Infof("Loaded %d networks into the ip2asn table", trie.Len())
+	return nil
+}
+
 var (
 	sqlDB                   *db.DB
 	offline                 = flag.Bool("offline", false, "don't require an internet connection (dev mode)")
+	dbBackend               = flag.String("db", "postgres", "database backend, postgres or sqlite")
+	sqlitePath              = flag.String("sqlitePath", "", "sqlite database file, defaults to central.sqlite in datadir")
+	dbMigrate               = flag.Bool("dbMigrate", true, "apply pending postgres schema migrations on startup")
 	pgConnString            = flag.String("pgconn", "user=alkasir_central password=alkasir_central dbname=alkasir_central port=39558 sslmode=disable", "postgresql connection string")
 	pgMaxIdleConnections    = flag.Int("pg_max_idle_conn", 15, "Maximum idle postgres connections")
 	pgMaxOpenConnections    = flag.Int("pg_max_open_conn", 100, "Maximum active postgres connections")
@@ -237,6 +384,25 @@ var (
 // InitDB opens a connection to the database.
 func InitDB() error {
 	var err error
+	switch *dbBackend {
+	case "postgres":
+	case "sqlite":
+		path := *sqlitePath
+		if path == "" {
+			if err := os.MkdirAll(datadir, 0700); err != nil {
+				return err
+			}
+			path = filepath.Join(datadir, "central.sqlite")
+		}
+		sqlDB, err = db.OpenSQLite(path)
+		if err != nil {
+			return err
+		}
+		lg.Infoln("Opened sqlite database", path)
+		return nil
+	default:
+		return fmt.Errorf("unknown db backend: %s", *dbBackend)
+	}
 	sqlDB, err = db.Open(*pgConnString)
 	if err != nil {
 		return err
@@ -250,6 +416,13 @@ func InitDB() error {
 	sqlDB.SetMaxIdleConns(*pgMaxIdleConnections)
 	sqlDB.SetMaxOpenConns(*pgMaxOpenConnections)
 	lg.Infoln("Successfully connected to the database")
+	if *dbMigrate {
+		applied, err := sqlDB.Migrate()
+		if err != nil {
+			return err
+		}
+		lg.Infof("Applied %d schema migrations", len(applied))
+	}
 	return nil
 }
 
@@ -268,7 +441,9 @@ func startMonitoring(addr string) {
 	prometheus.MustRegister(redisMaxConn)
 
 	http.Handle("/metrics", prometheus.Handler())
-	redisMaxConn.Set(float64(redisPool.MaxActive))
+	if redisPool != nil {
+		redisMaxConn.Set(float64(redisPool.MaxActive))
+	}
 	go func() {
 		tick := time.NewTicker(1 * time.Second)
 		for range tick.C {
@@ -0,0 +1,54 @@
+package central
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/thomasf/internet"
+)
+
+func TestRefreshLocalInternet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-internet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	internet.SetDataDir(dir)
+	defer func(v bool) { *offline = v }(*offline)
+	*offline = true
+
+	l := db.NewLocalInternetClient()
+	if err := refreshLocalInternet(l); err == nil {
+		t.Fatal("expected an error without dumps")
+	}
+
+	// today's dump is broken, yesterday's is used.
+	today := internet.BGPDump{Date: time.Now()}
+	yesterday := internet.BGPDump{Date: time.Now().Add(-24 * time.Hour)}
+	for _, v := range []struct {
+		dump internet.BGPDump
+		data string
+	}{
+		{today, "1.0.0.0 24 x\n"},
+		{yesterday, "1.0.0.0\t24\t13335\n"},
+	} {
+		if err := os.MkdirAll(filepath.Dir(v.dump.Path()), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(v.dump.Path(), []byte(v.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := refreshLocalInternet(l); err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.IP2ASN(net.ParseIP("1.0.0.1"))
+	if err != nil || r == nil || r.ASN != 13335 {
+		t.Errorf("unexpected lookup result: %+v %v", r, err)
+	}
+}
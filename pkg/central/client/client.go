@@ -177,12 +177,13 @@ type Suggestion struct {
 
 // RequestToken requests a suggestion session from central. On success the
 // Suggestion is updated the token id. Check SuggestionTokenResponse.Ok for
-// validity.
-func (s *Suggestion) RequestToken(client *Client, clientAddr net.IP, countryCode string) (shared.SuggestionTokenResponse, error) {
+// validity. updateID is the last update id sent by the client, if any.
+func (s *Suggestion) RequestToken(client *Client, clientAddr net.IP, countryCode, updateID string) (shared.SuggestionTokenResponse, error) {
 	r, err := client.CreateSuggestionToken(shared.SuggestionTokenRequest{
 		URL:         s.URL,
 		ClientAddr:  clientAddr,
 		CountryCode: countryCode,
+		UpdateID:    updateID,
 	})
 	if err != nil {
 		return r, err
@@ -195,8 +196,12 @@ func (s *Suggestion) RequestToken(client *Client, clientAddr net.IP, countryCode
 }
 
 var supportedTypes = map[sampletypes.SampleType]bool{
-	sampletypes.DNSQuery:   true,
-	sampletypes.HTTPHeader: true,
+	sampletypes.DNSQuery:     true,
+	sampletypes.HTTPHeader:   true,
+	sampletypes.TLSHandshake: true,
+	sampletypes.TCPConnect:   true,
+	sampletypes.DNSInjection: true,
+	sampletypes.TTLProbe:     true,
 }
 
 func (s *Suggestion) AddMeasurement(m measure.Measurement) error {
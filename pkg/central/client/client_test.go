@@ -67,7 +67,7 @@ func TestRequestTokenSuccess(t *testing.T) {
 
 	client := NewClient(ts.URL, nil)
 	suggestion := NewSuggestion("http://data")
-	tokenResponse, err := suggestion.RequestToken(client, net.IPv4(130, 234, 12, 2), "SE")
+	tokenResponse, err := suggestion.RequestToken(client, net.IPv4(130, 234, 12, 2), "SE", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -105,7 +105,7 @@ func TestRequestTokenFail(t *testing.T) {
 	client := NewClient(ts.URL, nil)
 
 	suggestion := NewSuggestion("http://data")
-	tokenResponse, err := suggestion.RequestToken(client, net.IPv4(130, 234, 12, 2), "SE")
+	tokenResponse, err := suggestion.RequestToken(client, net.IPv4(130, 234, 12, 2), "SE", "")
 	if err != nil {
 		t.Error(err)
 	}
@@ -169,7 +169,7 @@ func TestSubmitSample(t *testing.T) {
 
 	// create session token
 	{
-		tokenResponse, err := s.RequestToken(client, net.IPv4(133, 23, 123, 21), "US")
+		tokenResponse, err := s.RequestToken(client, net.IPv4(133, 23, 123, 21), "US", "")
 		if err != nil || !tokenResponse.Ok {
 			t.Fail()
 		}
@@ -5,16 +5,66 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// Export api scopes, they are granted to credentials and required by the
+// export api routes.
+const (
+	ScopeBlockedRead = "blocked:read"  // the publish log of blocked hosts
+	ScopeSamplesRead = "samples:read"  // samples and analysis results
+	ScopeRawDataRead = "raw-data:read" // the data fields of samples
+	ScopeStatsRead   = "stats:read"    // aggregated statistics
+)
+
+// ExportAPIScopes lists all known export api scopes.
+var ExportAPIScopes = []string{ScopeBlockedRead, ScopeSamplesRead, ScopeRawDataRead, ScopeStatsRead}
+
+// ParseScopes parses a comma separated list of scopes and returns an error
+// for unknown scopes.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+loop:
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		for _, known := range ExportAPIScopes {
+			if v == known {
+				scopes = append(scopes, v)
+				continue loop
+			}
+		}
+		return nil, fmt.Errorf("unknown scope: %s", v)
+	}
+	return scopes, nil
+}
+
 // APICredentials is used for export api jwt authentication.
 type APICredentials struct {
+	ID           int
 	Username     string
 	PasswordHash []byte
 	Salt         []byte
 	Enabled      bool
+	Scopes       []string
+	Generation   int // increased when the password is rotated, older tokens are rejected
+	CreatedAt    time.Time
+}
+
+// HasScope returns true if the credentials are granted scope.
+func (a *APICredentials) HasScope(scope string) bool {
+	for _, v := range a.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
 }
 
 const passwordSaltLen = 16
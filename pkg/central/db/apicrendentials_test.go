@@ -33,3 +33,16 @@ func TestAPICredentials(t *testing.T) {
 	}
 
 }
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes(" blocked:read, raw-data:read,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 2 || scopes[0] != ScopeBlockedRead || scopes[1] != ScopeRawDataRead {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	if _, err := ParseScopes("blocked:write"); err == nil {
+		t.Error("expected unknown scope error")
+	}
+}
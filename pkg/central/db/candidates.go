@@ -0,0 +1,210 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+// SessionReporter mirrors the session_reporters table, it identifies the
+// reporter of a suggestion session without storing its address.
+type SessionReporter struct {
+	Token     shared.SuggestionToken
+	UpdateID  string // the weekly update id of the client, if sent
+	AddrHash  string // keyed hash of the network of the client address
+	Reverify  bool   // true for re-verification of an already listed host
+	CreatedAt time.Time
+}
+
+// PublishCandidate mirrors the publish_candidates table, a session which
+// found the host blocked but is not yet published, or which found a published
+// host not blocked.
+type PublishCandidate struct {
+	ID          uint64
+	Host        string
+	CountryCode string
+	ASN         int
+	Token       shared.SuggestionToken
+	UpdateID    string
+	AddrHash    string
+	NotBlocked  bool      // true if the session found the host not blocked
+	CreatedAt   time.Time // when the session was started
+}
+
+// InsertSessionReporter inserts r into the session_reporters table.
+func (d *DB) InsertSessionReporter(r SessionReporter) error {
+	psql := d.builder()
+	i := psql.Insert("session_reporters").
+		Columns("token", "update_id", "addr_hash", "reverify").
+		Values(string(r.Token), r.UpdateID, r.AddrHash, r.Reverify)
+	_, err := i.RunWith(d.cache).Exec()
+	logSQLErr(err, &i)
+	return err
+}
+
+// GetSessionReporter returns the reporter of the session token.
+func (d *DB) GetSessionReporter(token shared.SuggestionToken) (SessionReporter, bool, error) {
+	psql := d.builder()
+	s := psql.Select("update_id", "addr_hash", "reverify", "created_at").
+		From("session_reporters").
+		Where(squirrel.Eq{"token": string(token)})
+	r := SessionReporter{Token: token}
+	err := s.RunWith(d.cache).QueryRow().Scan(&r.UpdateID, &r.AddrHash, &r.Reverify, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return SessionReporter{}, false, nil
+	}
+	if err != nil {
+		logSQLErr(err, &s)
+		return SessionReporter{}, false, err
+	}
+	return r, true, nil
+}
+
+// InsertPublishCandidate inserts c into the publish_candidates table. A
+// session is only inserted once.
+func (d *DB) InsertPublishCandidate(c PublishCandidate) error {
+	psql := d.builder()
+	s := psql.Select("1").Prefix("select exists(").
+		From("publish_candidates").
+		Where(squirrel.Eq{"token": string(c.Token)}).
+		Suffix(")")
+	var exists bool
+	if err := s.RunWith(d.cache).QueryRow().Scan(&exists); err != nil {
+		logSQLErr(err, &s)
+		return err
+	}
+	if exists {
+		return nil
+	}
+	i := psql.Insert("publish_candidates").
+		Columns("host", "country_code", "asn", "token", "update_id", "addr_hash", "not_blocked", "created_at").
+		Values(c.Host, c.CountryCode, c.ASN, string(c.Token), c.UpdateID, c.AddrHash, c.NotBlocked, c.CreatedAt.UTC())
+	_, err := i.RunWith(d.cache).Exec()
+	logSQLErr(err, &i)
+	return err
+}
+
+// GetPublishCandidates returns the blocked or not blocked candidates for
+// host in a country and ASN for sessions started after since, oldest first.
+func (d *DB) GetPublishCandidates(host, countryCode string, ASN int, notBlocked bool, since time.Time) ([]PublishCandidate, error) {
+	psql := d.builder()
+	s := psql.Select("id", "host", "country_code", "asn", "token", "update_id", "addr_hash", "not_blocked", "created_at").
+		From("publish_candidates").
+		Where(squirrel.Eq{
+			"host":         host,
+			"country_code": countryCode,
+			"asn":          ASN,
+			"not_blocked":  notBlocked,
+		}).
+		Where("created_at > ?", since.UTC()).
+		OrderBy("created_at", "id")
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var candidates []PublishCandidate
+	for rows.Next() {
+		var c PublishCandidate
+		var token string
+		err := rows.Scan(&c.ID, &c.Host, &c.CountryCode, &c.ASN, &token, &c.UpdateID, &c.AddrHash, &c.NotBlocked, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.Token = shared.SuggestionToken(token)
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// DeletePublishCandidates deletes all candidates for host in a country and
+// ASN, which is done when the host is published or removed.
+func (d *DB) DeletePublishCandidates(host, countryCode string, ASN int) error {
+	psql := d.builder()
+	q := psql.Delete("publish_candidates").Where(squirrel.Eq{
+		"host":         host,
+		"country_code": countryCode,
+		"asn":          ASN,
+	})
+	_, err := q.RunWith(d.cache).Exec()
+	logSQLErr(err, &q)
+	return err
+}
+
+// ResetNotBlocked breaks any series of not blocked verdicts for host in a
+// country and ASN, it clears the not blocked count of the published host and
+// deletes its not blocked candidates.
+func (d *DB) ResetNotBlocked(host, countryCode string, ASN int) error {
+	psql := d.builder()
+	wh := squirrel.Eq{
+		"host":         host,
+		"country_code": countryCode,
+		"asn":          ASN,
+	}
+	u := psql.Update("hosts_publish").
+		Set("not_blocked_count", 0).
+		Where(wh).
+		Where("not_blocked_count > 0")
+	if _, err := u.RunWith(d.cache).Exec(); err != nil {
+		logSQLErr(err, &u)
+		return err
+	}
+	q := psql.Delete("publish_candidates").
+		Where(wh).
+		Where(squirrel.Eq{"not_blocked": true})
+	_, err := q.RunWith(d.cache).Exec()
+	logSQLErr(err, &q)
+	return err
+}
+
+// ExpirePublishCandidates deletes the candidates and session reporters
+// created before before and returns the number of deleted candidates.
+func (d *DB) ExpirePublishCandidates(before time.Time) (int64, error) {
+	psql := d.builder()
+	q := psql.Delete("session_reporters").Where("created_at < ?", before.UTC())
+	if _, err := q.RunWith(d.cache).Exec(); err != nil {
+		logSQLErr(err, &q)
+		return 0, err
+	}
+	q = psql.Delete("publish_candidates").Where("created_at < ?", before.UTC())
+	res, err := q.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetReporterHashKey returns the secret key used to hash reporter addresses,
+// it is created on first use and shared by all central processes.
+func (d *DB) GetReporterHashKey() ([]byte, error) {
+	psql := d.builder()
+	s := psql.Select("value").From("central_state").
+		Where(squirrel.Eq{"name": "reporter_hash_key"})
+	var value string
+	err := s.RunWith(d.cache).QueryRow().Scan(&value)
+	if err == sql.ErrNoRows {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		i := psql.Insert("central_state").
+			Columns("name", "value").
+			Values("reporter_hash_key", hex.EncodeToString(key))
+		if _, err := i.RunWith(d.cache).Exec(); err != nil {
+			// another process might have created the key first.
+			logSQLErr(err, &i)
+		}
+		err = s.RunWith(d.cache).QueryRow().Scan(&value)
+	}
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	return hex.DecodeString(value)
+}
@@ -5,4 +5,5 @@ type Clients struct {
 	Internet InternetClient
 	DB       DBClient
 	Maxmind  MaxmindClient
+	Tokens   TokenStore
 }
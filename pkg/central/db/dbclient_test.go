@@ -0,0 +1,696 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+// testDBClient runs the DBClient test suite against d. The suite only
+// depends on rows that it inserts itself so that it can be run against
+// databases which already contains data.
+func testDBClient(t *testing.T, d *DB) {
+	var _ DBClient = d
+	nonce := fmt.Sprintf("%d", time.Now().UnixNano())
+	host := func(name string) string {
+		return fmt.Sprintf("%s-%s.example.com", name, nonce)
+	}
+
+	t.Run("LastProcessedSampleID", func(t *testing.T) {
+		prev, err := d.GetLastProcessedSampleID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.SetLastProcessedSampleID(prev)
+		for _, id := range []uint64{prev + 10, prev + 20} {
+			if err := d.SetLastProcessedSampleID(id); err != nil {
+				t.Fatal(err)
+			}
+			got, err := d.GetLastProcessedSampleID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != id {
+				t.Errorf("expected %d, got %d", id, got)
+			}
+		}
+	})
+
+	t.Run("Samples", func(t *testing.T) {
+		token := shared.SuggestionToken("token-" + nonce)
+		h := host("sample")
+		err := d.InsertSample(Sample{
+			Host:        h,
+			CountryCode: "SE",
+			ASN:         1,
+			Origin:      "Central",
+			Type:        "NewClientToken",
+			Token:       token,
+			Data:        []byte(`{"URL":"http://` + h + `/"}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.InsertSample(Sample{
+			Host:        h,
+			CountryCode: "SE",
+			ASN:         1,
+			Origin:      "Client",
+			Type:        "HTTPHeader",
+			Token:       token,
+			Data:        []byte(`{}`),
+			ExtraData:   []byte(`{"a":1}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		samples, err := d.GetSessionSamples(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 2 {
+			t.Fatalf("expected 2 session samples, got %d", len(samples))
+		}
+		if samples[0].Token != token || samples[0].Host != h || samples[0].CreatedAt.IsZero() {
+			t.Errorf("unexpected sample: %+v", samples[0])
+		}
+
+		ch, err := d.GetSamples(samples[0].ID-1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for s := range ch {
+			if s.Token == token {
+				n++
+			}
+		}
+		if n != 2 {
+			t.Errorf("expected 2 samples, got %d", n)
+		}
+
+		sessions, err := d.RecentSuggestionSessions(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, s := range sessions {
+			if s.ID == token {
+				found = strings.Contains(s.URL, h)
+			}
+		}
+		if !found {
+			t.Errorf("session %s not found in %v", token, sessions)
+		}
+
+		exported, _, err := d.GetExportSamples(shared.ExportSampleRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exported) < 2 || exported[0].Host != h {
+			t.Errorf("unexpected exported samples: %v", exported)
+		}
+	})
+
+	t.Run("SimpleSamples", func(t *testing.T) {
+		origin := "origin-" + nonce
+		err := d.InsertSimpleSample(SimpleSample{
+			CountryCode: "SE",
+			ASN:         1,
+			Type:        "ClientBlocklistUpdate",
+			OriginID:    origin,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported, _, err := d.GetExportSimpleSamples(shared.ExportSimpleSampleRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exported) == 0 || exported[0].OriginID != origin || exported[0].Data != "{}" {
+			t.Errorf("unexpected exported simple samples: %v", exported)
+		}
+	})
+
+	t.Run("PublishHost", func(t *testing.T) {
+		h := host("publish")
+		sample := Sample{Host: h, CountryCode: "SE", ASN: 2}
+		for i := 0; i < 2; i++ {
+			if err := d.PublishHost(sample); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hosts, err := d.GetBlockedHosts("SE", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count(hosts, h) != 1 {
+			t.Errorf("expected %s once in %v", h, hosts)
+		}
+
+		entry := HostListEntry{Host: h, CountryCode: "SE", ASN: 2}
+		removed, err := d.RecordNotBlocked(entry, 2)
+		if err != nil || removed {
+			t.Fatalf("expected host to remain, got %v %v", removed, err)
+		}
+		published, err := d.GetPublishedHosts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range published {
+			if p.Host == h && p.NotBlocked != 1 {
+				t.Errorf("expected 1 not blocked verdict, got %d", p.NotBlocked)
+			}
+		}
+		removed, err = d.RecordNotBlocked(entry, 2)
+		if err != nil || !removed {
+			t.Fatalf("expected host to be removed, got %v %v", removed, err)
+		}
+		hosts, err = d.GetBlockedHosts("SE", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count(hosts, h) != 0 {
+			t.Errorf("expected %s to be removed from %v", h, hosts)
+		}
+
+		log, _, err := d.GetExportBlockedHosts(shared.BlockedContentRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, l := range log {
+			if l.Host == h {
+				actions = append(actions, l.Action)
+			}
+		}
+		if strings.Join(actions, ",") != "Remove,Add" {
+			t.Errorf("unexpected hosts_publish_log actions: %v", actions)
+		}
+	})
+
+	t.Run("PublishHostRule", func(t *testing.T) {
+		rule := "*." + host("rule")
+		if err := d.PublishHostRule(rule, "SE", 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.PublishHostRule("not a rule", "SE", 3); err == nil {
+			t.Error("expected invalid rule error")
+		}
+		removed, err := d.RecordNotBlocked(HostListEntry{Host: rule, CountryCode: "SE", ASN: 3}, 1)
+		if err != nil || removed {
+			t.Errorf("sticky rules should not be removed: %v %v", removed, err)
+		}
+		hosts, err := d.GetBlockedHosts("SE", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count(hosts, rule) != 1 {
+			t.Errorf("expected %s in %v", rule, hosts)
+		}
+		ok, err := d.UnpublishHostRule(rule, "SE", 3)
+		if err != nil || !ok {
+			t.Fatalf("expected rule to be removed: %v %v", ok, err)
+		}
+		ok, err = d.UnpublishHostRule(rule, "SE", 3)
+		if err != nil || ok {
+			t.Errorf("expected rule to be gone: %v %v", ok, err)
+		}
+	})
+
+	t.Run("CountryWideHosts", func(t *testing.T) {
+		wide, narrow := host("wide"), host("narrow")
+		for asn := 100; asn < 103; asn++ {
+			if err := d.PublishHost(Sample{Host: wide, CountryCode: "SE", ASN: asn}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.PublishHost(Sample{Host: narrow, CountryCode: "SE", ASN: 100}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := d.GetCountryWideHosts(0, 3, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count(result["SE"], wide) != 1 || count(result["SE"], narrow) != 0 {
+			t.Errorf("unexpected country wide hosts: %v", result["SE"])
+		}
+		for asn := 100; asn < 103; asn++ {
+			d.UnpublishHostRule(wide, "SE", asn)
+		}
+		d.UnpublishHostRule(narrow, "SE", 100)
+	})
+
+	t.Run("AnalysisResults", func(t *testing.T) {
+		h := host("analysis")
+		err := d.InsertAnalysisResult(AnalysisResult{
+			Host:        h,
+			CountryCode: "SE",
+			ASN:         1,
+			Token:       shared.SuggestionToken("token-" + nonce),
+			Score:       0.5,
+			Published:   true,
+			Scores:      []byte(`{"HTTPHeader":0.5}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported, _, err := d.GetExportAnalysisResults(shared.ExportAnalysisResultRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exported) == 0 || exported[0].Host != h || !exported[0].Published || exported[0].Score != 0.5 {
+			t.Errorf("unexpected exported analysis results: %v", exported)
+		}
+	})
+
+	t.Run("BlockPageSignatures", func(t *testing.T) {
+		pattern := "blocked-" + nonce
+		id, err := d.InsertBlockPageSignature(BlockPageSignature{
+			Kind:    BlockPageTitle,
+			Pattern: pattern,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.InsertBlockPageSignature(BlockPageSignature{Kind: "invalid"}); err == nil {
+			t.Error("expected invalid kind error")
+		}
+		signatures, err := d.GetBlockPageSignatures()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, s := range signatures {
+			if s.ID == id {
+				found = s.Pattern == pattern && s.CountryCode == ""
+			}
+		}
+		if !found {
+			t.Errorf("signature %d not found", id)
+		}
+		ok, err := d.DeleteBlockPageSignature(id)
+		if err != nil || !ok {
+			t.Errorf("expected signature to be deleted: %v %v", ok, err)
+		}
+	})
+
+	t.Run("Upgrades", func(t *testing.T) {
+		version := "v0.0.0-" + nonce
+		err := d.InsertUpgrades([]UpgradeMeta{{
+			Artifact:         "alkasir-gui-linux-amd64",
+			Version:          version,
+			SHA256Sum:        "sum",
+			ED25519Signature: "sig",
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err := d.GetUpgrade(GetUpgradeQuery{
+			Artifact: "alkasir-gui-linux-amd64",
+			Version:  version,
+		})
+		if err != nil || ok {
+			t.Errorf("unpublished upgrade should not be found: %v %v", ok, err)
+		}
+		u, ok, err := d.GetUpgrade(GetUpgradeQuery{
+			Artifact:        "alkasir-gui-linux-amd64",
+			Version:         version,
+			AlsoUnpublished: true,
+		})
+		if err != nil || !ok || u.SHA256Sum != "sum" {
+			t.Errorf("unexpected upgrade: %v %v %v", u, ok, err)
+		}
+	})
+
+	t.Run("ExportAPICredentials", func(t *testing.T) {
+		c := APICredentials{
+			Username: "user-" + nonce,
+			Scopes:   []string{ScopeBlockedRead, ScopeSamplesRead},
+		}
+		if err := c.SetPassword("secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.InsertExportAPICredentials(c); err != nil {
+			t.Fatal(err)
+		}
+		ok, stored, err := d.GetExportAPIAuthCredentials(c.Username)
+		if err != nil || !ok {
+			t.Fatalf("expected credentials: %v %v", ok, err)
+		}
+		if valid, err := stored.IsValid("secret"); !valid || err != nil {
+			t.Errorf("expected valid credentials: %v %v", valid, err)
+		}
+		if !stored.HasScope(ScopeSamplesRead) || stored.HasScope(ScopeRawDataRead) || stored.Generation != 0 {
+			t.Errorf("unexpected credentials: %+v", stored)
+		}
+		ok, _, err = d.GetExportAPIAuthCredentials("missing-" + nonce)
+		if err != nil || ok {
+			t.Errorf("expected no credentials: %v %v", ok, err)
+		}
+
+		if err := stored.SetPassword("rotated"); err != nil {
+			t.Fatal(err)
+		}
+		stored.Scopes = []string{ScopeRawDataRead}
+		if ok, err := d.UpdateExportAPICredentials(stored); !ok || err != nil {
+			t.Fatalf("expected updated credentials: %v %v", ok, err)
+		}
+		if ok, err := d.SetExportAPICredentialsEnabled(c.Username, false); !ok || err != nil {
+			t.Fatalf("expected disabled credentials: %v %v", ok, err)
+		}
+		all, err := d.ListExportAPICredentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, v := range all {
+			if v.Username != c.Username {
+				continue
+			}
+			found = true
+			if v.Enabled || v.Generation != 1 || !v.HasScope(ScopeRawDataRead) || v.HasScope(ScopeSamplesRead) {
+				t.Errorf("unexpected credentials: %+v", v)
+			}
+			if valid, _ := v.IsValid("rotated"); valid {
+				t.Error("expected disabled credentials to be invalid")
+			}
+		}
+		if !found {
+			t.Errorf("%s not listed", c.Username)
+		}
+		if ok, err := d.SetExportAPICredentialsEnabled("missing-"+nonce, false); ok || err != nil {
+			t.Errorf("expected no credentials: %v %v", ok, err)
+		}
+	})
+
+	t.Run("ExportAPIAudit", func(t *testing.T) {
+		user := "audit-" + nonce
+		for i := 1; i <= 2; i++ {
+			err := d.InsertExportAPIAudit(ExportAPIAudit{
+				Username: user,
+				Request:  "GET /v1/samples/?country_code=SE",
+				Route:    "/v1/samples/",
+				Filters:  "country_code=SE",
+				Rows:     i,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		audit, err := d.GetExportAPIAudit(user, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(audit) != 2 || audit[0].Rows != 2 || audit[0].Filters != "country_code=SE" || audit[0].CreatedAt.IsZero() {
+			t.Errorf("unexpected audit: %+v", audit)
+		}
+	})
+
+	t.Run("IsURLAllowed", func(t *testing.T) {
+		u, _ := url.Parse("http://" + host("allowed") + "/")
+		ok, err := d.IsURLAllowed(u, "SE")
+		if err != nil || !ok {
+			t.Errorf("expected url to be allowed: %v %v", ok, err)
+		}
+	})
+
+	t.Run("TokenStore", func(t *testing.T) {
+		expired := NewDBTokenStore(d, -time.Minute)
+		testTokenStore(t, NewDBTokenStore(d, time.Minute), expired)
+		n, err := expired.Expire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 1 {
+			t.Errorf("expected expired tokens to be deleted, got %d", n)
+		}
+	})
+
+	t.Run("PublishCandidates", func(t *testing.T) {
+		token := shared.SuggestionToken("candidate-" + nonce)
+		err := d.InsertSessionReporter(SessionReporter{Token: token, UpdateID: "u", AddrHash: "a", Reverify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok, err := d.GetSessionReporter(token)
+		if err != nil || !ok || r.UpdateID != "u" || r.AddrHash != "a" || !r.Reverify || r.CreatedAt.IsZero() {
+			t.Fatalf("unexpected session reporter: %+v %v %v", r, ok, err)
+		}
+		if _, ok, err := d.GetSessionReporter("missing-" + token); err != nil || ok {
+			t.Errorf("expected no session reporter: %v %v", ok, err)
+		}
+
+		h := host("candidate")
+		now := time.Now()
+		for i, created := range []time.Time{now.Add(-time.Hour), now.Add(-30 * 24 * time.Hour)} {
+			c := PublishCandidate{
+				Host:        h,
+				CountryCode: "SE",
+				ASN:         4,
+				Token:       shared.SuggestionToken(fmt.Sprintf("%s-%d", token, i)),
+				AddrHash:    "a",
+				CreatedAt:   created,
+			}
+			for j := 0; j < 2; j++ {
+				if err := d.InsertPublishCandidate(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		candidates, err := d.GetPublishCandidates(h, "SE", 4, false, now.Add(-24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 1 || candidates[0].AddrHash != "a" || candidates[0].Token != token+"-0" {
+			t.Errorf("unexpected candidates: %+v", candidates)
+		}
+		notBlocked := PublishCandidate{
+			Host:        h,
+			CountryCode: "SE",
+			ASN:         4,
+			Token:       token + "-not-blocked",
+			AddrHash:    "b",
+			NotBlocked:  true,
+			CreatedAt:   now,
+		}
+		if err := d.InsertPublishCandidate(notBlocked); err != nil {
+			t.Fatal(err)
+		}
+		candidates, err = d.GetPublishCandidates(h, "SE", 4, true, now.Add(-24*time.Hour))
+		if err != nil || len(candidates) != 1 || !candidates[0].NotBlocked || candidates[0].Token != notBlocked.Token {
+			t.Errorf("unexpected not blocked candidates: %+v %v", candidates, err)
+		}
+		if err := d.ResetNotBlocked(h, "SE", 4); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []bool{false, true} {
+			candidates, err = d.GetPublishCandidates(h, "SE", 4, v, now.Add(-24*time.Hour))
+			if err != nil || len(candidates) != map[bool]int{false: 1, true: 0}[v] {
+				t.Errorf("unexpected candidates after reset: %+v %v", candidates, err)
+			}
+		}
+		n, err := d.ExpirePublishCandidates(now.Add(-24 * time.Hour))
+		if err != nil || n < 1 {
+			t.Errorf("expected old candidates to be expired: %d %v", n, err)
+		}
+		if err := d.DeletePublishCandidates(h, "SE", 4); err != nil {
+			t.Fatal(err)
+		}
+		candidates, err = d.GetPublishCandidates(h, "SE", 4, false, time.Time{})
+		if err != nil || len(candidates) != 0 {
+			t.Errorf("expected no candidates: %v %v", candidates, err)
+		}
+
+		key, err := d.GetReporterHashKey()
+		if err != nil || len(key) != 32 {
+			t.Fatalf("unexpected reporter hash key: %x %v", key, err)
+		}
+		key2, err := d.GetReporterHashKey()
+		if err != nil || string(key) != string(key2) {
+			t.Errorf("expected the same reporter hash key: %v", err)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		asn := int(time.Now().UnixNano()%1000000) + 4000000
+		version := "v-" + nonce
+		for _, origin := range []string{"a", "b", "a"} {
+			err := d.InsertSimpleSample(SimpleSample{
+				CountryCode: "SE",
+				ASN:         asn,
+				Type:        "ClientBlocklistUpdate",
+				OriginID:    origin + nonce,
+				Data:        []byte(`{"version":"` + version + `"}`),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// two sessions from the same reporter and a re-verification which is
+		// not counted.
+		for _, prefix := range []string{"stats-", "stats2-", "reverify-"} {
+			token := shared.SuggestionToken(prefix + nonce)
+			err := d.InsertSample(Sample{
+				Host:        host("stats"),
+				CountryCode: "SE",
+				ASN:         asn,
+				Origin:      "Central",
+				Type:        "NewClientToken",
+				Token:       token,
+				Data:        []byte(`{}`),
+				ExtraData:   []byte(`{"CityGeoNameID":2673730}`),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reporter := SessionReporter{Token: token, AddrHash: "stats-" + nonce}
+			if prefix == "reverify-" {
+				reporter = SessionReporter{Token: token, AddrHash: prefix + nonce, Reverify: true}
+			}
+			if err := d.InsertSessionReporter(reporter); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		count := func(name, label string, filter shared.ExportFilter) int {
+			entries, err := d.GetStats(shared.ExportStatsRequest{Name: name, ExportFilter: filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			for _, e := range entries {
+				if label == "" || e.Label == label {
+					n += e.Count
+				}
+			}
+			return n
+		}
+		// refreshing twice recomputes the latest day.
+		for i := 0; i < 2; i++ {
+			for _, name := range append(StatsNames, StatsSessions) {
+				if _, err := d.RefreshStats(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			byASN := shared.ExportFilter{ASN: asn, Since: time.Now().Add(-24 * time.Hour)}
+			if n := count(StatsActiveClients, "", byASN); n != 2 {
+				t.Errorf("expected 2 active clients, got %d", n)
+			}
+			if n := count(StatsSuggestions, "", byASN); n != 2 {
+				t.Errorf("expected 2 suggestions, got %d", n)
+			}
+			if n := count(StatsSessions, "2673730", byASN); n != 1 {
+				t.Errorf("expected 1 reporter, got %d", n)
+			}
+			if n := count(StatsClientVersions, version, shared.ExportFilter{CountryCode: "SE"}); n != 2 {
+				t.Errorf("expected 2 clients with version %s, got %d", version, n)
+			}
+		}
+		if n := count(StatsActiveClients, "", shared.ExportFilter{ASN: asn, Until: time.Now().Add(-48 * time.Hour)}); n != 0 {
+			t.Errorf("expected no active clients before the samples, got %d", n)
+		}
+		if _, err := d.RefreshStats("unknown"); err == nil {
+			t.Error("expected unknown statistics error")
+		}
+		token := shared.SuggestionToken("stats-" + nonce)
+		buckets, err := d.GetSessionBuckets([]shared.SuggestionToken{token, shared.SuggestionToken("unknown-" + nonce)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := buckets[token]; len(buckets) != 1 || b.CountryCode != "SE" || b.ASN != asn || b.City != "2673730" || b.Day.IsZero() {
+			t.Errorf("unexpected session buckets: %+v", buckets)
+		}
+	})
+
+	t.Run("Webhooks", func(t *testing.T) {
+		if _, err := d.InsertWebhookSubscription(WebhookSubscription{URL: "http://example.com/"}); err == nil {
+			t.Error("expected https url error")
+		}
+		u := "https://" + host("webhook") + "/"
+		id, err := d.InsertWebhookSubscription(WebhookSubscription{URL: u, Secret: "secret", CountryCode: "se"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.DeleteWebhookSubscription(id)
+		get := func() WebhookSubscription {
+			subs, err := d.ListWebhookSubscriptions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range subs {
+				if v.ID == id {
+					return v
+				}
+			}
+			t.Fatalf("webhook %d not listed", id)
+			return WebhookSubscription{}
+		}
+		sub := get()
+		if sub.URL != u || sub.CountryCode != "SE" || !sub.Enabled || sub.CreatedAt.IsZero() {
+			t.Errorf("unexpected subscription: %+v", sub)
+		}
+
+		rule := host("event")
+		if err := d.PublishHostRule(rule, "SE", 5); err != nil {
+			t.Fatal(err)
+		}
+		events, err := d.GetPublishEvents(sub.LastEventID, shared.ExportFilter{CountryCode: "SE", Host: rule}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Action != "Add" || events[0].ASN != "5" {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+		latest, err := d.LatestPublishEventID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if events[0].ID != fmt.Sprint(latest) {
+			t.Errorf("expected latest event %d, got %+v", latest, events[0])
+		}
+
+		sub.LastEventID = latest
+		sub.Failures = 2
+		sub.LastError = "failed"
+		if err := d.UpdateWebhookSubscriptionState(sub); err != nil {
+			t.Fatal(err)
+		}
+		if v := get(); v.LastEventID != latest || v.Failures != 2 || v.LastError != "failed" {
+			t.Errorf("unexpected subscription: %+v", v)
+		}
+		if ok, err := d.SetWebhookSubscriptionEnabled(id, false); err != nil || !ok {
+			t.Fatal(ok, err)
+		}
+		if v := get(); v.Enabled || v.Failures != 0 {
+			t.Errorf("unexpected subscription: %+v", v)
+		}
+		if ok, err := d.DeleteWebhookSubscription(id); err != nil || !ok {
+			t.Fatal(ok, err)
+		}
+		if ok, _ := d.DeleteWebhookSubscription(id); ok {
+			t.Error("expected webhook to be deleted")
+		}
+	})
+
+	t.Run("RelatedHosts", func(t *testing.T) {
+		if _, err := d.GetRelatedHosts(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func count(list []string, s string) int {
+	var n int
+	for _, v := range list {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// ExportAPIAudit mirrors the export_api_audit table, one row is written for
+// each export api request.
+type ExportAPIAudit struct {
+	ID        uint64
+	Username  string
+	Request   string // method and request uri
+	Route     string
+	Filters   string // url encoded filter parameters
+	Rows      int    // number of returned entries
+	CreatedAt time.Time
+}
+
+// InsertExportAPIAudit inserts a into the export_api_audit table.
+func (d *DB) InsertExportAPIAudit(a ExportAPIAudit) error {
+	psql := d.builder()
+	var authID *int // nullable, requests can be made by removed users
+	s := psql.Select("id").From("export_api_auth").
+		Where(squirrel.Eq{"username": a.Username})
+	var id int
+	if err := s.RunWith(d.cache).QueryRow().Scan(&id); err == nil {
+		authID = &id
+	}
+	i := psql.Insert("export_api_audit").
+		Columns("export_api_auth_id", "username", "request", "route", "filters", "row_count").
+		Values(authID, a.Username, a.Request, a.Route, a.Filters, a.Rows)
+	_, err := i.RunWith(d.cache).Exec()
+	logSQLErr(err, &i)
+	return err
+}
+
+// GetExportAPIAudit returns the latest limit audit rows, only for username
+// unless it is empty.
+func (d *DB) GetExportAPIAudit(username string, limit int) ([]ExportAPIAudit, error) {
+	psql := d.builder()
+	s := psql.Select("id", "username", "request", "route", "filters", "row_count", "created_at").
+		From("export_api_audit").
+		OrderBy("id desc").
+		Limit(uint64(limit))
+	if username != "" {
+		s = s.Where(squirrel.Eq{"username": username})
+	}
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var audit []ExportAPIAudit
+	for rows.Next() {
+		var a ExportAPIAudit
+		err := rows.Scan(&a.ID, &a.Username, &a.Request, &a.Route, &a.Filters, &a.Rows, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		audit = append(audit, a)
+	}
+	return audit, rows.Err()
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"errors"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/thomasf/internet"
@@ -26,3 +29,43 @@ func (i *Internet) IP2ASN(IP net.IP) (*internet.ASNResult, error) {
 	rconn.Close()
 	return r, e
 }
+
+// LocalInternet is an InternetClient which resolves addresses using an in
+// process PrefixTrie instead of a redis server.
+type LocalInternet struct {
+	mu   sync.RWMutex
+	trie *PrefixTrie
+	date time.Time // date of the table in trie
+}
+
+// NewLocalInternetClient returns a LocalInternet without a table, lookups
+// fail until Replace is called.
+func NewLocalInternetClient() *LocalInternet {
+	return &LocalInternet{}
+}
+
+// Replace sets the table used for lookups.
+func (l *LocalInternet) Replace(trie *PrefixTrie, date time.Time) {
+	l.mu.Lock()
+	l.trie = trie
+	l.date = date
+	l.mu.Unlock()
+}
+
+func (l *LocalInternet) IP2ASN(IP net.IP) (*internet.ASNResult, error) {
+	l.mu.RLock()
+	trie, date := l.trie, l.date
+	l.mu.RUnlock()
+	if trie == nil {
+		return nil, errors.New("no ip2asn table loaded")
+	}
+	ipnet, asn, ok := trie.Lookup(IP)
+	if !ok {
+		return nil, nil
+	}
+	return &internet.ASNResult{
+		Mask: *ipnet,
+		ASN:  asn,
+		Date: date,
+	}, nil
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/thomasf/lg"
+)
+
+// Migration is a versioned schema change.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         string
+	NoTx        bool // run outside of a transaction, required by postgres ALTER TYPE ... ADD VALUE
+}
+
+// Checksum returns the hex encoded sha256 sum of the migration SQL.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppliedMigration mirrors the schema_migrations table.
+type AppliedMigration struct {
+	Version     int
+	Description string
+	Checksum    string
+	AppliedAt   time.Time
+}
+
+const createSchemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version integer PRIMARY KEY,
+  description text NOT NULL,
+  checksum text NOT NULL,
+  applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrationLockID is the postgres advisory lock held while migrating.
+const migrationLockID = 4242001
+
+// Migrations returns all migrations for the database dialect.
+func (d *DB) Migrations() []Migration {
+	if d.dialect == DialectSQLite {
+		return sqliteMigrations
+	}
+	return postgresMigrations
+}
+
+// MigrationStatus returns the applied and the pending migrations. An error
+// is returned if an applied migration is unknown or has been modified.
+func (d *DB) MigrationStatus() ([]AppliedMigration, []Migration, error) {
+	ctx := context.Background()
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, createSchemaMigrationsSQL); err != nil {
+		return nil, nil, err
+	}
+	applied, err := d.appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending, err := d.pendingMigrations(applied)
+	return applied, pending, err
+}
+
+// Migrate applies all pending migrations and returns them. Concurrent
+// Migrate calls, also from other processes, wait for each other.
+//
+// Postgres databases which were created by the liquibase changelogs gets the
+// baseline migration recorded as applied without running it, if liquibase
+// has applied all changesets.
+func (d *DB) Migrate() ([]Migration, error) {
+	ctx := context.Background()
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.dialect == DialectSQLite {
+		// The write lock is held until the migrations are committed.
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return nil, err
+		}
+		pending, err := d.migrate(ctx, conn)
+		if err != nil {
+			if _, rerr := conn.ExecContext(ctx, "ROLLBACK"); rerr != nil {
+				lg.Errorln(rerr)
+			}
+			return nil, err
+		}
+		if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+			return nil, err
+		}
+		return pending, nil
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			lg.Errorln(err)
+		}
+	}()
+	return d.migrate(ctx, conn)
+}
+
+func (d *DB) migrate(ctx context.Context, conn *sql.Conn) ([]Migration, error) {
+	if _, err := conn.ExecContext(ctx, createSchemaMigrationsSQL); err != nil {
+		return nil, err
+	}
+	applied, err := d.appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 && d.dialect == DialectPostgres {
+		ok, err := liquibaseBaseline(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			baseline := postgresMigrations[0]
+			lg.Infof("recording migration %d as applied to the liquibase managed database", baseline.Version)
+			if err := d.recordMigration(ctx, conn, baseline); err != nil {
+				return nil, err
+			}
+			applied = append(applied, AppliedMigration{Version: baseline.Version, Checksum: baseline.Checksum()})
+		}
+	}
+	pending, err := d.pendingMigrations(applied)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		lg.Infof("applying migration %d: %s", m.Version, m.Description)
+		if err := d.applyMigration(ctx, conn, m); err != nil {
+			return nil, fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+	}
+	return pending, nil
+}
+
+// applyMigration runs m and records it. SQLite migrations are already
+// running inside the transaction started by Migrate.
+func (d *DB) applyMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	if d.dialect == DialectSQLite || m.NoTx {
+		if _, err := conn.ExecContext(ctx, m.SQL); err != nil {
+			return err
+		}
+		return d.recordMigration(ctx, conn, m)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := d.recordMigration(ctx, tx, m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// liquibaseBaselineChangeset is the last liquibase changeset, postgres
+// migration 1 is the schema after it.
+const liquibaseBaselineChangeset = "20261018-201544-CEST"
+
+// liquibaseBaseline returns true if the database was created by the liquibase
+// changelogs and has all changesets applied. Databases where liquibase
+// stopped at an earlier changeset are rejected since migration 1 can not be
+// applied on top of them.
+func liquibaseBaseline(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var liquibase bool
+	err := conn.QueryRowContext(ctx,
+		`SELECT exists(SELECT 1 FROM information_schema.tables
+		 WHERE table_schema = current_schema() AND table_name = 'databasechangelog')`,
+	).Scan(&liquibase)
+	if err != nil || !liquibase {
+		return false, err
+	}
+	var complete bool
+	err = conn.QueryRowContext(ctx,
+		"SELECT exists(SELECT 1 FROM databasechangelog WHERE id = $1)", liquibaseBaselineChangeset,
+	).Scan(&complete)
+	if err != nil {
+		return false, err
+	}
+	if !complete {
+		return false, fmt.Errorf("the liquibase managed database is older than changeset %s, "+
+			"apply the changelogs in central-db/changelogs before migrating", liquibaseBaselineChangeset)
+	}
+	return true, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (d *DB) recordMigration(ctx context.Context, e execer, m Migration) error {
+	_, err := e.ExecContext(ctx,
+		d.rebind("INSERT INTO schema_migrations (version, description, checksum) VALUES (?, ?, ?)"),
+		m.Version, m.Description, m.Checksum())
+	return err
+}
+
+func (d *DB) appliedMigrations(ctx context.Context, conn *sql.Conn) ([]AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx,
+		"SELECT version, description, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var applied []AppliedMigration
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Description, &m.Checksum, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// pendingMigrations verifies the applied migrations against the known ones
+// and returns the ones which are not applied.
+func (d *DB) pendingMigrations(applied []AppliedMigration) ([]Migration, error) {
+	known := make(map[int]Migration, 0)
+	for _, m := range d.Migrations() {
+		known[m.Version] = m
+	}
+	done := make(map[int]bool, 0)
+	for _, a := range applied {
+		m, ok := known[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("applied migration %d is unknown, the database is newer than this program", a.Version)
+		}
+		if m.Checksum() != a.Checksum {
+			return nil, fmt.Errorf("checksum mismatch for applied migration %d: %s", a.Version, m.Description)
+		}
+		done[a.Version] = true
+	}
+	var pending []Migration
+	for _, m := range d.Migrations() {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationVersions(t *testing.T) {
+	for name, migrations := range map[string][]Migration{
+		DialectPostgres: postgresMigrations,
+		DialectSQLite:   sqliteMigrations,
+	} {
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s: expected migration %d to have version %d", name, m.Version, i+1)
+			}
+			if m.Description == "" || m.SQL == "" {
+				t.Errorf("%s: migration %d is incomplete", name, m.Version)
+			}
+		}
+	}
+	if len(postgresMigrations) != len(sqliteMigrations) {
+		t.Errorf("postgres and sqlite migrations differ in length: %d != %d",
+			len(postgresMigrations), len(sqliteMigrations))
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pending, err := d.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending migrations, got %v", pending)
+	}
+	applied, _, err := d.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range applied {
+		m := sqliteMigrations[i]
+		if a.Version != m.Version || a.Checksum != m.Checksum() || a.AppliedAt.IsZero() {
+			t.Errorf("unexpected applied migration: %+v", a)
+		}
+	}
+
+	if _, err := d.Exec("UPDATE schema_migrations SET checksum = 'modified' WHERE version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.MigrationStatus(); err == nil {
+		t.Error("expected checksum mismatch from MigrationStatus")
+	}
+	if _, err := d.Migrate(); err == nil {
+		t.Error("expected checksum mismatch from Migrate")
+	}
+}
@@ -0,0 +1,583 @@
+package db
+
+// Schema migrations, see Migrate. Applied migrations must never be changed,
+// schema changes are made by appending new migrations for both dialects.
+
+// postgresMigrations is the schema for postgres. Version 1 is the final
+// schema of the liquibase changelogs in central-db/changelogs.
+var postgresMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "schema of liquibase changeset 20261018-201544-CEST",
+		SQL: `
+CREATE TYPE country_code AS ENUM (
+  'AF', 'AL', 'DZ', 'AS', 'AD', 'AO', 'AI', 'AQ', 'AG', 'AR', 'AM', 'AW',
+  'AU', 'AT', 'AZ', 'BS', 'BH', 'BD', 'BB', 'BY', 'BE', 'BZ', 'BJ', 'BM',
+  'BT', 'BO', 'BA', 'BW', 'BV', 'BR', 'IO', 'BN', 'BG', 'BF', 'BI', 'KH',
+  'CM', 'CA', 'CV', 'KY', 'CF', 'TD', 'CL', 'CN', 'CX', 'CC', 'CO', 'KM',
+  'CG', 'CK', 'CR', 'HR', 'CU', 'CY', 'CZ', 'DK', 'DJ', 'DM', 'DO', 'TP',
+  'EC', 'EG', 'SV', 'GQ', 'ER', 'EE', 'ET', 'FK', 'FO', 'FJ', 'FI', 'CS',
+  'FR', 'GF', 'TF', 'GA', 'GM', 'GE', 'DE', 'GH', 'GI', 'GB', 'GR', 'GL',
+  'GD', 'GP', 'GU', 'GT', 'GN', 'GW', 'GY', 'HT', 'HM', 'HN', 'HK', 'HU',
+  'IS', 'IN', 'ID', 'IR', 'IQ', 'IE', 'IL', 'IT', 'CI', 'JM', 'JP', 'JO',
+  'KZ', 'KE', 'KI', 'KW', 'KG', 'LA', 'LV', 'LB', 'LS', 'LR', 'LY', 'LI',
+  'LT', 'LU', 'MO', 'MK', 'MG', 'MW', 'MY', 'MV', 'ML', 'MT', 'MH', 'MQ',
+  'MR', 'MU', 'YT', 'MX', 'FM', 'MD', 'MC', 'MN', 'MS', 'MA', 'MZ', 'MM',
+  'NA', 'NR', 'NP', 'NL', 'AN', 'NC', 'NZ', 'NI', 'NE', 'NG', 'NU', 'NF',
+  'KP', 'MP', 'NO', 'OM', 'PK', 'PW', 'PA', 'PG', 'PY', 'PE', 'PH', 'PN',
+  'PL', 'PF', 'PT', 'PR', 'QA', 'RE', 'RO', 'RU', 'RW', 'GS', 'SH', 'KN',
+  'LC', 'PM', 'ST', 'VC', 'WS', 'SM', 'SA', 'SN', 'SC', 'SL', 'SG', 'SK',
+  'SI', 'SB', 'SO', 'ZA', 'KR', 'ES', 'LK', 'SD', 'SR', 'SJ', 'SZ', 'SE',
+  'CH', 'SY', 'TJ', 'TW', 'TZ', 'TH', 'TG', 'TK', 'TO', 'TT', 'TN', 'TR',
+  'TM', 'TC', 'TV', 'UG', 'UA', 'AE', 'US', 'UY', 'UM', 'UZ', 'VU', 'VA',
+  'VE', 'VN', 'VG', 'VI', 'WF', 'EH', 'YE', 'ZM', 'ZW', 'A1', 'A2', 'AP',
+  'AX', 'BL', 'BQ', 'CD', 'CW', 'EU', 'GG', 'IM', 'JE', 'ME', 'MF', 'O1',
+  'PS', 'RS', 'SS', 'SX', 'TL'
+);
+
+CREATE TYPE log_action AS ENUM ('Add', 'Remove', 'Update');
+
+CREATE TYPE sample_origin AS ENUM ('Central', 'Client');
+
+CREATE TYPE sample_type AS ENUM (
+  'NewClientToken', 'HTTPHeader', 'BrowserExtension', 'DNSQuery',
+  'TLSHandshake', 'TCPConnect', 'DNSInjection', 'TTLProbe'
+);
+
+CREATE TYPE simple_sample_type AS ENUM ('ClientBlocklistUpdate');
+
+CREATE TABLE hosts_publish (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now(),
+  sticky bool NOT NULL DEFAULT false,
+  not_blocked_count int NOT NULL DEFAULT 0
+);
+
+CREATE TABLE hosts_publish_log (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now(),
+  sticky bool NOT NULL DEFAULT false,
+  action log_action NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION log_host_publish() RETURNS TRIGGER AS $log_host_publish$
+    BEGIN
+        IF (TG_OP = 'DELETE') THEN
+            INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action) SELECT OLD.host, OLD.country_code, OLD.ASN, OLD.sticky, 'Remove';
+            RETURN OLD;
+        ELSIF (TG_OP = 'UPDATE') THEN
+            IF (OLD.host, OLD.country_code, OLD.asn, OLD.sticky) IS DISTINCT FROM (NEW.host, NEW.country_code, NEW.asn, NEW.sticky) THEN
+                INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action) SELECT NEW.host, NEW.country_code, NEW.ASN, NEW.sticky, 'Update';
+            END IF;
+            RETURN NEW;
+        ELSIF (TG_OP = 'INSERT') THEN
+            INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action) SELECT NEW.host, NEW.country_code, NEW.ASN, NEW.sticky, 'Add';
+            RETURN NEW;
+        END IF;
+        RETURN NULL; -- result is ignored since this is an AFTER trigger
+    END;
+$log_host_publish$ LANGUAGE plpgsql;
+
+CREATE TRIGGER log_host_publish
+AFTER INSERT OR UPDATE OR DELETE ON hosts_publish
+FOR EACH ROW EXECUTE PROCEDURE log_host_publish();
+
+CREATE TABLE hosts_unsupported (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now()
+);
+
+CREATE TABLE samples (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now(),
+  origin sample_origin NOT NULL,
+  type sample_type NOT NULL,
+  token text,
+  data jsonb NOT NULL,
+  extra_data jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE export_api_auth (
+  id serial PRIMARY KEY,
+  enabled bool NOT NULL DEFAULT true,
+  username text NOT NULL UNIQUE,
+  hash text NOT NULL,
+  salt text NOT NULL,
+  created_at timestamp without time zone DEFAULT now(),
+  comments text
+);
+
+CREATE UNIQUE INDEX idx_public_auth ON export_api_auth (enabled, username);
+
+CREATE TABLE export_api_audit (
+  id serial PRIMARY KEY,
+  export_api_auth_id int CONSTRAINT fk_auth_id REFERENCES export_api_auth (id),
+  request text NOT NULL
+);
+
+CREATE TABLE central_state (
+  name text PRIMARY KEY,
+  value text
+);
+
+CREATE UNIQUE INDEX idx_name ON central_state (name);
+
+CREATE TABLE simple_samples (
+  id serial PRIMARY KEY,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now(),
+  type simple_sample_type NOT NULL,
+  origin_id text,
+  data jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_simple_samples_type_created_at ON simple_samples (type, created_at);
+
+CREATE TABLE hosts_related (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  related text NOT NULL
+);
+
+CREATE TABLE upgrades (
+  id serial PRIMARY KEY,
+  artifact text NOT NULL,
+  version text NOT NULL,
+  published bool NOT NULL DEFAULT false,
+  created_at timestamp without time zone DEFAULT now(),
+  sha256sum text NOT NULL,
+  ed25519sig text NOT NULL,
+  CONSTRAINT const_uniq_upgrade UNIQUE (artifact, version)
+);
+
+CREATE UNIQUE INDEX idx_published_upgrades ON upgrades USING btree (artifact, version) WHERE published = true;
+
+CREATE TABLE analysis_results (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code country_code,
+  asn int,
+  created_at timestamp without time zone DEFAULT now(),
+  token text NOT NULL,
+  status_code_score double precision NOT NULL,
+  redirects_score double precision NOT NULL,
+  error_score double precision NOT NULL,
+  score double precision NOT NULL,
+  published bool NOT NULL,
+  scores jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_analysis_results_token ON analysis_results (token);
+
+CREATE TABLE block_page_signatures (
+  id serial PRIMARY KEY,
+  country_code country_code,
+  kind text NOT NULL CONSTRAINT block_page_signatures_kind CHECK (kind IN ('title', 'body', 'simhash')),
+  pattern text NOT NULL,
+  max_distance int NOT NULL DEFAULT 0,
+  comment text NOT NULL DEFAULT '',
+  created_at timestamp without time zone DEFAULT now()
+);
+`,
+	},
+	{
+		Version:     2,
+		Description: "suggestion_tokens table for the shared token store",
+		SQL: `
+CREATE TABLE suggestion_tokens (
+  token text PRIMARY KEY,
+  url text NOT NULL,
+  created_at timestamp without time zone NOT NULL,
+  expires_at bigint NOT NULL
+);
+
+CREATE INDEX suggestion_tokens_expires_at_idx ON suggestion_tokens (expires_at);
+`,
+	},
+	{
+		Version:     3,
+		Description: "sample counters for suggestion tokens",
+		SQL: `
+ALTER TABLE suggestion_tokens ADD COLUMN samples integer NOT NULL DEFAULT 0;
+ALTER TABLE suggestion_tokens ADD COLUMN sample_bytes bigint NOT NULL DEFAULT 0;
+`,
+	},
+	{
+		Version:     4,
+		Description: "session reporters and publish candidates",
+		SQL: `
+CREATE TABLE session_reporters (
+  token text PRIMARY KEY,
+  update_id text NOT NULL DEFAULT '',
+  addr_hash text NOT NULL,
+  created_at timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc')
+);
+
+CREATE INDEX session_reporters_created_at_idx ON session_reporters (created_at);
+
+CREATE TABLE publish_candidates (
+  id serial PRIMARY KEY,
+  host text NOT NULL,
+  country_code text NOT NULL,
+  asn integer NOT NULL,
+  token text NOT NULL UNIQUE,
+  update_id text NOT NULL DEFAULT '',
+  addr_hash text NOT NULL,
+  created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX publish_candidates_host_idx ON publish_candidates (host, country_code, asn);
+CREATE INDEX publish_candidates_created_at_idx ON publish_candidates (created_at);
+`,
+	},
+	{
+		Version:     5,
+		Description: "export api scopes and audit trail",
+		SQL: `
+ALTER TABLE export_api_auth ADD COLUMN scopes text NOT NULL DEFAULT '';
+ALTER TABLE export_api_auth ADD COLUMN generation integer NOT NULL DEFAULT 0;
+UPDATE export_api_auth SET scopes = 'blocked:read,samples:read,raw-data:read';
+
+ALTER TABLE export_api_audit ADD COLUMN created_at timestamp without time zone NOT NULL DEFAULT (now() at time zone 'utc');
+ALTER TABLE export_api_audit ADD COLUMN username text NOT NULL DEFAULT '';
+ALTER TABLE export_api_audit ADD COLUMN route text NOT NULL DEFAULT '';
+ALTER TABLE export_api_audit ADD COLUMN filters text NOT NULL DEFAULT '';
+ALTER TABLE export_api_audit ADD COLUMN row_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX export_api_audit_created_at_idx ON export_api_audit (created_at);
+`,
+	},
+	{
+		Version:     6,
+		Description: "daily statistics rollups for the export api",
+		SQL: `
+CREATE TABLE stats_rollups (
+  name text NOT NULL,
+  bucket timestamp without time zone NOT NULL,
+  country_code text NOT NULL DEFAULT '',
+  asn integer NOT NULL DEFAULT 0,
+  label text NOT NULL DEFAULT '',
+  count integer NOT NULL,
+  PRIMARY KEY (name, bucket, country_code, asn, label)
+);
+`,
+	},
+	{
+		Version:     7,
+		Description: "webhook subscriptions for publish events",
+		SQL: `
+CREATE TABLE webhook_subscriptions (
+  id serial PRIMARY KEY,
+  url text NOT NULL,
+  secret text NOT NULL,
+  country_code text NOT NULL DEFAULT '',
+  enabled boolean NOT NULL DEFAULT true,
+  last_event_id integer NOT NULL DEFAULT 0,
+  failures integer NOT NULL DEFAULT 0,
+  last_error text NOT NULL DEFAULT '',
+  created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+`,
+	},
+	{
+		Version:     8,
+		Description: "re-verification sessions",
+		SQL: `
+ALTER TABLE session_reporters ADD COLUMN reverify boolean NOT NULL DEFAULT false;
+`,
+	},
+	{
+		Version:     9,
+		Description: "not blocked publish candidates",
+		SQL: `
+ALTER TABLE publish_candidates ADD COLUMN not_blocked boolean NOT NULL DEFAULT false;
+`,
+	},
+}
+
+// sqliteMigrations mirrors postgresMigrations for SQLite, postgres enum
+// columns are text columns.
+var sqliteMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "schema of liquibase changeset 20261018-201544-CEST",
+		SQL: `
+CREATE TABLE hosts_publish (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  sticky BOOLEAN NOT NULL DEFAULT 0,
+  not_blocked_count INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE hosts_publish_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  sticky BOOLEAN NOT NULL DEFAULT 0,
+  action TEXT NOT NULL CHECK (action IN ('Add', 'Remove', 'Update'))
+);
+
+CREATE TRIGGER log_host_publish_insert AFTER INSERT ON hosts_publish
+BEGIN
+  INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action)
+  VALUES (NEW.host, NEW.country_code, NEW.asn, NEW.sticky, 'Add');
+END;
+
+CREATE TRIGGER log_host_publish_update AFTER UPDATE ON hosts_publish
+WHEN OLD.host IS NOT NEW.host OR OLD.country_code IS NOT NEW.country_code
+  OR OLD.asn IS NOT NEW.asn OR OLD.sticky IS NOT NEW.sticky
+BEGIN
+  INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action)
+  VALUES (NEW.host, NEW.country_code, NEW.asn, NEW.sticky, 'Update');
+END;
+
+CREATE TRIGGER log_host_publish_delete AFTER DELETE ON hosts_publish
+BEGIN
+  INSERT INTO hosts_publish_log (host, country_code, asn, sticky, action)
+  VALUES (OLD.host, OLD.country_code, OLD.asn, OLD.sticky, 'Remove');
+END;
+
+CREATE TABLE hosts_unsupported (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE samples (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  origin TEXT NOT NULL CHECK (origin IN ('Central', 'Client')),
+  type TEXT NOT NULL,
+  token TEXT,
+  data BLOB NOT NULL,
+  extra_data BLOB NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE export_api_auth (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  enabled BOOLEAN NOT NULL DEFAULT 1,
+  username TEXT NOT NULL UNIQUE,
+  hash TEXT NOT NULL,
+  salt TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  comments TEXT
+);
+
+CREATE UNIQUE INDEX idx_public_auth ON export_api_auth (enabled, username);
+
+CREATE TABLE export_api_audit (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  export_api_auth_id INTEGER REFERENCES export_api_auth (id),
+  request TEXT NOT NULL
+);
+
+CREATE TABLE central_state (
+  name TEXT PRIMARY KEY NOT NULL,
+  value TEXT
+);
+
+CREATE TABLE simple_samples (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  type TEXT NOT NULL,
+  origin_id TEXT,
+  data BLOB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_simple_samples_type_created_at ON simple_samples (type, created_at);
+
+CREATE TABLE hosts_related (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  related TEXT NOT NULL
+);
+
+CREATE TABLE upgrades (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  artifact TEXT NOT NULL,
+  version TEXT NOT NULL,
+  published BOOLEAN NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  sha256sum TEXT NOT NULL,
+  ed25519sig TEXT NOT NULL,
+  CONSTRAINT const_uniq_upgrade UNIQUE (artifact, version)
+);
+
+CREATE UNIQUE INDEX idx_published_upgrades ON upgrades (artifact, version) WHERE published = 1;
+
+CREATE TABLE analysis_results (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT,
+  asn INTEGER,
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  token TEXT NOT NULL,
+  status_code_score DOUBLE PRECISION NOT NULL,
+  redirects_score DOUBLE PRECISION NOT NULL,
+  error_score DOUBLE PRECISION NOT NULL,
+  score DOUBLE PRECISION NOT NULL,
+  published BOOLEAN NOT NULL,
+  scores BLOB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_analysis_results_token ON analysis_results (token);
+
+CREATE TABLE block_page_signatures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  country_code TEXT,
+  kind TEXT NOT NULL CHECK (kind IN ('title', 'body', 'simhash')),
+  pattern TEXT NOT NULL,
+  max_distance INTEGER NOT NULL DEFAULT 0,
+  comment TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+`,
+	},
+	{
+		Version:     2,
+		Description: "suggestion_tokens table for the shared token store",
+		SQL: `
+CREATE TABLE suggestion_tokens (
+  token TEXT PRIMARY KEY NOT NULL,
+  url TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_suggestion_tokens_expires_at ON suggestion_tokens (expires_at);
+`,
+	},
+	{
+		Version:     3,
+		Description: "sample counters for suggestion tokens",
+		SQL: `
+ALTER TABLE suggestion_tokens ADD COLUMN samples INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suggestion_tokens ADD COLUMN sample_bytes INTEGER NOT NULL DEFAULT 0;
+`,
+	},
+	{
+		Version:     4,
+		Description: "session reporters and publish candidates",
+		SQL: `
+CREATE TABLE session_reporters (
+  token TEXT PRIMARY KEY NOT NULL,
+  update_id TEXT NOT NULL DEFAULT '',
+  addr_hash TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX idx_session_reporters_created_at ON session_reporters (created_at);
+
+CREATE TABLE publish_candidates (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  host TEXT NOT NULL,
+  country_code TEXT NOT NULL,
+  asn INTEGER NOT NULL,
+  token TEXT NOT NULL UNIQUE,
+  update_id TEXT NOT NULL DEFAULT '',
+  addr_hash TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_publish_candidates_host ON publish_candidates (host, country_code, asn);
+CREATE INDEX idx_publish_candidates_created_at ON publish_candidates (created_at);
+`,
+	},
+	{
+		Version:     5,
+		Description: "export api scopes and audit trail",
+		SQL: `
+ALTER TABLE export_api_auth ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
+ALTER TABLE export_api_auth ADD COLUMN generation INTEGER NOT NULL DEFAULT 0;
+UPDATE export_api_auth SET scopes = 'blocked:read,samples:read,raw-data:read';
+
+CREATE TABLE export_api_audit_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  export_api_auth_id INTEGER REFERENCES export_api_auth (id),
+  request TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  username TEXT NOT NULL DEFAULT '',
+  route TEXT NOT NULL DEFAULT '',
+  filters TEXT NOT NULL DEFAULT '',
+  row_count INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO export_api_audit_new (id, export_api_auth_id, request)
+  SELECT id, export_api_auth_id, request FROM export_api_audit;
+DROP TABLE export_api_audit;
+ALTER TABLE export_api_audit_new RENAME TO export_api_audit;
+
+CREATE INDEX idx_export_api_audit_created_at ON export_api_audit (created_at);
+`,
+	},
+	{
+		Version:     6,
+		Description: "daily statistics rollups for the export api",
+		SQL: `
+CREATE TABLE stats_rollups (
+  name TEXT NOT NULL,
+  bucket TIMESTAMP NOT NULL,
+  country_code TEXT NOT NULL DEFAULT '',
+  asn INTEGER NOT NULL DEFAULT 0,
+  label TEXT NOT NULL DEFAULT '',
+  count INTEGER NOT NULL,
+  PRIMARY KEY (name, bucket, country_code, asn, label)
+);
+`,
+	},
+	{
+		Version:     7,
+		Description: "webhook subscriptions for publish events",
+		SQL: `
+CREATE TABLE webhook_subscriptions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  country_code TEXT NOT NULL DEFAULT '',
+  enabled BOOLEAN NOT NULL DEFAULT 1,
+  last_event_id INTEGER NOT NULL DEFAULT 0,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+`,
+	},
+	{
+		Version:     8,
+		Description: "re-verification sessions",
+		SQL: `
+ALTER TABLE session_reporters ADD COLUMN reverify BOOLEAN NOT NULL DEFAULT 0;
+`,
+	},
+	{
+		Version:     9,
+		Description: "not blocked publish candidates",
+		SQL: `
+ALTER TABLE publish_candidates ADD COLUMN not_blocked BOOLEAN NOT NULL DEFAULT 0;
+`,
+	},
+}
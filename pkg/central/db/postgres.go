@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/squirrel"
@@ -19,10 +20,16 @@ import (
 // DB .
 type DB struct {
 	*sql.DB
-	cache squirrel.DBProxyBeginner // statement cache
-
+	cache   squirrel.DBProxyBeginner // statement cache
+	dialect string                   // one of the Dialect constants
 }
 
+// SQL dialects supported by DB.
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite3"
+)
+
 type DBClient interface {
 
 	// internal house keeping
@@ -31,17 +38,36 @@ type DBClient interface {
 
 	// client/server api
 	IsURLAllowed(url *url.URL, countryCode string) (bool, error)
-	RecentSuggestionSessions(n uint64) ([]tokenData, error)
+	RecentSuggestionSessions(n uint64) ([]TokenData, error)
 
 	InsertSample(s Sample) error
 	InsertSimpleSample(s SimpleSample) error
 	GetSamples(fromID uint64, sampleType string) (chan Sample, error)
 	PublishHost(sample Sample) error
+	PublishHostRule(rule string, countryCode string, ASN int) error
+	UnpublishHostRule(rule string, countryCode string, ASN int) (bool, error)
+	GetPublishedHosts() ([]HostListEntry, error)
+	RecordNotBlocked(host HostListEntry, unpublishAfter int) (bool, error)
+	InsertAnalysisResult(r AnalysisResult) error
+	GetBlockPageSignatures() ([]BlockPageSignature, error)
+	InsertBlockPageSignature(s BlockPageSignature) (uint64, error)
+	DeleteBlockPageSignature(id uint64) (bool, error)
+
+	// sybil resistant publishing
+	InsertSessionReporter(r SessionReporter) error
+	GetSessionReporter(token shared.SuggestionToken) (SessionReporter, bool, error)
+	InsertPublishCandidate(c PublishCandidate) error
+	GetPublishCandidates(host, countryCode string, ASN int, notBlocked bool, since time.Time) ([]PublishCandidate, error)
+	DeletePublishCandidates(host, countryCode string, ASN int) error
+	ResetNotBlocked(host, countryCode string, ASN int) error
+	ExpirePublishCandidates(before time.Time) (int64, error)
+	GetReporterHashKey() ([]byte, error)
 
 	// GetURLSamples(URL string) ([]Sample, error)
 	GetSessionSamples(Token shared.SuggestionToken) ([]Sample, error)
 
 	GetBlockedHosts(CountryCode string, ASN int) ([]string, error)
+	GetCountryWideHosts(fraction float64, minASNs int, activeSince time.Time) (map[string][]string, error)
 	GetRelatedHosts() (map[string][]string, error)
 	GetUpgrade(GetUpgradeQuery) (UpgradeMeta, bool, error)
 	InsertUpgrades([]UpgradeMeta) error
@@ -52,11 +78,29 @@ type DBClient interface {
 	GetExportAPIAuthCredentials(username string) (bool, APICredentials, error)
 	// create or update credentials, does not enable if disabled.
 	InsertExportAPICredentials(credentials APICredentials) error
+	ListExportAPICredentials() ([]APICredentials, error)
+	UpdateExportAPICredentials(credentials APICredentials) (bool, error)
+	SetExportAPICredentialsEnabled(username string, enabled bool) (bool, error)
+	InsertExportAPIAudit(a ExportAPIAudit) error
+	GetExportAPIAudit(username string, limit int) ([]ExportAPIAudit, error)
 
 	// query for exporting data from logs...
 	GetExportBlockedHosts(req shared.BlockedContentRequest) ([]shared.HostsPublishLog, string, error)
 	GetExportSamples(req shared.ExportSampleRequest) ([]shared.ExportSampleEntry, string, error)
 	GetExportSimpleSamples(req shared.ExportSimpleSampleRequest) ([]shared.ExportSimpleSampleEntry, string, error)
+	GetExportAnalysisResults(req shared.ExportAnalysisResultRequest) ([]shared.ExportAnalysisResultEntry, string, error)
+
+	// statistics rollups
+	RefreshStats(name string) (int, error)
+	GetStats(req shared.ExportStatsRequest) ([]shared.ExportStatsEntry, error)
+	GetSessionBuckets(tokens []shared.SuggestionToken) (map[shared.SuggestionToken]SessionBucket, error)
+	InsertWebhookSubscription(s WebhookSubscription) (uint64, error)
+	ListWebhookSubscriptions() ([]WebhookSubscription, error)
+	SetWebhookSubscriptionEnabled(id uint64, enabled bool) (bool, error)
+	DeleteWebhookSubscription(id uint64) (bool, error)
+	UpdateWebhookSubscriptionState(s WebhookSubscription) error
+	LatestPublishEventID() (int, error)
+	GetPublishEvents(afterID int, f shared.ExportFilter, limit int) ([]shared.HostsPublishLog, error)
 }
 
 // Sample mirrors the samples postgres table
@@ -84,6 +128,40 @@ type SimpleSample struct {
 	Data        []byte
 }
 
+// AnalysisResult mirrors the analysis_results postgres table
+type AnalysisResult struct {
+	ID              uint64
+	Host            string
+	CountryCode     string
+	ASN             int
+	CreatedAt       time.Time
+	Token           shared.SuggestionToken
+	StatusCodeScore float64 // HTTPHeaderScore.StatusCode
+	RedirectsScore  float64 // HTTPHeaderScore.Redirects
+	ErrorScore      float64 // HTTPHeaderScore.Error
+	Score           float64 // The final combined score
+	Published       bool    // true if the analysis decided to publish the host
+	Scores          []byte  // json encoded scores for all sample types
+}
+
+// Block page signature kinds.
+const (
+	BlockPageTitle   = "title"   // case insensitive substring of the html title
+	BlockPageBody    = "body"    // case insensitive substring of the body prefix
+	BlockPageSimHash = "simhash" // body fingerprint within MaxDistance
+)
+
+// BlockPageSignature mirrors the block_page_signatures postgres table
+type BlockPageSignature struct {
+	ID          uint64
+	CountryCode string // empty matches samples from all countries
+	Kind        string // one of the BlockPage kinds
+	Pattern     string // substring or hex encoded fingerprint depending on Kind
+	MaxDistance int    // max hamming distance for simhash signatures
+	Comment     string
+	CreatedAt   time.Time
+}
+
 // HostListEntry .
 type HostListEntry struct {
 	ID          uint64
@@ -92,6 +170,7 @@ type HostListEntry struct {
 	ASN         int
 	CreatedAt   time.Time
 	Sticky      bool
+	NotBlocked  int // consecutive not blocked verdicts since last published
 }
 
 // UpgradeMeta .
@@ -109,7 +188,34 @@ func Open(connectionString string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db, squirrel.NewStmtCacheProxy(db)}, nil
+	return &DB{db, squirrel.NewStmtCacheProxy(db), DialectPostgres}, nil
+}
+
+// Dialect returns the SQL dialect of the database.
+func (d *DB) Dialect() string {
+	return d.dialect
+}
+
+// builder returns a statement builder using the placeholder format of the
+// database.
+func (d *DB) builder() squirrel.StatementBuilderType {
+	if d.dialect == DialectSQLite {
+		return squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question)
+	}
+	return squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+}
+
+// rebind replaces ? placeholders in query with the placeholder format of the
+// database.
+func (d *DB) rebind(query string) string {
+	if d.dialect == DialectSQLite {
+		return query
+	}
+	query, err := squirrel.Dollar.ReplacePlaceholders(query)
+	if err != nil {
+		panic(err)
+	}
+	return query
 }
 
 func logSQLErr(err error, query squirrel.Sqlizer) {
@@ -138,19 +244,53 @@ func logSQLErr(err error, query squirrel.Sqlizer) {
 
 const PageLength = 1000
 
+// pageLength returns the number of rows to return for a requested page
+// length, which is at most PageLength.
+func pageLength(limit int) uint64 {
+	if limit <= 0 || limit > PageLength {
+		return PageLength
+	}
+	return uint64(limit)
+}
+
+// whereExportFilter adds the conditions of f to s. The filtered columns must
+// exist in the table.
+func whereExportFilter(s squirrel.SelectBuilder, f shared.ExportFilter) squirrel.SelectBuilder {
+	if f.CountryCode != "" {
+		s = s.Where(squirrel.Eq{"country_code": f.CountryCode})
+	}
+	if f.ASN != 0 {
+		s = s.Where(squirrel.Eq{"asn": f.ASN})
+	}
+	if f.Type != "" {
+		s = s.Where(squirrel.Eq{"type": f.Type})
+	}
+	if f.Host != "" {
+		s = s.Where(squirrel.Eq{"host": f.Host})
+	}
+	if !f.Since.IsZero() {
+		s = s.Where("created_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		s = s.Where("created_at < ?", f.Until.UTC())
+	}
+	return s
+}
+
 func (d *DB) GetExportBlockedHosts(req shared.BlockedContentRequest) ([]shared.HostsPublishLog, string, error) {
 	var results []shared.HostsPublishLog
 
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	i := psql.
 		Select("id", "host", "country_code", "asn", "created_at", "sticky", "action").
 		From("hosts_publish_log").
 		OrderBy("id desc").
-		Limit(PageLength + 1)
+		Limit(pageLength(req.Limit) + 1)
 	if req.IDMax != 0 {
 		i = i.Where("id < ?", req.IDMax)
 	}
+	i = whereExportFilter(i, req.ExportFilter)
 	rows, err := i.RunWith(d.cache).Query()
 	if err != nil {
 		logSQLErr(err, &i)
@@ -175,7 +315,7 @@ func (d *DB) GetExportBlockedHosts(req shared.BlockedContentRequest) ([]shared.H
 			lg.Warning(err)
 			continue
 		}
-		if count > PageLength {
+		if count > int(pageLength(req.Limit)) {
 			next = item.ID
 		} else {
 			results = append(results, item)
@@ -187,16 +327,17 @@ func (d *DB) GetExportBlockedHosts(req shared.BlockedContentRequest) ([]shared.H
 func (d *DB) GetExportSamples(req shared.ExportSampleRequest) ([]shared.ExportSampleEntry, string, error) {
 	var results []shared.ExportSampleEntry
 
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	i := psql.
 		Select("id", "host", "country_code", "asn", "created_at", "origin", "type", "token", "data", "extra_data").
 		From("samples").
 		OrderBy("id desc").
-		Limit(PageLength + 1)
+		Limit(pageLength(req.Limit) + 1)
 	if req.IDMax != 0 {
 		i = i.Where("id < ?", req.IDMax)
 	}
+	i = whereExportFilter(i, req.ExportFilter)
 	rows, err := i.RunWith(d.cache).Query()
 	if err != nil {
 		logSQLErr(err, &i)
@@ -224,7 +365,7 @@ func (d *DB) GetExportSamples(req shared.ExportSampleRequest) ([]shared.ExportSa
 			lg.Warning(err)
 			continue
 		}
-		if count > PageLength {
+		if count > int(pageLength(req.Limit)) {
 			next = i.ID
 		} else {
 			results = append(results, i)
@@ -236,15 +377,16 @@ func (d *DB) GetExportSamples(req shared.ExportSampleRequest) ([]shared.ExportSa
 func (d *DB) GetExportSimpleSamples(req shared.ExportSimpleSampleRequest) ([]shared.ExportSimpleSampleEntry, string, error) {
 	var results []shared.ExportSimpleSampleEntry
 
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	i := psql.
 		Select("id", "country_code", "asn", "created_at", "type", "origin_id", "data").
 		From("simple_samples").
-		OrderBy("id desc").Limit(PageLength + 1)
+		OrderBy("id desc").Limit(pageLength(req.Limit) + 1)
 	if req.IDMax != 0 {
 		i = i.Where("id < ?", req.IDMax)
 	}
+	i = whereExportFilter(i, req.ExportFilter)
 	rows, err := i.RunWith(d.cache).Query()
 	if err != nil {
 		logSQLErr(err, &i)
@@ -269,7 +411,61 @@ func (d *DB) GetExportSimpleSamples(req shared.ExportSimpleSampleRequest) ([]sha
 			lg.Warning(err)
 			continue
 		}
-		if count > PageLength {
+		if count > int(pageLength(req.Limit)) {
+			next = i.ID
+		} else {
+			results = append(results, i)
+		}
+	}
+	return results, next, nil
+}
+
+func (d *DB) GetExportAnalysisResults(req shared.ExportAnalysisResultRequest) ([]shared.ExportAnalysisResultEntry, string, error) {
+	var results []shared.ExportAnalysisResultEntry
+
+	psql := d.builder()
+
+	i := psql.
+		Select("id", "host", "country_code", "asn", "created_at", "token",
+			"status_code_score", "redirects_score", "error_score",
+			"score", "published", "scores").
+		From("analysis_results").
+		OrderBy("id desc").
+		Limit(pageLength(req.Limit) + 1)
+	if req.IDMax != 0 {
+		i = i.Where("id < ?", req.IDMax)
+	}
+	i = whereExportFilter(i, req.ExportFilter)
+	rows, err := i.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &i)
+		return nil, "", err
+	}
+	defer rows.Close()
+	next := ""
+	count := 0
+	for rows.Next() {
+		var i shared.ExportAnalysisResultEntry
+		err := rows.Scan(
+			&i.ID,
+			&i.Host,
+			&i.CountryCode,
+			&i.ASN,
+			&i.CreatedAt,
+			&i.Token,
+			&i.StatusCodeScore,
+			&i.RedirectsScore,
+			&i.ErrorScore,
+			&i.Score,
+			&i.Published,
+			&i.Scores,
+		)
+		count++
+		if err != nil {
+			lg.Warning(err)
+			continue
+		}
+		if count > int(pageLength(req.Limit)) {
 			next = i.ID
 		} else {
 			results = append(results, i)
@@ -279,16 +475,14 @@ func (d *DB) GetExportSimpleSamples(req shared.ExportSimpleSampleRequest) ([]sha
 }
 
 func (d *DB) GetExportAPIAuthCredentials(username string) (bool, APICredentials, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 	i := psql.
-		Select("enabled", "username", "hash", "salt").
+		Select(exportAPICredentialsColumns...).
 		From("export_api_auth").
 		Where(squirrel.Eq{"username": username}).
 		Limit(1)
 	row := i.RunWith(d.cache).QueryRow()
-	cred := APICredentials{}
-	var hashstr, saltstr string
-	err := row.Scan(&cred.Enabled, &cred.Username, &hashstr, &saltstr)
+	cred, err := scanExportAPICredentials(row)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -297,20 +491,66 @@ func (d *DB) GetExportAPIAuthCredentials(username string) (bool, APICredentials,
 			return false, APICredentials{}, err
 		}
 	}
+	return true, cred, nil
+}
+
+var exportAPICredentialsColumns = []string{
+	"id", "enabled", "username", "hash", "salt", "scopes", "generation", "created_at"}
+
+func scanExportAPICredentials(row squirrel.RowScanner) (APICredentials, error) {
+	cred := APICredentials{}
+	var hashstr, saltstr, scopes string
+	var createdAt *time.Time // nullable
+	err := row.Scan(&cred.ID, &cred.Enabled, &cred.Username, &hashstr, &saltstr,
+		&scopes, &cred.Generation, &createdAt)
+	if err != nil {
+		return APICredentials{}, err
+	}
+	if createdAt != nil {
+		cred.CreatedAt = *createdAt
+	}
 
 	hashdata, err := base64.StdEncoding.DecodeString(hashstr)
 	if err != nil {
-		return false, APICredentials{}, err
+		return APICredentials{}, err
 	}
 	cred.PasswordHash = hashdata
 
 	saltdata, err := base64.StdEncoding.DecodeString(saltstr)
 	if err != nil {
-		return false, APICredentials{}, err
+		return APICredentials{}, err
 	}
 	cred.Salt = saltdata
 
-	return true, cred, nil
+	if scopes != "" {
+		cred.Scopes = strings.Split(scopes, ",")
+	}
+	return cred, nil
+}
+
+// ListExportAPICredentials returns all export api credentials ordered by
+// username.
+func (d *DB) ListExportAPICredentials() ([]APICredentials, error) {
+	psql := d.builder()
+	s := psql.
+		Select(exportAPICredentialsColumns...).
+		From("export_api_auth").
+		OrderBy("username")
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var creds []APICredentials
+	for rows.Next() {
+		cred, err := scanExportAPICredentials(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
 }
 
 func (d *DB) InsertExportAPICredentials(cred APICredentials) error {
@@ -324,23 +564,66 @@ func (d *DB) InsertExportAPICredentials(cred APICredentials) error {
 		return errors.New("Username not set")
 	}
 
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	hashstr := base64.StdEncoding.EncodeToString(cred.PasswordHash)
 	saltstr := base64.StdEncoding.EncodeToString(cred.Salt)
 
 	i := psql.Insert("export_api_auth").
-		Columns("username", "hash", "salt").
-		Values(cred.Username, hashstr, saltstr)
+		Columns("username", "hash", "salt", "scopes").
+		Values(cred.Username, hashstr, saltstr, strings.Join(cred.Scopes, ","))
 
 	_, err := i.RunWith(d.cache).Exec()
 	logSQLErr(err, &i)
 	return err
 }
 
+// UpdateExportAPICredentials replaces the password hash and scopes of the
+// credentials for cred.Username and increases their generation so that
+// tokens issued before are rejected. Returns false if there are no
+// credentials for the user.
+func (d *DB) UpdateExportAPICredentials(cred APICredentials) (bool, error) {
+	if cred.Salt == nil {
+		return false, errors.New("Salt not set")
+	}
+	if cred.PasswordHash == nil {
+		return false, errors.New("Passwordhash not set")
+	}
+	psql := d.builder()
+	u := psql.Update("export_api_auth").
+		Set("hash", base64.StdEncoding.EncodeToString(cred.PasswordHash)).
+		Set("salt", base64.StdEncoding.EncodeToString(cred.Salt)).
+		Set("scopes", strings.Join(cred.Scopes, ",")).
+		Set("generation", squirrel.Expr("generation + 1")).
+		Where(squirrel.Eq{"username": cred.Username})
+	res, err := u.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &u)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetExportAPICredentialsEnabled enables or disables the credentials for
+// username. Returns false if there are no credentials for the user.
+func (d *DB) SetExportAPICredentialsEnabled(username string, enabled bool) (bool, error) {
+	psql := d.builder()
+	u := psql.Update("export_api_auth").
+		Set("enabled", enabled).
+		Where(squirrel.Eq{"username": username})
+	res, err := u.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &u)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
 // InsertSample inserts a Sample into the samples table.
 func (d *DB) InsertSample(s Sample) error {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	columns := []string{
 		"host", "country_code", "asn",
@@ -364,7 +647,7 @@ func (d *DB) InsertSample(s Sample) error {
 
 // InsertSample inserts a Sample into the samples table.
 func (d *DB) InsertSimpleSample(s SimpleSample) error {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	columns := []string{"country_code", "asn", "type", "origin_id"}
 	var values []interface{}
@@ -380,7 +663,7 @@ func (d *DB) InsertSimpleSample(s SimpleSample) error {
 }
 
 func (d *DB) GetSamples(fromID uint64, sampleType string) (chan Sample, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	i := psql.
 		Select("id", "host", "country_code", "asn", "created_at", "origin", "type", "token", "data", "extra_data").
@@ -422,7 +705,7 @@ func (d *DB) GetSamples(fromID uint64, sampleType string) (chan Sample, error) {
 }
 
 // func (d *DB) GetURLSamples(URL string) ([]Sample, error) {
-// 	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+// 	psql := d.builder()
 
 // 	u, err := url.Parse(URL)
 // 	if err != nil {
@@ -474,7 +757,7 @@ func (d *DB) GetSamples(fromID uint64, sampleType string) (chan Sample, error) {
 // }
 
 func (d *DB) GetSessionSamples(token shared.SuggestionToken) ([]Sample, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	//	fromID := 0
 	i := psql.
@@ -518,7 +801,7 @@ func (d *DB) GetSessionSamples(token shared.SuggestionToken) ([]Sample, error) {
 }
 
 func (d *DB) GetBlockedHosts(CountryCode string, ASN int) ([]string, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 	s := psql.Select("host").From("hosts_publish").Where(squirrel.Eq{
 		"country_code": CountryCode,
 		"asn":          ASN,
@@ -536,14 +819,69 @@ func (d *DB) GetBlockedHosts(CountryCode string, ASN int) ([]string, error) {
 		if err != nil {
 			lg.Fatal(err)
 		}
+		if _, err := shared.ParseHostRule(host); err != nil {
+			lg.Warningf("not publishing invalid host rule: %v", err)
+			continue
+		}
 		hosts = append(hosts, host)
 	}
 	return hosts, nil
 }
 
+// countryWideHostsSQL selects hosts which are published for enough of the
+// ASNs in a country. The ASNs in a country are the ones which clients has
+// requested host lists from since the first argument together with the ones
+// which has published hosts.
+const countryWideHostsSQL = `
+WITH active AS (
+  SELECT country_code, asn FROM simple_samples
+   WHERE type = 'ClientBlocklistUpdate' AND created_at > ?
+     AND country_code IS NOT NULL AND asn IS NOT NULL AND asn <> 0
+  UNION
+  SELECT country_code, asn FROM hosts_publish
+   WHERE country_code IS NOT NULL AND asn IS NOT NULL AND asn <> 0
+), asns AS (
+  SELECT country_code, count(*) AS n FROM active GROUP BY country_code
+)
+SELECT p.host, CAST(p.country_code AS text)
+  FROM hosts_publish p JOIN asns a ON a.country_code = p.country_code
+ WHERE p.asn IS NOT NULL AND p.asn <> 0
+ GROUP BY p.host, p.country_code, a.n
+HAVING count(DISTINCT p.asn) >= ? AND count(DISTINCT p.asn) >= ? * a.n`
+
+// GetCountryWideHosts returns hosts by country code which are published for
+// at least minASNs and at least fraction of the ASNs seen in the country since
+// activeSince.
+func (d *DB) GetCountryWideHosts(fraction float64, minASNs int, activeSince time.Time) (map[string][]string, error) {
+	query := d.rebind(countryWideHostsSQL)
+	if d.dialect == DialectSQLite {
+		// sqlite compares timestamps as text in UTC.
+		activeSince = activeSince.UTC()
+	}
+	q := squirrel.Expr(query, activeSince, minASNs, fraction)
+	rows, err := d.cache.Query(query, activeSince, minASNs, fraction)
+	if err != nil {
+		logSQLErr(err, q)
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string][]string, 0)
+	for rows.Next() {
+		var host, countryCode string
+		if err := rows.Scan(&host, &countryCode); err != nil {
+			return nil, err
+		}
+		if _, err := shared.ParseHostRule(host); err != nil {
+			continue
+		}
+		result[countryCode] = append(result[countryCode], host)
+	}
+	return result, rows.Err()
+}
+
 func (d *DB) GetRelatedHosts() (map[string][]string, error) {
 	result := make(map[string][]string, 0)
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 	s := psql.Select("host", "related").From("hosts_related")
 	rows, err := s.RunWith(d.cache).Query()
 	if err != nil {
@@ -572,7 +910,7 @@ func (d *DB) GetRelatedHosts() (map[string][]string, error) {
 
 // InsertUpgrades inserts a list of upgrade entreis into the database
 func (d *DB) InsertUpgrades(u []UpgradeMeta) error {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	tx, err := d.cache.Begin()
 	if err != nil {
@@ -607,7 +945,7 @@ type GetUpgradeQuery struct {
 }
 
 func (d *DB) GetUpgrade(q GetUpgradeQuery) (UpgradeMeta, bool, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	wh := squirrel.Eq{"artifact": q.Artifact}
 
@@ -646,7 +984,7 @@ func (d *DB) GetUpgrade(q GetUpgradeQuery) (UpgradeMeta, bool, error) {
 }
 
 func (d *DB) PublishHost(sample Sample) error {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 
 	s := psql.Select("1").From("hosts_publish").Where(squirrel.Eq{
 		"host":         sample.Host,
@@ -671,25 +1009,257 @@ func (d *DB) PublishHost(sample Sample) error {
 			logSQLErr(err, &i)
 			return err
 		}
+		return nil
+	}
+
+	// a new blocked verdict breaks any series of not blocked verdicts.
+	return d.ResetNotBlocked(sample.Host, sample.CountryCode, sample.ASN)
+}
+
+// PublishHostRule adds a manually managed host rule to hosts_publish. Rules
+// are sticky since analysis never produces verdicts for them.
+func (d *DB) PublishHostRule(rule string, countryCode string, ASN int) error {
+	r, err := shared.ParseHostRule(rule)
+	if err != nil {
+		return err
+	}
+	rule = r.String()
+	psql := d.builder()
+	s := psql.Select("1").From("hosts_publish").Where(squirrel.Eq{
+		"host":         rule,
+		"country_code": countryCode,
+		"asn":          ASN,
+	}).Limit(1).Prefix("select exists(").Suffix(")")
+	var exists bool
+	err = s.RunWith(d.cache).QueryRow().Scan(&exists)
+	if err != nil {
+		logSQLErr(err, &s)
+		return err
+	}
+	if exists {
+		return nil
+	}
+	i := psql.Insert("hosts_publish").
+		Columns("host", "country_code", "asn", "sticky").
+		Values(rule, countryCode, ASN, true)
+	_, err = i.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &i)
+		return err
 	}
 	return nil
 }
 
+// UnpublishHostRule removes a host rule from hosts_publish, returns true if
+// it existed.
+func (d *DB) UnpublishHostRule(rule string, countryCode string, ASN int) (bool, error) {
+	if r, err := shared.ParseHostRule(rule); err == nil {
+		rule = r.String()
+	}
+	psql := d.builder()
+	q := psql.Delete("hosts_publish").Where(squirrel.Eq{
+		"host":         rule,
+		"country_code": countryCode,
+		"asn":          ASN,
+	})
+	res, err := q.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetPublishedHosts returns all entries in the hosts_publish table.
+func (d *DB) GetPublishedHosts() ([]HostListEntry, error) {
+	psql := d.builder()
+	s := psql.
+		Select("id", "host", "country_code", "asn", "created_at", "sticky", "not_blocked_count").
+		From("hosts_publish").
+		OrderBy("id")
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var hosts []HostListEntry
+	for rows.Next() {
+		var h HostListEntry
+		err := rows.Scan(
+			&h.ID,
+			&h.Host,
+			&h.CountryCode,
+			&h.ASN,
+			&h.CreatedAt,
+			&h.Sticky,
+			&h.NotBlocked,
+		)
+		if err != nil {
+			lg.Warning(err)
+			continue
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// RecordNotBlocked counts a not blocked verdict for a published host. The
+// host is removed from hosts_publish when unpublishAfter not blocked verdicts
+// has been recorded since the last blocked verdict, see ResetNotBlocked. Sticky hosts are never removed. Returns
+// true if the host was removed.
+func (d *DB) RecordNotBlocked(host HostListEntry, unpublishAfter int) (bool, error) {
+	psql := d.builder()
+	wh := squirrel.Eq{
+		"host":         host.Host,
+		"country_code": host.CountryCode,
+		"asn":          host.ASN,
+		"sticky":       false,
+	}
+
+	u := psql.Update("hosts_publish").
+		Set("not_blocked_count", squirrel.Expr("not_blocked_count + 1")).
+		Where(wh).
+		Suffix("RETURNING not_blocked_count")
+	var count int
+	err := squirrel.QueryRowWith(d.cache, u).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		logSQLErr(err, &u)
+		return false, err
+	}
+	if count < unpublishAfter {
+		return false, nil
+	}
+
+	del := psql.Delete("hosts_publish").Where(wh)
+	_, err = del.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &del)
+		return false, err
+	}
+	return true, nil
+}
+
+// InsertAnalysisResult inserts an AnalysisResult into the analysis_results table.
+func (d *DB) InsertAnalysisResult(r AnalysisResult) error {
+	psql := d.builder()
+
+	columns := []string{
+		"host", "country_code", "asn", "token",
+		"status_code_score", "redirects_score", "error_score",
+		"score", "published"}
+	var values []interface{}
+	values = append(values,
+		r.Host, r.CountryCode, r.ASN, string(r.Token),
+		r.StatusCodeScore, r.RedirectsScore, r.ErrorScore,
+		r.Score, r.Published)
+	if r.Scores != nil {
+		columns = append(columns, "scores")
+		values = append(values, r.Scores)
+	}
+
+	i := psql.Insert("analysis_results").Columns(columns...).Values(values...)
+	_, err := i.RunWith(d.cache).Exec()
+	logSQLErr(err, &i)
+	return err
+}
+
+// GetBlockPageSignatures returns all block page signatures.
+func (d *DB) GetBlockPageSignatures() ([]BlockPageSignature, error) {
+	psql := d.builder()
+	s := psql.
+		Select("id", "coalesce(CAST(country_code AS text), '')", "kind", "pattern", "max_distance", "comment", "created_at").
+		From("block_page_signatures").
+		OrderBy("id")
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var signatures []BlockPageSignature
+	for rows.Next() {
+		var b BlockPageSignature
+		err := rows.Scan(
+			&b.ID,
+			&b.CountryCode,
+			&b.Kind,
+			&b.Pattern,
+			&b.MaxDistance,
+			&b.Comment,
+			&b.CreatedAt,
+		)
+		if err != nil {
+			lg.Warning(err)
+			continue
+		}
+		signatures = append(signatures, b)
+	}
+	return signatures, rows.Err()
+}
+
+// InsertBlockPageSignature stores a new signature and returns its id.
+func (d *DB) InsertBlockPageSignature(b BlockPageSignature) (uint64, error) {
+	psql := d.builder()
+	var countryCode interface{}
+	if b.CountryCode != "" {
+		countryCode = b.CountryCode
+	}
+	i := psql.Insert("block_page_signatures").
+		Columns("country_code", "kind", "pattern", "max_distance", "comment").
+		Values(countryCode, b.Kind, b.Pattern, b.MaxDistance, b.Comment).
+		Suffix("RETURNING id")
+	var id uint64
+	err := i.RunWith(d.cache).QueryRow().Scan(&id)
+	if err != nil {
+		logSQLErr(err, &i)
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteBlockPageSignature removes a signature, returns true if it existed.
+func (d *DB) DeleteBlockPageSignature(id uint64) (bool, error) {
+	psql := d.builder()
+	q := psql.Delete("block_page_signatures").Where(squirrel.Eq{"id": id})
+	res, err := q.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 // IsURLAllowed returns true if the supplied URL is supported for circumenvtion with alkasir.
 func (d *DB) IsURLAllowed(url *url.URL, countryCode string) (bool, error) {
 	// TODO: Also needs to match ASN
 	var isUnsupported bool
 	err := d.QueryRow(
-		"select exists(select 1 from hosts_unsupported where host = $1 and country_code = $2)",
+		d.rebind("select exists(select 1 from hosts_unsupported where host = ? and country_code = ?)"),
 		url.Host, countryCode,
 	).Scan(&isUnsupported)
 	return !isUnsupported, err
 }
 
-func (d *DB) RecentSuggestionSessions(n uint64) ([]tokenData, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+func (d *DB) RecentSuggestionSessions(n uint64) ([]TokenData, error) {
+	psql := d.builder()
+	urlColumn := "data::json->'URL'"
+	if d.dialect == DialectSQLite {
+		urlColumn = "CAST(data AS text) -> 'URL'"
+	}
 	q := psql.
-		Select("token", "created_at", "data::json->'URL'").
+		Select("token", "created_at", urlColumn).
 		From("samples").
 		Where(squirrel.Eq{"type": "NewClientToken"}).
 		OrderBy("ID desc").
@@ -700,9 +1270,9 @@ func (d *DB) RecentSuggestionSessions(n uint64) ([]tokenData, error) {
 		lg.Fatal(err)
 	}
 	defer rows.Close()
-	var tokens []tokenData
+	var tokens []TokenData
 	for rows.Next() {
-		var token tokenData
+		var token TokenData
 		var ID string
 		err := rows.Scan(&ID, &token.CreatedAt, &token.URL)
 		if err != nil {
@@ -722,7 +1292,7 @@ type kv struct {
 }
 
 func (d *DB) GetLastProcessedSampleID() (uint64, error) {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 	s := psql.Select("value").From("central_state").Where(
 		squirrel.Eq{"name": "last_processed_sample_id"},
 	).Limit(1)
@@ -745,7 +1315,7 @@ func (d *DB) GetLastProcessedSampleID() (uint64, error) {
 }
 
 func (d *DB) SetLastProcessedSampleID(id uint64) error {
-	psql := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	psql := d.builder()
 	idstr := strconv.FormatUint(id, 10)
 
 	s := psql.
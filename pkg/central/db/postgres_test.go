@@ -4,14 +4,18 @@ package db
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/thomasf/lg"
 )
 
 var sqlDB *DB
+var redisServer = flag.String("redisServer", ":39550", "")
 var pgConnString = flag.String("pgconn", "user=alkasir_central password=alkasir_central dbname=alkasir_central port=39558 sslmode=disable", "postgresql connection string")
 
 // InitDB opens a connection to the database.
@@ -37,6 +41,9 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
+	if _, err := sqlDB.Migrate(); err != nil {
+		panic(err)
+	}
 
 	os.Exit(m.Run())
 }
@@ -50,3 +57,97 @@ func TestFeature(t *testing.T) {
 	log.Println(res)
 
 }
+
+func TestPostgres(t *testing.T) {
+	testDBClient(t, sqlDB)
+}
+
+func TestPostgresMigrate(t *testing.T) {
+	if _, err := sqlDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	applied, pending, err := sqlDB.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(postgresMigrations) || len(pending) != 0 {
+		t.Errorf("expected all migrations applied, got %v pending %v", applied, pending)
+	}
+}
+
+// TestPostgresLiquibaseBaseline migrates databases created by the liquibase
+// changelogs, each in its own schema.
+func TestPostgresLiquibaseBaseline(t *testing.T) {
+	open := func(changesets ...string) *DB {
+		schema := fmt.Sprintf("liquibase_%d", time.Now().UnixNano())
+		if _, err := sqlDB.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		d, err := Open(*pgConnString + " search_path=" + schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Exec(`CREATE TABLE databasechangelog (
+  id varchar(255) NOT NULL,
+  author varchar(255) NOT NULL,
+  filename varchar(255) NOT NULL,
+  dateexecuted timestamp NOT NULL DEFAULT now(),
+  orderexecuted integer NOT NULL DEFAULT 0,
+  exectype varchar(10) NOT NULL DEFAULT 'EXECUTED'
+)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range changesets {
+			_, err := d.Exec("INSERT INTO databasechangelog (id, author, filename) VALUES ($1, 'thomasf', 'changelogs/version-0000.xml')", id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return d
+	}
+	drop := func(d *DB) {
+		var schema string
+		if err := d.QueryRow("SELECT current_schema()").Scan(&schema); err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+		if _, err := sqlDB.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// liquibase stopped before the changesets included in migration 1.
+	d := open("20150415-222229-CEST", "20160402-170426-CEST")
+	defer drop(d)
+	if _, err := d.Migrate(); err == nil {
+		t.Error("expected an incomplete liquibase database to be refused")
+	}
+	if applied, _, err := d.MigrationStatus(); err != nil || len(applied) != 0 {
+		t.Errorf("expected no applied migrations, got %v %v", applied, err)
+	}
+
+	// liquibase applied all changesets, which gives the migration 1 schema.
+	d2 := open("20160402-170426-CEST", liquibaseBaselineChangeset)
+	defer drop(d2)
+	if _, err := d2.Exec(postgresMigrations[0].SQL); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := d2.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(postgresMigrations)-1 || pending[0].Version != 2 {
+		t.Errorf("expected migrations after the baseline to be applied, got %v", pending)
+	}
+}
+
+func TestRedisTokenStore(t *testing.T) {
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", *redisServer)
+		},
+	}
+	defer pool.Close()
+	testTokenStore(t, NewRedisTokenStore(pool, time.Minute), NewRedisTokenStore(pool, time.Millisecond))
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"github.com/osrg/gobgp/packet/bgp"
+	"github.com/osrg/gobgp/packet/mrt"
+	"github.com/thomasf/internet"
+)
+
+// PrefixTrie maps IP networks to AS numbers and resolves addresses to the
+// longest matching network.
+type PrefixTrie struct {
+	v4, v6 prefixNode
+	n      int
+}
+
+type prefixNode struct {
+	children [2]*prefixNode
+	asn      uint32
+	set      bool
+}
+
+// NewPrefixTrie returns an empty PrefixTrie.
+func NewPrefixTrie() *PrefixTrie {
+	return &PrefixTrie{}
+}
+
+// Len returns the number of networks in the trie.
+func (t *PrefixTrie) Len() int {
+	return t.n
+}
+
+// root returns the tree for ip and ip in its 4 or 16 byte form.
+func (t *PrefixTrie) root(ip net.IP) (*prefixNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &t.v4, ip4
+	}
+	return &t.v6, ip.To16()
+}
+
+// Insert adds or replaces the AS number of a network. IPv4 networks mapped
+// into IPv6, as found in MaxMind databases, are stored as IPv4 networks.
+func (t *PrefixTrie) Insert(ipnet *net.IPNet, asn int) {
+	ones, bits := ipnet.Mask.Size()
+	if bits == 0 {
+		return
+	}
+	node, ip := t.root(ipnet.IP)
+	if ip == nil {
+		return
+	}
+	if len(ip) == net.IPv4len && bits == 8*net.IPv6len {
+		if ones < 96 {
+			return
+		}
+		ones -= 96
+	}
+	for i := 0; i < ones; i++ {
+		b := ip[i/8] >> uint(7-i%8) & 1
+		if node.children[b] == nil {
+			node.children[b] = &prefixNode{}
+		}
+		node = node.children[b]
+	}
+	if !node.set {
+		t.n++
+	}
+	node.asn = uint32(asn)
+	node.set = true
+}
+
+// Lookup returns the longest network containing ip and its AS number, ok is
+// false if no network contains ip.
+func (t *PrefixTrie) Lookup(ip net.IP) (ipnet *net.IPNet, asn int, ok bool) {
+	node, ip := t.root(ip)
+	if ip == nil {
+		return nil, 0, false
+	}
+	depth := -1
+	for i := 0; node != nil; i++ {
+		if node.set {
+			depth, asn = i, int(node.asn)
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		node = node.children[ip[i/8]>>uint(7-i%8)&1]
+	}
+	if depth < 0 {
+		return nil, 0, false
+	}
+	mask := net.CIDRMask(depth, len(ip)*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, asn, true
+}
+
+// LoadPrefixFile reads a network to AS number table from path.
+//
+// Files with the .mmdb extension are read as MaxMind ASN databases, other
+// files can be RIPE RIS or routeviews MRT table dumps, routeviews prefix2as
+// files or bgpdump -m output, optionally gzip compressed.
+func LoadPrefixFile(path string) (*PrefixTrie, error) {
+	if strings.HasSuffix(path, ".mmdb") {
+		return loadMaxmindASN(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create gzip reader: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	t := NewPrefixTrie()
+	br = bufio.NewReader(r)
+	head, _ := br.Peek(mrt.MRT_COMMON_HEADER_LEN)
+	if bytes.IndexByte(head, 0) >= 0 {
+		err = t.readMRT(br)
+	} else {
+		err = t.readText(br, filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readMRT reads the RIB entries of a TABLE_DUMPv2 MRT dump.
+func (t *PrefixTrie) readMRT(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	s.Split(mrt.SplitMrt)
+	for s.Scan() {
+		data := s.Bytes()
+		hdr := &mrt.MRTHeader{}
+		if err := hdr.DecodeFromBytes(data[:mrt.MRT_COMMON_HEADER_LEN]); err != nil {
+			return err
+		}
+		if hdr.Type != mrt.TABLE_DUMPv2 {
+			return fmt.Errorf("unexpected mrt message type: %d", hdr.Type)
+		}
+		msg, err := mrt.ParseMRTBody(hdr, data[mrt.MRT_COMMON_HEADER_LEN:])
+		if err != nil {
+			// unsupported address families are skipped.
+			continue
+		}
+		rib, ok := msg.Body.(*mrt.Rib)
+		if !ok {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(rib.Prefix.String())
+		if err != nil {
+			continue
+		}
+		for _, entry := range rib.Entries {
+			if asn, ok := originASN(entry.PathAttributes); ok {
+				t.Insert(ipnet, asn)
+				break
+			}
+		}
+	}
+	return s.Err()
+}
+
+// originASN returns the last AS of the AS_PATH attribute.
+func originASN(attrs []bgp.PathAttributeInterface) (int, bool) {
+	for _, attr := range attrs {
+		path, ok := attr.(*bgp.PathAttributeAsPath)
+		if !ok || len(path.Value) == 0 {
+			continue
+		}
+		switch v := path.Value[len(path.Value)-1].(type) {
+		case *bgp.As4PathParam:
+			if v.Type == bgp.BGP_ASPATH_ATTR_TYPE_SEQ && len(v.AS) > 0 {
+				return int(v.AS[len(v.AS)-1]), true
+			}
+		case *bgp.AsPathParam:
+			if v.Type == bgp.BGP_ASPATH_ATTR_TYPE_SEQ && len(v.AS) > 0 {
+				return int(v.AS[len(v.AS)-1]), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// readText reads routeviews prefix2as lines (address, prefix length and
+// origin AS) or bgpdump -m lines. Multi origin and AS set origins use the
+// first AS.
+func (t *PrefixTrie) readText(r io.Reader, name string) error {
+	s := bufio.NewScanner(r)
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parseErr := func(msg string) error {
+			return internet.ParseError{
+				Message: msg,
+				Path:    name,
+				LineNum: lineNum,
+				Line:    line,
+			}
+		}
+		var prefix, origin string
+		if cols := strings.Split(line, "|"); len(cols) > 1 {
+			// TABLE_DUMP2|time|B|peer address|peer as|prefix|as path|...
+			if len(cols) < 7 {
+				return parseErr("too few columns")
+			}
+			path := strings.Fields(cols[6])
+			if len(path) == 0 {
+				return parseErr("no ASPATH data")
+			}
+			prefix, origin = cols[5], path[len(path)-1]
+		} else {
+			fields := strings.Fields(line)
+			switch len(fields) {
+			case 2:
+				prefix, origin = fields[0], fields[1]
+			case 3:
+				prefix, origin = fields[0]+"/"+fields[1], fields[2]
+			default:
+				return parseErr("unexpected number of columns")
+			}
+		}
+		origin = strings.TrimLeft(origin, "{")
+		if i := strings.IndexAny(origin, "_,}"); i >= 0 {
+			origin = origin[:i]
+		}
+		_, ipnet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return parseErr("invalid prefix")
+		}
+		asn, err := strconv.Atoi(origin)
+		if err != nil {
+			return parseErr("invalid AS number")
+		}
+		t.Insert(ipnet, asn)
+	}
+	return s.Err()
+}
+
+// loadMaxmindASN reads all networks of a MaxMind ASN database.
+func loadMaxmindASN(path string) (*PrefixTrie, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	t := NewPrefixTrie()
+	networks := r.Networks()
+	for networks.Next() {
+		var record onlyASN
+		ipnet, err := networks.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		if record.ASN != 0 {
+			t.Insert(ipnet, int(record.ASN))
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type onlyASN struct {
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
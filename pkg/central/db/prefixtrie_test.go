@@ -0,0 +1,232 @@
+package db
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osrg/gobgp/packet/bgp"
+	"github.com/osrg/gobgp/packet/mrt"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	trie := NewPrefixTrie()
+	for _, v := range []struct {
+		cidr string
+		asn  int
+	}{
+		{"10.0.0.0/8", 1},
+		{"10.1.0.0/16", 2},
+		{"10.1.2.0/24", 3},
+		{"::ffff:192.168.0.0/112", 4},
+		{"2001:db8::/32", 5},
+		{"0.0.0.0/0", 6},
+	} {
+		_, ipnet, err := net.ParseCIDR(v.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trie.Insert(ipnet, v.asn)
+	}
+	if trie.Len() != 6 {
+		t.Errorf("expected 6 networks, got %d", trie.Len())
+	}
+
+	for _, v := range []struct {
+		ip   string
+		cidr string
+		asn  int
+	}{
+		{"10.2.3.4", "10.0.0.0/8", 1},
+		{"10.1.3.4", "10.1.0.0/16", 2},
+		{"10.1.2.4", "10.1.2.0/24", 3},
+		{"192.168.1.1", "192.168.0.0/16", 4},
+		{"2001:db8::1", "2001:db8::/32", 5},
+		{"8.8.8.8", "0.0.0.0/0", 6},
+		{"2001:db9::1", "", 0},
+	} {
+		ipnet, asn, ok := trie.Lookup(net.ParseIP(v.ip))
+		if v.cidr == "" {
+			if ok {
+				t.Errorf("%s: expected no match, got %s", v.ip, ipnet)
+			}
+			continue
+		}
+		if !ok || ipnet.String() != v.cidr || asn != v.asn {
+			t.Errorf("%s: expected %s %d, got %v %d %v", v.ip, v.cidr, v.asn, ipnet, asn, ok)
+		}
+	}
+}
+
+func TestLoadPrefixFileText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefixtrie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pfx2as := filepath.Join(dir, "routeviews-rv2-20261018-1200.pfx2as")
+	err = ioutil.WriteFile(pfx2as, []byte(`1.0.0.0	24	13335
+1.0.4.0	22	38803_56203
+2001:200::	32	2500
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bgpdump := filepath.Join(dir, "bview.txt.gz")
+	f, err := os.Create(bgpdump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(`TABLE_DUMP2|1476748800|B|202.12.28.1|4777|1.0.0.0/24|4777 2516 13335|IGP
+TABLE_DUMP2|1476748800|B|202.12.28.1|4777|1.0.4.0/22|4777 4826 {38803,56203}|IGP
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	for _, path := range []string{pfx2as, bgpdump} {
+		trie, err := LoadPrefixFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if _, asn, _ := trie.Lookup(net.ParseIP("1.0.0.1")); asn != 13335 {
+			t.Errorf("%s: expected 13335, got %d", path, asn)
+		}
+		if _, asn, _ := trie.Lookup(net.ParseIP("1.0.5.1")); asn != 38803 {
+			t.Errorf("%s: expected 38803, got %d", path, asn)
+		}
+	}
+
+	invalid := filepath.Join(dir, "invalid.txt")
+	if err := ioutil.WriteFile(invalid, []byte("1.0.0.0 24 x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrefixFile(invalid); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestLoadPrefixFileMRT(t *testing.T) {
+	var data []byte
+	for i, v := range []struct {
+		prefix bgp.AddrPrefixInterface
+		path   []uint32
+	}{
+		{bgp.NewIPAddrPrefix(24, "1.0.0.0"), []uint32{4777, 2516, 13335}},
+		{bgp.NewIPv6AddrPrefix(32, "2001:200::"), []uint32{4777, 2500}},
+	} {
+		attrs := []bgp.PathAttributeInterface{
+			bgp.NewPathAttributeOrigin(0),
+			bgp.NewPathAttributeAsPath([]bgp.AsPathParamInterface{
+				bgp.NewAs4PathParam(bgp.BGP_ASPATH_ATTR_TYPE_SEQ, v.path),
+			}),
+		}
+		rib := mrt.NewRib(uint32(i), v.prefix, []*mrt.RibEntry{mrt.NewRibEntry(0, 1476748800, attrs)})
+		subtype := mrt.RIB_IPV4_UNICAST
+		if i == 1 {
+			subtype = mrt.RIB_IPV6_UNICAST
+		}
+		msg, err := mrt.NewMRTMessage(1476748800, mrt.TABLE_DUMPv2, subtype, rib)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := msg.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+
+	f, err := ioutil.TempFile("", "bview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	trie, err := LoadPrefixFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trie.Len() != 2 {
+		t.Errorf("expected 2 networks, got %d", trie.Len())
+	}
+	if _, asn, _ := trie.Lookup(net.ParseIP("1.0.0.1")); asn != 13335 {
+		t.Errorf("expected 13335, got %d", asn)
+	}
+	if _, asn, _ := trie.Lookup(net.ParseIP("2001:200::1")); asn != 2500 {
+		t.Errorf("expected 2500, got %d", asn)
+	}
+}
+
+func TestLoadPrefixFileMaxmind(t *testing.T) {
+	// testdata/GeoLite2-ASN-Test.mmdb is an IPv6 database which, like the
+	// GeoLite2 ASN databases, has the IPv4 networks both under ::/96 and
+	// ::ffff:0:0/96. 2001:db8::/32 has a record without an AS number.
+	trie, err := LoadPrefixFile(filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		ip   string
+		cidr string
+		asn  int
+	}{
+		{"1.0.0.1", "1.0.0.0/24", 13335},
+		{"1.0.5.1", "1.0.4.0/22", 38803},
+		{"2001:200::1", "2001:200::/32", 2500},
+	} {
+		ipnet, asn, ok := trie.Lookup(net.ParseIP(v.ip))
+		if !ok || ipnet.String() != v.cidr || asn != v.asn {
+			t.Errorf("%s: expected %s %d, got %v %d %v", v.ip, v.cidr, v.asn, ipnet, asn, ok)
+		}
+	}
+	for _, ip := range []string{"1.0.1.1", "2001:db8::1"} {
+		if _, asn, ok := trie.Lookup(net.ParseIP(ip)); ok {
+			t.Errorf("%s: expected no match, got %d", ip, asn)
+		}
+	}
+
+	invalid := filepath.Join("testdata", "invalid.mmdb")
+	if _, err := LoadPrefixFile(invalid); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+func TestLocalInternet(t *testing.T) {
+	l := NewLocalInternetClient()
+	if _, err := l.IP2ASN(net.ParseIP("1.0.0.1")); err == nil {
+		t.Error("expected error without a table")
+	}
+	trie := NewPrefixTrie()
+	_, ipnet, _ := net.ParseCIDR("1.0.0.0/24")
+	trie.Insert(ipnet, 13335)
+	date := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	l.Replace(trie, date)
+
+	res, err := l.IP2ASN(net.ParseIP("1.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.ASN != 13335 || res.Mask.String() != "1.0.0.0/24" || !res.Date.Equal(date) {
+		t.Errorf("unexpected result: %v", res)
+	}
+	res, err = l.IP2ASN(net.ParseIP("2.0.0.1"))
+	if err != nil || res != nil {
+		t.Errorf("expected no result, got %v %v", res, err)
+	}
+}
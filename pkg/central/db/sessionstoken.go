@@ -1,16 +1,20 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/garyburd/redigo/redis"
 	"github.com/thomasf/lg"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var tokenSessionTimeout = 30 * time.Minute
 var tokenSessionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
 	Name: "suggestion_tokens_count",
 	Help: "Number of active suggestion sessions",
@@ -21,58 +25,111 @@ var tokenSessionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 	Help: "Total suggestion sessions since restart",
 })
 
-type tokenData struct {
-	ID        shared.SuggestionToken
-	CreatedAt time.Time // When the token was created
-	URL       string    // The url related to the token, used for quick validation.
+// TokenData is a suggestion session.
+type TokenData struct {
+	ID          shared.SuggestionToken
+	CreatedAt   time.Time // When the token was created
+	URL         string    // The url related to the token, used for quick validation.
+	Samples     int       // Number of samples stored for the token
+	SampleBytes int64     // Total size of the samples stored for the token
 }
 
-type sessionTokenStore struct {
+// TokenStore stores suggestion session tokens until their TTL has passed.
+type TokenStore interface {
+	// New creates a suggestion session token for URL.
+	New(URL string) (shared.SuggestionToken, error)
+	// Get returns the session data for id unless it is unknown or expired.
+	Get(id shared.SuggestionToken) (TokenData, bool, error)
+	// AddSample counts a sample of size bytes for the session id and
+	// returns the number of samples and bytes counted for it so far.
+	AddSample(id shared.SuggestionToken, size int) (samples int, bytes int64, err error)
+}
+
+var errUnknownToken = errors.New("unknown suggestion token")
+
+func newTokenData(URL string) (TokenData, error) {
+	idstr, err := shared.SecureRandomString(32)
+	if err != nil {
+		return TokenData{}, err
+	}
+	tokenSessionsTotal.Inc()
+	return TokenData{
+		ID:        shared.SuggestionToken(idstr),
+		CreatedAt: time.Now(),
+		URL:       URL,
+	}, nil
+}
+
+// MemoryTokenStore keeps the tokens in process, they are lost on restart and
+// not shared between central processes.
+type MemoryTokenStore struct {
 	sync.RWMutex
-	sessions map[shared.SuggestionToken]tokenData
+	ttl      time.Duration
+	sessions map[shared.SuggestionToken]TokenData
 }
 
-func (s *sessionTokenStore) Get(id shared.SuggestionToken) (tokenData, bool) {
+// NewMemoryTokenStore returns an empty MemoryTokenStore. Expire has to be
+// called regularly to free expired tokens.
+func NewMemoryTokenStore(ttl time.Duration) *MemoryTokenStore {
+	return &MemoryTokenStore{
+		ttl:      ttl,
+		sessions: make(map[shared.SuggestionToken]TokenData, 0),
+	}
+}
+
+func (s *MemoryTokenStore) Get(id shared.SuggestionToken) (TokenData, bool, error) {
 	s.RLock()
 	data, ok := s.sessions[id]
 	s.RUnlock()
-	return data, ok
+	if ok && time.Since(data.CreatedAt) > s.ttl {
+		return TokenData{}, false, nil
+	}
+	return data, ok, nil
 }
 
-func (s *sessionTokenStore) Reset(sessions []tokenData) {
+// Reset replaces all tokens with sessions.
+func (s *MemoryTokenStore) Reset(sessions []TokenData) {
 	s.Lock()
 	defer func() {
 		s.Unlock()
-		s.expireSessions()
+		s.Expire()
 	}()
-	s.sessions = make(map[shared.SuggestionToken]tokenData, 0)
+	s.sessions = make(map[shared.SuggestionToken]TokenData, 0)
 	for _, v := range sessions {
 		s.sessions[v.ID] = v
 	}
 }
 
-func (s *sessionTokenStore) New(URL string) shared.SuggestionToken {
-	idstr, err := shared.SecureRandomString(32)
+func (s *MemoryTokenStore) New(URL string) (shared.SuggestionToken, error) {
+	data, err := newTokenData(URL)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	id := shared.SuggestionToken(idstr)
 	s.Lock()
-	s.sessions[id] = tokenData{
-		ID:        id,
-		CreatedAt: time.Now(),
-		URL:       URL,
-	}
+	s.sessions[data.ID] = data
 	s.Unlock()
 	tokenSessionsActive.Add(1)
-	tokenSessionsTotal.Add(1)
-	return id
+	return data.ID, nil
+}
+
+func (s *MemoryTokenStore) AddSample(id shared.SuggestionToken, size int) (int, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.sessions[id]
+	if !ok {
+		return 0, 0, errUnknownToken
+	}
+	data.Samples++
+	data.SampleBytes += int64(size)
+	s.sessions[id] = data
+	return data.Samples, data.SampleBytes, nil
 }
 
-func (s *sessionTokenStore) expireSessions() {
+// Expire removes all expired tokens.
+func (s *MemoryTokenStore) Expire() {
 	var expired []shared.SuggestionToken
 	start := time.Now()
-	th := start.Add(-tokenSessionTimeout)
+	th := start.Add(-s.ttl)
 	s.RLock()
 	for _, v := range s.sessions {
 		if v.CreatedAt.Before(th) {
@@ -80,31 +137,175 @@ func (s *sessionTokenStore) expireSessions() {
 		}
 	}
 	s.RUnlock()
-	if len(expired) > 0 {
-		s.Lock()
-		for _, v := range expired {
-			delete(s.sessions, v)
-		}
-		tokenSessionsActive.Set(float64(len(s.sessions)))
-		tokenSessionsTotal.Set(float64(len(s.sessions)))
-		s.Unlock()
-		if lg.V(3) {
-			lg.Infof("expired %d sessions in %s", len(expired), time.Now().Sub(start).String())
-		}
+	s.Lock()
+	for _, v := range expired {
+		delete(s.sessions, v)
+	}
+	tokenSessionsActive.Set(float64(len(s.sessions)))
+	s.Unlock()
+	if len(expired) > 0 && lg.V(3) {
+		lg.Infof("expired %d sessions in %s", len(expired), time.Now().Sub(start).String())
+	}
+}
+
+// RedisTokenStore keeps the tokens in redis which expires them.
+type RedisTokenStore struct {
+	pool *redis.Pool
+	ttl  time.Duration
+}
+
+// NewRedisTokenStore returns a RedisTokenStore using pool.
+func NewRedisTokenStore(pool *redis.Pool, ttl time.Duration) *RedisTokenStore {
+	return &RedisTokenStore{pool: pool, ttl: ttl}
+}
+
+func redisTokenKey(id shared.SuggestionToken) string {
+	return "suggestion_token:" + string(id)
+}
+
+func (s *RedisTokenStore) New(URL string) (shared.SuggestionToken, error) {
+	data, err := newTokenData(URL)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	ttl := int64(s.ttl / time.Millisecond)
+	if _, err := conn.Do("SET", redisTokenKey(data.ID), value, "PX", ttl); err != nil {
+		return "", err
 	}
+	return data.ID, nil
+}
+
+func (s *RedisTokenStore) Get(id shared.SuggestionToken) (TokenData, bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("GET", redisTokenKey(id)))
+	if err == redis.ErrNil {
+		return TokenData{}, false, nil
+	}
+	if err != nil {
+		return TokenData{}, false, err
+	}
+	var data TokenData
+	if err := json.Unmarshal(value, &data); err != nil {
+		return TokenData{}, false, err
+	}
+	counts, err := redis.Values(conn.Do("HMGET", redisTokenKey(id)+":samples", "samples", "bytes"))
+	if err != nil {
+		return TokenData{}, false, err
+	}
+	if _, err := redis.Scan(counts, &data.Samples, &data.SampleBytes); err != nil {
+		return TokenData{}, false, err
+	}
+	return data, true, nil
+}
+
+func (s *RedisTokenStore) AddSample(id shared.SuggestionToken, size int) (int, int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	key := redisTokenKey(id) + ":samples"
+	conn.Send("MULTI")
+	conn.Send("HINCRBY", key, "samples", 1)
+	conn.Send("HINCRBY", key, "bytes", size)
+	conn.Send("PEXPIRE", key, int64(s.ttl/time.Millisecond))
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var samples int
+	var bytes int64
+	if _, err := redis.Scan(values, &samples, &bytes); err != nil {
+		return 0, 0, err
+	}
+	return samples, bytes, nil
+}
+
+// DBTokenStore keeps the tokens in the suggestion_tokens table.
+type DBTokenStore struct {
+	db  *DB
+	ttl time.Duration
+}
+
+// NewDBTokenStore returns a DBTokenStore using d. Expire has to be called
+// regularly to delete expired tokens.
+func NewDBTokenStore(d *DB, ttl time.Duration) *DBTokenStore {
+	return &DBTokenStore{db: d, ttl: ttl}
+}
+
+func (s *DBTokenStore) New(URL string) (shared.SuggestionToken, error) {
+	data, err := newTokenData(URL)
+	if err != nil {
+		return "", err
+	}
+	psql := s.db.builder()
+	q := psql.Insert("suggestion_tokens").
+		Columns("token", "url", "created_at", "expires_at").
+		Values(string(data.ID), data.URL, data.CreatedAt.UTC(), data.CreatedAt.Add(s.ttl).Unix())
+	if _, err := q.RunWith(s.db.cache).Exec(); err != nil {
+		logSQLErr(err, &q)
+		return "", err
+	}
+	return data.ID, nil
+}
+
+func (s *DBTokenStore) Get(id shared.SuggestionToken) (TokenData, bool, error) {
+	psql := s.db.builder()
+	q := psql.Select("url", "created_at", "samples", "sample_bytes").
+		From("suggestion_tokens").
+		Where(squirrel.Eq{"token": string(id)}).
+		Where("expires_at > ?", time.Now().Unix())
+	data := TokenData{ID: id}
+	err := q.RunWith(s.db.cache).QueryRow().Scan(
+		&data.URL, &data.CreatedAt, &data.Samples, &data.SampleBytes)
+	if err == sql.ErrNoRows {
+		return TokenData{}, false, nil
+	}
+	if err != nil {
+		logSQLErr(err, &q)
+		return TokenData{}, false, err
+	}
+	return data, true, nil
+}
+
+func (s *DBTokenStore) AddSample(id shared.SuggestionToken, size int) (int, int64, error) {
+	psql := s.db.builder()
+	q := psql.Update("suggestion_tokens").
+		Set("samples", squirrel.Expr("samples + 1")).
+		Set("sample_bytes", squirrel.Expr("sample_bytes + ?", size)).
+		Where(squirrel.Eq{"token": string(id)}).
+		Suffix("RETURNING samples, sample_bytes")
+	var samples int
+	var bytes int64
+	err := squirrel.QueryRowWith(s.db.cache, q).Scan(&samples, &bytes)
+	if err == sql.ErrNoRows {
+		return 0, 0, errUnknownToken
+	}
+	if err != nil {
+		logSQLErr(err, &q)
+		return 0, 0, err
+	}
+	return samples, bytes, nil
+}
+
+// Expire deletes all expired tokens and returns the number of deleted tokens.
+func (s *DBTokenStore) Expire() (int64, error) {
+	psql := s.db.builder()
+	q := psql.Delete("suggestion_tokens").
+		Where("expires_at <= ?", time.Now().Unix())
+	res, err := q.RunWith(s.db.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 func init() {
 	prometheus.MustRegister(tokenSessionsActive)
 	prometheus.MustRegister(tokenSessionsTotal)
-	go func() {
-		for {
-			<-time.After(10 * time.Second)
-			SessionTokens.expireSessions()
-		}
-	}()
-}
-
-var SessionTokens = sessionTokenStore{
-	sessions: make(map[shared.SuggestionToken]tokenData, 0),
 }
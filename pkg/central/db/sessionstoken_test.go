@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// testTokenStore tests s, tokens created by expired must be expired within
+// 10ms.
+func testTokenStore(t *testing.T, s TokenStore, expired TokenStore) {
+	token, err := s.New("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ok, err := s.Get(token)
+	if err != nil || !ok {
+		t.Fatalf("expected token: %v %v", ok, err)
+	}
+	if data.ID != token || data.URL != "http://example.com/" || data.CreatedAt.IsZero() {
+		t.Errorf("unexpected token data: %+v", data)
+	}
+	for i, size := range []int{10, 20} {
+		samples, bytes, err := s.AddSample(token, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if samples != i+1 || bytes != int64(10+i*20) {
+			t.Errorf("unexpected sample counts: %d %d", samples, bytes)
+		}
+	}
+	data, _, err = s.Get(token)
+	if err != nil || data.Samples != 2 || data.SampleBytes != 30 {
+		t.Errorf("unexpected sample counts in token data: %+v %v", data, err)
+	}
+	if _, ok, err := s.Get("missing"); err != nil || ok {
+		t.Errorf("expected no token: %v %v", ok, err)
+	}
+
+	token, err = expired.New("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok, err := expired.Get(token); err != nil || ok {
+		t.Errorf("expected token to be expired: %v %v", ok, err)
+	}
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	s := NewMemoryTokenStore(time.Minute)
+	testTokenStore(t, s, NewMemoryTokenStore(-time.Minute))
+
+	s.Reset([]TokenData{
+		{ID: "old", CreatedAt: time.Now().Add(-time.Hour)},
+		{ID: "new", CreatedAt: time.Now()},
+	})
+	if len(s.sessions) != 1 {
+		t.Errorf("expected expired sessions to be removed, got %v", s.sessions)
+	}
+	if _, ok, _ := s.Get("new"); !ok {
+		t.Error("expected token to exist")
+	}
+}
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	_ "github.com/mattn/go-sqlite3" // add sqlite driver
+)
+
+// OpenSQLite opens or creates an embedded SQLite database at path and
+// migrates it to the latest schema version.
+func OpenSQLite(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=10000&_journal_mode=WAL&_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+	d := &DB{db, squirrel.NewStmtCacheProxy(db), DialectSQLite}
+	if _, err := d.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
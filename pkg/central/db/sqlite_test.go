@@ -0,0 +1,38 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "central.sqlite")
+
+	d, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDBClient(t, d)
+	d.Close()
+
+	// reopening does not run any migrations
+	d, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	applied, pending, err := d.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(sqliteMigrations) || len(pending) != 0 {
+		t.Errorf("expected all migrations applied, got %v pending %v", applied, pending)
+	}
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/thomasf/lg"
+)
+
+// Names of the statistics rollups.
+const (
+	StatsSuggestions    = "suggestions"     // suggestion sessions by country and ASN, without re-verifications
+	StatsPublishEvents  = "publish_events"  // hosts publish log entries by country, ASN and action
+	StatsActiveClients  = "active_clients"  // distinct update ids requesting host lists by country and ASN
+	StatsClientVersions = "client_versions" // distinct update ids by country and client version
+
+	// StatsSessions counts the distinct reporters of suggestion sessions by
+	// country, ASN, city and day for the privacy filter of the export api,
+	// it is not exported. Re-verification sessions are not counted.
+	StatsSessions = "sessions"
+)
+
+// StatsNames lists the statistics rollups.
+var StatsNames = []string{StatsSuggestions, StatsPublishEvents, StatsActiveClients, StatsClientVersions}
+
+// statsSource describes the rows counted by a statistics rollup.
+type statsSource struct {
+	table       string
+	join        string           // optional join clause
+	where       squirrel.Sqlizer // optional
+	label       string           // text expression used as label, if any
+	sqliteLabel string           // label expression for sqlite, if it differs
+	distinct    string           // column to count distinct values of instead of rows
+	noASN       bool             // count by country only
+}
+
+var statsSources = map[string]statsSource{
+	StatsSuggestions: {
+		table: "samples",
+		join:  "LEFT JOIN session_reporters ON session_reporters.token = samples.token",
+		where: squirrel.And{
+			squirrel.Eq{"type": "NewClientToken"},
+			notReverify,
+		},
+	},
+	StatsPublishEvents: {
+		table: "hosts_publish_log",
+		label: "action",
+	},
+	StatsActiveClients: {
+		table:    "simple_samples",
+		where:    squirrel.Eq{"type": "ClientBlocklistUpdate"},
+		distinct: "origin_id",
+	},
+	StatsClientVersions: {
+		table:       "simple_samples",
+		where:       squirrel.Eq{"type": "ClientBlocklistUpdate"},
+		label:       "simple_samples.data->>'version'",
+		sqliteLabel: sqliteJSONLabel("simple_samples.data", "version"),
+		distinct:    "origin_id",
+		noASN:       true,
+	},
+	StatsSessions: {
+		table:       "samples",
+		join:        "JOIN session_reporters ON session_reporters.token = samples.token",
+		where:       squirrel.And{squirrel.Eq{"type": "NewClientToken"}, notReverify},
+		label:       "NULLIF(samples.extra_data->>'CityGeoNameID', '0')",
+		sqliteLabel: "NULLIF(" + sqliteJSONLabel("samples.extra_data", "CityGeoNameID") + ", '0')",
+		distinct:    "session_reporters.addr_hash",
+	},
+}
+
+// notReverify leaves out the samples of re-verification sessions, sessions
+// without a reporter are not re-verifications.
+var notReverify = squirrel.Or{
+	squirrel.Eq{"session_reporters.reverify": nil},
+	squirrel.Eq{"session_reporters.reverify": false},
+}
+
+// sqliteJSONLabel returns an sqlite expression for the text value of field in
+// the json column, or NULL if the column is not valid json.
+func sqliteJSONLabel(column, field string) string {
+	return fmt.Sprintf("CASE WHEN json_valid(CAST(%[1]s AS text)) THEN CAST(json_extract(CAST(%[1]s AS text), '$.%[2]s') AS text) END",
+		column, field)
+}
+
+// cityLabel returns the city geoname id of NewClientToken sample extra data,
+// or an empty string if it is unknown.
+func cityLabel(extraData string) string {
+	var v shared.IPExtraData
+	if err := json.Unmarshal([]byte(extraData), &v); err != nil || v.CityGeoNameID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(v.CityGeoNameID), 10)
+}
+
+// statsDay returns the start of the UTC day of t.
+func statsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// RefreshStats recomputes the named statistics rollup from the start of the
+// latest stored day, or from the first counted row if the rollup is empty,
+// and returns the number of stored rows. The rows are grouped and counted by
+// the database one day at a time.
+func (d *DB) RefreshStats(name string) (int, error) {
+	src, ok := statsSources[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown statistics: %s", name)
+	}
+	psql := d.builder()
+	col := func(name string) string { return src.table + "." + name }
+	source := func(s squirrel.SelectBuilder) squirrel.SelectBuilder {
+		s = s.From(src.table)
+		if src.join != "" {
+			s = s.JoinClause(src.join)
+		}
+		if src.where != nil {
+			s = s.Where(src.where)
+		}
+		return s
+	}
+
+	var from time.Time
+	latest := psql.Select("bucket").From("stats_rollups").
+		Where(squirrel.Eq{"name": name}).
+		OrderBy("bucket desc").Limit(1)
+	err := latest.RunWith(d.cache).QueryRow().Scan(&from)
+	if err == sql.ErrNoRows {
+		var first *time.Time
+		q := source(psql.Select(col("created_at"))).
+			Where(col("created_at") + " IS NOT NULL").
+			OrderBy(col("created_at")).Limit(1)
+		err = q.RunWith(d.cache).QueryRow().Scan(&first)
+		if err == sql.ErrNoRows || (err == nil && first == nil) {
+			return 0, nil
+		}
+		if err != nil {
+			logSQLErr(err, &q)
+			return 0, err
+		}
+		from = *first
+	} else if err != nil {
+		logSQLErr(err, &latest)
+		return 0, err
+	}
+	from = statsDay(from)
+
+	label, asn, count := "''", "0", "count(*)"
+	if src.label != "" {
+		label = src.label
+		if d.dialect == DialectSQLite && src.sqliteLabel != "" {
+			label = src.sqliteLabel
+		}
+	}
+	if !src.noASN {
+		asn = "COALESCE(" + col("asn") + ", 0)"
+	}
+	if src.distinct != "" {
+		count = "count(DISTINCT " + src.distinct + ")"
+	}
+	var total int
+	until := time.Now().UTC()
+	for day := from; day.Before(until); day = day.Add(24 * time.Hour) {
+		s := source(psql.Select(
+			"COALESCE(CAST("+col("country_code")+" AS text), '')",
+			asn,
+			"COALESCE("+label+", '')",
+			count)).
+			Where(col("created_at")+" >= ?", day).
+			Where(col("created_at")+" < ?", day.Add(24*time.Hour)).
+			GroupBy("1", "2", "3")
+		n, err := d.storeStatsDay(name, day, s)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	lg.V(5).Infof("refreshed %d %s statistics from %s", total, name, from)
+	return total, nil
+}
+
+// storeStatsDay replaces the rollup rows of the day with the country code,
+// ASN, label and count rows of the query and returns the number of rows.
+func (d *DB) storeStatsDay(name string, day time.Time, q squirrel.SelectBuilder) (int, error) {
+	psql := d.builder()
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := q.RunWith(tx).Query()
+	if err != nil {
+		logSQLErr(err, &q)
+		tx.Rollback()
+		return 0, err
+	}
+	var entries []shared.ExportStatsEntry
+	for rows.Next() {
+		var e shared.ExportStatsEntry
+		if err := rows.Scan(&e.CountryCode, &e.ASN, &e.Label, &e.Count); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	del := psql.Delete("stats_rollups").
+		Where(squirrel.Eq{"name": name, "bucket": day})
+	if _, err := del.RunWith(tx).Exec(); err != nil {
+		logSQLErr(err, &del)
+		tx.Rollback()
+		return 0, err
+	}
+	for _, e := range entries {
+		i := psql.Insert("stats_rollups").
+			Columns("name", "bucket", "country_code", "asn", "label", "count").
+			Values(name, day, e.CountryCode, e.ASN, e.Label, e.Count)
+		if _, err := i.RunWith(tx).Exec(); err != nil {
+			logSQLErr(err, &i)
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(entries), tx.Commit()
+}
+
+// GetStats returns the days of the statistics rollup req.Name matching the
+// country code, ASN and time range of the filter, ordered by day.
+func (d *DB) GetStats(req shared.ExportStatsRequest) ([]shared.ExportStatsEntry, error) {
+	if _, ok := statsSources[req.Name]; !ok {
+		return nil, fmt.Errorf("unknown statistics: %s", req.Name)
+	}
+	psql := d.builder()
+	s := psql.Select("bucket", "country_code", "asn", "label", "count").
+		From("stats_rollups").
+		Where(squirrel.Eq{"name": req.Name}).
+		OrderBy("bucket", "country_code", "asn", "label")
+	if req.CountryCode != "" {
+		s = s.Where(squirrel.Eq{"country_code": req.CountryCode})
+	}
+	if req.ASN != 0 {
+		s = s.Where(squirrel.Eq{"asn": req.ASN})
+	}
+	if !req.Since.IsZero() {
+		s = s.Where("bucket >= ?", statsDay(req.Since))
+	}
+	if !req.Until.IsZero() {
+		s = s.Where("bucket < ?", req.Until.UTC())
+	}
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []shared.ExportStatsEntry
+	for rows.Next() {
+		var e shared.ExportStatsEntry
+		if err := rows.Scan(&e.Bucket, &e.CountryCode, &e.ASN, &e.Label, &e.Count); err != nil {
+			return nil, err
+		}
+		e.Bucket = e.Bucket.UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SessionBucket is the country, ASN, city and day of a suggestion session
+// which the privacy filter of the export api groups sessions by.
+type SessionBucket struct {
+	CountryCode string
+	ASN         int
+	City        string // geoname id, empty if unknown
+	Day         time.Time
+}
+
+// GetSessionBuckets returns the buckets of the sessions tokens, sessions
+// without a NewClientToken sample are left out.
+func (d *DB) GetSessionBuckets(tokens []shared.SuggestionToken) (map[shared.SuggestionToken]SessionBucket, error) {
+	result := make(map[shared.SuggestionToken]SessionBucket, len(tokens))
+	if len(tokens) == 0 {
+		return result, nil
+	}
+	values := make([]string, len(tokens))
+	for i, v := range tokens {
+		values[i] = string(v)
+	}
+	psql := d.builder()
+	s := psql.Select("token", "country_code", "asn", "created_at", "extra_data").
+		From("samples").
+		Where(squirrel.Eq{"type": "NewClientToken", "token": values})
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			token                  string
+			countryCode, extraData sql.NullString
+			asn                    sql.NullInt64
+			createdAt              time.Time
+		)
+		if err := rows.Scan(&token, &countryCode, &asn, &createdAt, &extraData); err != nil {
+			return nil, err
+		}
+		result[shared.SuggestionToken(token)] = SessionBucket{
+			CountryCode: countryCode.String,
+			ASN:         int(asn.Int64),
+			City:        cityLabel(extraData.String),
+			Day:         statsDay(createdAt),
+		}
+	}
+	return result, rows.Err()
+}
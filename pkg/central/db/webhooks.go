@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+// WebhookSubscription mirrors the webhook_subscriptions table. Publish events
+// after LastEventID are delivered to URL, signed with Secret.
+type WebhookSubscription struct {
+	ID          uint64
+	URL         string
+	Secret      string
+	CountryCode string // only events for this country if set
+	Enabled     bool
+	LastEventID int    // id of the latest delivered hosts_publish_log row
+	Failures    int    // consecutive failed deliveries
+	LastError   string // error of the latest failed delivery
+	CreatedAt   time.Time
+}
+
+// ValidateWebhookURL returns an error unless u is an absolute https url.
+func ValidateWebhookURL(u string) error {
+	v, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if v.Scheme != "https" || v.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute https url: %s", u)
+	}
+	return nil
+}
+
+var webhookSubscriptionColumns = []string{
+	"id", "url", "secret", "country_code", "enabled",
+	"last_event_id", "failures", "last_error", "created_at"}
+
+// InsertWebhookSubscription stores a new subscription which receives the
+// events published after it was created and returns its id.
+func (d *DB) InsertWebhookSubscription(s WebhookSubscription) (uint64, error) {
+	if err := ValidateWebhookURL(s.URL); err != nil {
+		return 0, err
+	}
+	last, err := d.LatestPublishEventID()
+	if err != nil {
+		return 0, err
+	}
+	psql := d.builder()
+	i := psql.Insert("webhook_subscriptions").
+		Columns("url", "secret", "country_code", "enabled", "last_event_id").
+		Values(s.URL, s.Secret, strings.ToUpper(s.CountryCode), true, last).
+		Suffix("RETURNING id")
+	var id uint64
+	if err := i.RunWith(d.cache).QueryRow().Scan(&id); err != nil {
+		logSQLErr(err, &i)
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListWebhookSubscriptions returns all webhook subscriptions.
+func (d *DB) ListWebhookSubscriptions() ([]WebhookSubscription, error) {
+	psql := d.builder()
+	s := psql.Select(webhookSubscriptionColumns...).
+		From("webhook_subscriptions").
+		OrderBy("id")
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []WebhookSubscription
+	for rows.Next() {
+		var v WebhookSubscription
+		err := rows.Scan(&v.ID, &v.URL, &v.Secret, &v.CountryCode, &v.Enabled,
+			&v.LastEventID, &v.Failures, &v.LastError, &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, v)
+	}
+	return subs, rows.Err()
+}
+
+// SetWebhookSubscriptionEnabled enables or disables a subscription and resets
+// its failures, returns true if it existed.
+func (d *DB) SetWebhookSubscriptionEnabled(id uint64, enabled bool) (bool, error) {
+	psql := d.builder()
+	q := psql.Update("webhook_subscriptions").
+		Set("enabled", enabled).
+		Set("failures", 0).
+		Where(squirrel.Eq{"id": id})
+	res, err := q.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteWebhookSubscription removes a subscription, returns true if it
+// existed.
+func (d *DB) DeleteWebhookSubscription(id uint64) (bool, error) {
+	psql := d.builder()
+	q := psql.Delete("webhook_subscriptions").Where(squirrel.Eq{"id": id})
+	res, err := q.RunWith(d.cache).Exec()
+	if err != nil {
+		logSQLErr(err, &q)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// UpdateWebhookSubscriptionState stores the delivery state of s, the last
+// delivered event, failures and last error.
+func (d *DB) UpdateWebhookSubscriptionState(s WebhookSubscription) error {
+	psql := d.builder()
+	q := psql.Update("webhook_subscriptions").
+		Set("last_event_id", s.LastEventID).
+		Set("failures", s.Failures).
+		Set("last_error", s.LastError).
+		Where(squirrel.Eq{"id": s.ID})
+	_, err := q.RunWith(d.cache).Exec()
+	logSQLErr(err, &q)
+	return err
+}
+
+// LatestPublishEventID returns the id of the latest hosts_publish_log row, or
+// 0 if there are none.
+func (d *DB) LatestPublishEventID() (int, error) {
+	psql := d.builder()
+	var id *int // nullable, the log may be empty
+	q := psql.Select("max(id)").From("hosts_publish_log")
+	if err := q.RunWith(d.cache).QueryRow().Scan(&id); err != nil {
+		logSQLErr(err, &q)
+		return 0, err
+	}
+	if id == nil {
+		return 0, nil
+	}
+	return *id, nil
+}
+
+// GetPublishEvents returns at most limit hosts_publish_log rows after the
+// afterID matching the filter, oldest first.
+func (d *DB) GetPublishEvents(afterID int, f shared.ExportFilter, limit int) ([]shared.HostsPublishLog, error) {
+	psql := d.builder()
+	s := psql.
+		Select("id", "host", "country_code", "asn", "created_at", "sticky", "action").
+		From("hosts_publish_log").
+		Where("id > ?", afterID).
+		OrderBy("id").
+		Limit(uint64(limit))
+	s = whereExportFilter(s, f)
+	rows, err := s.RunWith(d.cache).Query()
+	if err != nil {
+		logSQLErr(err, &s)
+		return nil, err
+	}
+	defer rows.Close()
+	var events []shared.HostsPublishLog
+	for rows.Next() {
+		var (
+			e           shared.HostsPublishLog
+			countryCode *string
+			asn         *int
+		)
+		err := rows.Scan(&e.ID, &e.Host, &countryCode, &asn, &e.CreatedAt, &e.Sticky, &e.Action)
+		if err != nil {
+			return nil, err
+		}
+		if countryCode != nil {
+			e.CountryCode = *countryCode
+		}
+		if asn != nil {
+			e.ASN = fmt.Sprint(*asn)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
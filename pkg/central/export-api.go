@@ -3,17 +3,27 @@ package central
 
 import (
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/alkasir/alkasir/pkg/central/db"
 	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
 	"github.com/alkasir/alkasir/pkg/shared/jwtmw"
-	"github.com/alkasir/alkasir/pkg/shared/linkheader"
 	"github.com/ant0ine/go-json-rest/rest"
 )
 
-// apiMux creates the servermux for the json api server
+// apiMux creates the servermux for the json api server.
+//
+// The list endpoints return the newest entries first. A page of json is
+// returned by default, with Link headers to the first and next pages, the
+// next page is requested with the cursor query parameter (id_max is an older
+// name for it) and the page length with limit. Requests which accept
+// application/x-ndjson or text/csv get all entries from the cursor streamed
+// instead. Entries can be filtered with country_code, asn, type, host, since
+// and until query parameters, where since and until are RFC 3339 times or
+// dates. The /v1/stats/ endpoints return daily counts from rollup tables
+// which are not paginated. /v1/events/ streams new publish events as
+// server-sent events.
 func apiMuxExport(dbclients db.Clients, secretKey []byte) (*http.ServeMux, error) {
 	jwtm := &jwtmw.JWTMiddleware{
 		Key:        secretKey,
@@ -34,21 +44,34 @@ func apiMuxExport(dbclients db.Clients, secretKey []byte) (*http.ServeMux, error
 			}
 			return ok
 		},
+		Authorizator: exportAuthorizator(dbclients),
+		PayloadFunc:  exportPayload(dbclients),
 	}
 	var routes = []*rest.Route{
 		{"POST", "/login", jwtm.LoginHandler},
-		{"GET", "/v1/blocked/", GetBlockedHostsExport(dbclients)},
-		{"GET", "/v1/samples/", GetSamplesExport(dbclients)},
-		{"GET", "/v1/simple_samples/", GetSimpleSamplesExport(dbclients)},
+		{"GET", "/v1/blocked/", requireScope(db.ScopeBlockedRead, GetBlockedHostsExport(dbclients))},
+		{"GET", "/v1/events/", requireScope(db.ScopeBlockedRead, GetEventsExport(dbclients, exportAuthorizator(dbclients)))},
+		{"GET", "/v1/samples/", requireScope(db.ScopeSamplesRead, GetSamplesExport(dbclients))},
+		{"GET", "/v1/simple_samples/", requireScope(db.ScopeSamplesRead, GetSimpleSamplesExport(dbclients))},
+		{"GET", "/v1/analysis/", requireScope(db.ScopeSamplesRead, GetAnalysisResultsExport(dbclients))},
+	}
+	for _, name := range db.StatsNames {
+		routes = append(routes, rest.Get("/v1/stats/"+name+"/",
+			requireScope(db.ScopeStatsRead, GetStatsExport(dbclients, name))))
 	}
 	mux := http.NewServeMux()
 	api := defaultAPI("export_api")
 
+	notLogin := func(request *rest.Request) bool {
+		return request.URL.Path != "/login"
+	}
 	api.Use(&rest.IfMiddleware{
-		Condition: func(request *rest.Request) bool {
-			return request.URL.Path != "/login"
-		},
-		IfTrue: jwtm,
+		Condition: notLogin,
+		IfTrue:    jwtm,
+	})
+	api.Use(&rest.IfMiddleware{
+		Condition: notLogin,
+		IfTrue:    &exportAuditMiddleware{dbclients: dbclients},
 	})
 
 	router, err := rest.MakeRouter(routes...)
@@ -62,100 +85,128 @@ func apiMuxExport(dbclients db.Clients, secretKey []byte) (*http.ServeMux, error
 	return mux, nil
 }
 
-// GetBlockedHostsExport
+// GetBlockedHostsExport lists the publish and unpublish events of blocked
+// hosts.
 func GetBlockedHostsExport(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
 	return func(w rest.ResponseWriter, r *rest.Request) {
-		req := shared.BlockedContentRequest{}
-		q := r.Request.URL.Query()
-
-		if q.Get("id_max") != "" {
-			idmax, err := strconv.Atoi(q.Get("id_max"))
-			if err != nil {
-				panic(err)
-			}
-			req.IDMax = idmax
-		}
-
-		hosts, nextpage, err := dbclients.DB.GetExportBlockedHosts(req)
-
-		lh := linkheader.NewLinkHeader(r.URL, "id_max")
-		r.URL.RequestURI()
-		if len(hosts) > 0 {
-			lh.Current(hosts[0].ID)
-		}
-		// len(hosts) > 0
-		if nextpage != "" {
-			lh.Next(hosts[len(hosts)-1].ID)
-		}
-
-		lh.SetHeader(w.Header())
-		err = w.WriteJson(hosts)
+		er, err := parseExportRequest(r, "country_code", "asn", "host")
 		if err != nil {
-			panic(err)
+			apiutils.WriteRestError(w, err)
+			return
 		}
-
+		writeExport(w, r, er, func(idMax, limit int) (interface{}, string, error) {
+			hosts, nextpage, err := dbclients.DB.GetExportBlockedHosts(shared.BlockedContentRequest{
+				IDMax:        idMax,
+				Limit:        limit,
+				ExportFilter: er.filter,
+			})
+			if err != nil || nextpage == "" {
+				return hosts, "", err
+			}
+			return hosts, hosts[len(hosts)-1].ID, nil
+		})
 	}
 }
 
+// GetSamplesExport lists the samples sent by clients, the data fields are
+// only included for the raw-data:read scope. Samples of small session buckets
+// are generalised or suppressed by the privacy filter.
 func GetSamplesExport(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
 	return func(w rest.ResponseWriter, r *rest.Request) {
-		req := shared.ExportSampleRequest{}
-		q := r.Request.URL.Query()
-
-		if q.Get("id_max") != "" {
-			idmax, err := strconv.Atoi(q.Get("id_max"))
-			if err != nil {
-				panic(err)
-			}
-			req.IDMax = idmax
-		}
-
-		samples, nextpage, err := dbclients.DB.GetExportSamples(req)
-
-		lh := linkheader.NewLinkHeader(r.URL, "id_max")
-		r.URL.RequestURI()
-		if len(samples) > 0 {
-			lh.Current(samples[0].ID)
-		}
-		if nextpage != "" {
-			lh.Next(samples[len(samples)-1].ID)
-		}
-		lh.SetHeader(w.Header())
-		err = w.WriteJson(samples)
+		er, err := parseExportRequest(r, "country_code", "asn", "type", "host")
 		if err != nil {
-			panic(err)
+			apiutils.WriteRestError(w, err)
+			return
 		}
+		raw := hasScope(r, db.ScopeRawDataRead)
+		privacy := newPrivacyFilter(dbclients, *exportMinSessions)
+		writeExport(w, r, er, func(idMax, limit int) (interface{}, string, error) {
+			samples, nextpage, err := dbclients.DB.GetExportSamples(shared.ExportSampleRequest{
+				IDMax:        idMax,
+				Limit:        limit,
+				ExportFilter: er.filter,
+			})
+			if err != nil {
+				return samples, "", err
+			}
+			cursor := ""
+			if nextpage != "" {
+				cursor = samples[len(samples)-1].ID
+			}
+			if !raw {
+				for i := range samples {
+					samples[i].Data = ""
+					samples[i].ExtraData = ""
+				}
+			}
+			samples, err = privacy.filterSamples(samples)
+			return samples, cursor, err
+		})
 	}
 }
 
+// GetSimpleSamplesExport lists the simple samples sent by clients, the data
+// field is only included for the raw-data:read scope. Samples from small
+// country and ASN buckets are generalised or suppressed by the privacy filter.
 func GetSimpleSamplesExport(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
 	return func(w rest.ResponseWriter, r *rest.Request) {
-		req := shared.ExportSimpleSampleRequest{}
-		q := r.Request.URL.Query()
-
-		if q.Get("id_max") != "" {
-			idmax, err := strconv.Atoi(q.Get("id_max"))
+		er, err := parseExportRequest(r, "country_code", "asn", "type")
+		if err != nil {
+			apiutils.WriteRestError(w, err)
+			return
+		}
+		raw := hasScope(r, db.ScopeRawDataRead)
+		privacy := newPrivacyFilter(dbclients, *exportMinSessions)
+		writeExport(w, r, er, func(idMax, limit int) (interface{}, string, error) {
+			samples, nextpage, err := dbclients.DB.GetExportSimpleSamples(shared.ExportSimpleSampleRequest{
+				IDMax:        idMax,
+				Limit:        limit,
+				ExportFilter: er.filter,
+			})
 			if err != nil {
-				panic(err)
+				return samples, "", err
 			}
-			req.IDMax = idmax
-		}
-
-		samples, nextpage, err := dbclients.DB.GetExportSimpleSamples(req)
-
-		lh := linkheader.NewLinkHeader(r.URL, "id_max")
-		r.URL.RequestURI()
-		if len(samples) > 0 {
-			lh.Current(samples[0].ID)
-		}
-		if nextpage != "" {
-			lh.Next(samples[len(samples)-1].ID)
-		}
+			cursor := ""
+			if nextpage != "" {
+				cursor = samples[len(samples)-1].ID
+			}
+			if !raw {
+				for i := range samples {
+					samples[i].Data = ""
+				}
+			}
+			samples, err = privacy.filterSimpleSamples(samples)
+			return samples, cursor, err
+		})
+	}
+}
 
-		lh.SetHeader(w.Header())
-		err = w.WriteJson(samples)
+// GetAnalysisResultsExport lists the analysis verdicts and score breakdowns
+// for suggestion sessions. Results of small session buckets are generalised
+// or suppressed by the privacy filter.
+func GetAnalysisResultsExport(dbclients db.Clients) func(w rest.ResponseWriter, r *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		er, err := parseExportRequest(r, "country_code", "asn", "host")
 		if err != nil {
-			panic(err)
+			apiutils.WriteRestError(w, err)
+			return
 		}
+		privacy := newPrivacyFilter(dbclients, *exportMinSessions)
+		writeExport(w, r, er, func(idMax, limit int) (interface{}, string, error) {
+			results, nextpage, err := dbclients.DB.GetExportAnalysisResults(shared.ExportAnalysisResultRequest{
+				IDMax:        idMax,
+				Limit:        limit,
+				ExportFilter: er.filter,
+			})
+			if err != nil {
+				return results, "", err
+			}
+			cursor := ""
+			if nextpage != "" {
+				cursor = results[len(results)-1].ID
+			}
+			results, err = privacy.filterAnalysisResults(results)
+			return results, cursor, err
+		})
 	}
 }
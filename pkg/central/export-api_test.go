@@ -0,0 +1,209 @@
+package central
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/shared/linkheader"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+// testExportHandler returns the export api routes without authentication
+// backed by a sqlite database with five samples, ASN 1 to 5 alternating
+// between SE and IR.
+func testExportHandler(t *testing.T) (http.Handler, func()) {
+	dir, err := ioutil.TempDir("", "alkasir-central-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	// the samples have no sessions, see TestPrivacyFilter.
+	k := *exportMinSessions
+	*exportMinSessions = 0
+	cleanup := func() {
+		*exportMinSessions = k
+		d.Close()
+		os.RemoveAll(dir)
+	}
+	for i := 1; i <= 5; i++ {
+		cc := "SE"
+		if i%2 == 0 {
+			cc = "IR"
+		}
+		err := d.InsertSample(db.Sample{
+			Host:        fmt.Sprintf("host%d.example.com", i),
+			CountryCode: cc,
+			ASN:         i,
+			Origin:      "Client",
+			Type:        "HTTPHeader",
+			Token:       shared.SuggestionToken(fmt.Sprintf("token%d", i)),
+			Data:        []byte(`{}`),
+		})
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	dbclients := db.Clients{DB: d}
+	router, err := rest.MakeRouter(
+		rest.Get("/v1/samples/", GetSamplesExport(dbclients)),
+		rest.Get("/v1/simple_samples/", GetSimpleSamplesExport(dbclients)),
+	)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	api.SetApp(router)
+	return api.MakeHandler(), cleanup
+}
+
+func TestNegotiateExportFormat(t *testing.T) {
+	for accept, expected := range map[string]exportFormat{
+		"":                                exportJSON,
+		"*/*":                             exportJSON,
+		"application/json":                exportJSON,
+		"application/x-ndjson":            exportNDJSON,
+		"application/ndjson; q=0.9":       exportNDJSON,
+		"text/csv":                        exportCSV,
+		"text/html, text/csv;q=0.8":       exportCSV,
+		"application/json, text/csv":      exportJSON,
+		"invalid;;, application/x-ndjson": exportNDJSON,
+	} {
+		if got := negotiateExportFormat(accept); got != expected {
+			t.Errorf("%s: expected %d, got %d", accept, expected, got)
+		}
+	}
+}
+
+func TestExportPagination(t *testing.T) {
+	handler, cleanup := testExportHandler(t)
+	defer cleanup()
+
+	u := "http://localhost/v1/samples/?limit=2"
+	var hosts []string
+	for u != "" {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", u, nil))
+		rec.CodeIs(200)
+		var samples []shared.ExportSampleEntry
+		if err := rec.DecodeJsonPayload(&samples); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range samples {
+			hosts = append(hosts, s.Host)
+		}
+		links, err := linkheader.Parse(rec.Recorder.HeaderMap.Get("Link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		u = ""
+		for _, l := range links {
+			if l.Rel == "next" {
+				u = l.URI
+			}
+		}
+		if len(hosts) > 5 {
+			t.Fatalf("too many pages: %v", hosts)
+		}
+	}
+	expected := "host5.example.com host4.example.com host3.example.com host2.example.com host1.example.com"
+	if strings.Join(hosts, " ") != expected {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	handler, cleanup := testExportHandler(t)
+	defer cleanup()
+
+	for query, expected := range map[string]int{
+		"country_code=se":              3,
+		"country_code=IR&asn=AS4":      1,
+		"host=host3.example.com":       1,
+		"type=NewClientToken":          0,
+		"since=2000-01-01":             5,
+		"until=2000-01-01T00:00:00Z":   0,
+		"country_code=SE&id_max=3":     1,
+		"country_code=SE&cursor=3":     1,
+		"country_code=SE&cursor=3&x=y": 1,
+	} {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/samples/?"+query, nil))
+		rec.CodeIs(200)
+		var samples []shared.ExportSampleEntry
+		if err := rec.DecodeJsonPayload(&samples); err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != expected {
+			t.Errorf("%s: expected %d samples, got %d", query, expected, len(samples))
+		}
+	}
+
+	for _, u := range []string{
+		"http://localhost/v1/samples/?limit=0",
+		"http://localhost/v1/samples/?limit=100000",
+		"http://localhost/v1/samples/?cursor=abc",
+		"http://localhost/v1/samples/?asn=x",
+		"http://localhost/v1/samples/?since=yesterday",
+		"http://localhost/v1/simple_samples/?host=host1.example.com",
+	} {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", u, nil))
+		rec.CodeIs(400)
+	}
+}
+
+func TestExportStream(t *testing.T) {
+	handler, cleanup := testExportHandler(t)
+	defer cleanup()
+
+	req := test.MakeSimpleRequest("GET", "http://localhost/v1/samples/?country_code=SE&limit=1", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rec := test.RunRequest(t, handler, req)
+	rec.CodeIs(200)
+	rec.HeaderIs("Content-Type", "application/x-ndjson")
+	var hosts []string
+	scanner := bufio.NewScanner(rec.Recorder.Body)
+	for scanner.Scan() {
+		var s shared.ExportSampleEntry
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, s.Host)
+	}
+	if strings.Join(hosts, " ") != "host5.example.com host3.example.com host1.example.com" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+
+	req = test.MakeSimpleRequest("GET", "http://localhost/v1/samples/?cursor=3", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec = test.RunRequest(t, handler, req)
+	rec.CodeIs(200)
+	rec.HeaderIs("Content-Type", "text/csv; charset=utf-8")
+	records, err := csv.NewReader(rec.Recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %v", records)
+	}
+	if strings.Join(records[0], ",") != "id,host,country_code,asn,created_at,origin,type,token,data,extra_data" {
+		t.Errorf("unexpected header: %v", records[0])
+	}
+	if records[1][1] != "host2.example.com" || records[1][3] != "2" {
+		t.Errorf("unexpected row: %v", records[1])
+	}
+}
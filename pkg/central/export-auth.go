@@ -0,0 +1,123 @@
+package central
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
+	"github.com/alkasir/alkasir/pkg/shared/jwtmw"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/thomasf/lg"
+)
+
+// exportRowsEnv is the request.Env key where export handlers store the
+// number of returned entries for the audit trail.
+const exportRowsEnv = "EXPORT_ROWS"
+
+// exportPayload returns the jwt claims added at login, the scopes and the
+// generation of the credentials.
+func exportPayload(dbclients db.Clients) func(userId string) map[string]interface{} {
+	return func(userId string) map[string]interface{} {
+		ok, cred, err := dbclients.DB.GetExportAPIAuthCredentials(userId)
+		if err != nil {
+			lg.Errorln(err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{
+			"scopes": cred.Scopes,
+			"gen":    cred.Generation,
+		}
+	}
+}
+
+// exportAuthorizator rejects tokens for credentials which have been disabled
+// or rotated since the token was issued.
+func exportAuthorizator(dbclients db.Clients) func(userId string, request *rest.Request) bool {
+	return func(userId string, request *rest.Request) bool {
+		ok, cred, err := dbclients.DB.GetExportAPIAuthCredentials(userId)
+		if err != nil {
+			lg.Errorln(err)
+			return false
+		}
+		if !ok || !cred.Enabled {
+			return false
+		}
+		gen, ok := jwtmw.ExtractClaims(request)["gen"].(float64)
+		return ok && int(gen) == cred.Generation
+	}
+}
+
+// claimScopes returns the scopes in the jwt claims of r.
+func claimScopes(r *rest.Request) []string {
+	var scopes []string
+	switch v := jwtmw.ExtractClaims(r)["scopes"].(type) {
+	case []string:
+		scopes = v
+	case []interface{}:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+// hasScope returns true if the jwt claims of r grants scope.
+func hasScope(r *rest.Request, scope string) bool {
+	for _, v := range claimScopes(r) {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope returns a handler which responds with forbidden unless the
+// request is granted scope.
+func requireScope(scope string, handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if !hasScope(r, scope) {
+			apiutils.WriteRestError(w, apierrors.NewForbidden("scope", scope,
+				fmt.Errorf("scope %s is required for %s", scope, r.URL.Path)))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// exportAuditMiddleware writes an export_api_audit row for each request.
+type exportAuditMiddleware struct {
+	dbclients db.Clients
+}
+
+func (mw *exportAuditMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		handler(w, r)
+		user, _ := r.Env["REMOTE_USER"].(string)
+		rows, _ := r.Env[exportRowsEnv].(int)
+		err := mw.dbclients.DB.InsertExportAPIAudit(db.ExportAPIAudit{
+			Username: user,
+			Request:  r.Method + " " + r.URL.RequestURI(),
+			Route:    r.URL.Path,
+			Filters:  auditFilters(r.URL.Query()),
+			Rows:     rows,
+		})
+		if err != nil {
+			lg.Errorf("could not write export api audit for %s: %v", user, err)
+		}
+	}
+}
+
+// auditFilters returns the query without the pagination parameters.
+func auditFilters(q url.Values) string {
+	for _, v := range []string{"cursor", "id_max", "limit"} {
+		q.Del(v)
+	}
+	return q.Encode()
+}
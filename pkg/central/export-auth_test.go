@@ -0,0 +1,122 @@
+package central
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+func TestExportAPIScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	defer func(k int) { *exportMinSessions = k }(*exportMinSessions)
+	*exportMinSessions = 0
+	err = d.InsertSample(db.Sample{
+		Host:        "example.com",
+		CountryCode: "SE",
+		ASN:         1,
+		Origin:      "Client",
+		Type:        "HTTPHeader",
+		Token:       "token",
+		Data:        []byte(`{"a":1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, scopes := range map[string][]string{
+		"blocked": {db.ScopeBlockedRead},
+		"samples": {db.ScopeSamplesRead},
+		"raw":     {db.ScopeSamplesRead, db.ScopeRawDataRead},
+	} {
+		c := db.APICredentials{Username: user, Scopes: scopes}
+		if err := c.SetPassword("secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.InsertExportAPICredentials(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler, err := apiMuxExport(db.Clients{DB: d}, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(user string) string {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/login",
+			map[string]string{"username": user, "password": "secret"}))
+		rec.CodeIs(200)
+		var resp map[string]string
+		if err := rec.DecodeJsonPayload(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp["token"]
+	}
+	get := func(token, u string) *test.Recorded {
+		req := test.MakeSimpleRequest("GET", u, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return test.RunRequest(t, handler, req)
+	}
+
+	blocked := login("blocked")
+	get(blocked, "http://localhost/v1/blocked/").CodeIs(200)
+	get(blocked, "http://localhost/v1/samples/").CodeIs(http.StatusForbidden)
+
+	for _, v := range []struct{ user, data string }{{"samples", ""}, {"raw", `{"a":1}`}} {
+		user, data := v.user, v.data
+		rec := get(login(user), "http://localhost/v1/samples/?country_code=SE")
+		rec.CodeIs(200)
+		var samples []shared.ExportSampleEntry
+		if err := rec.DecodeJsonPayload(&samples); err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 1 || samples[0].Data != data {
+			t.Errorf("%s: unexpected samples: %+v", user, samples)
+		}
+	}
+
+	audit, err := d.GetExportAPIAudit("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 4 {
+		t.Fatalf("expected 4 audit rows, got %+v", audit)
+	}
+	if a := audit[0]; a.Username != "raw" || a.Route != "/v1/samples/" || a.Filters != "country_code=SE" || a.Rows != 1 {
+		t.Errorf("unexpected audit: %+v", a)
+	}
+
+	// tokens are rejected after rotation or when disabled.
+	ok, c, err := d.GetExportAPIAuthCredentials("blocked")
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if _, err := d.UpdateExportAPICredentials(c); err != nil {
+		t.Fatal(err)
+	}
+	get(blocked, "http://localhost/v1/blocked/").CodeIs(http.StatusUnauthorized)
+	blocked = login("blocked")
+	get(blocked, "http://localhost/v1/blocked/").CodeIs(200)
+	if _, err := d.SetExportAPICredentialsEnabled("blocked", false); err != nil {
+		t.Fatal(err)
+	}
+	rec := get(blocked, "http://localhost/v1/blocked/")
+	rec.CodeIs(http.StatusUnauthorized)
+	if fmt.Sprint(rec.Recorder.HeaderMap["Www-Authenticate"]) != "[JWT realm=jwt auth]" {
+		t.Errorf("unexpected headers: %v", rec.Recorder.HeaderMap)
+	}
+}
@@ -0,0 +1,125 @@
+package central
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/thomasf/lg"
+)
+
+var eventsPollInterval = flag.Duration("eventsPollInterval", 2*time.Second, "how often export api event streams check for new publish events")
+
+// eventsKeepalive is the time after which an idle event stream gets a
+// comment line so that proxies keep the connection open.
+const eventsKeepalive = 30 * time.Second
+
+// GetEventsExport streams publish events as server-sent events. Streams start
+// at the latest event unless the Last-Event-ID header or the last_event_id
+// parameter is set, in which case the events after it are sent first. The
+// credentials are rechecked with authorized before each poll and the stream
+// is closed once they are disabled or rotated.
+func GetEventsExport(dbclients db.Clients, authorized func(userId string, request *rest.Request) bool) func(w rest.ResponseWriter, r *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		er, err := parseExportRequest(r, "country_code", "asn", "host")
+		if err != nil {
+			apiutils.WriteRestError(w, err)
+			return
+		}
+		if er.cursor != 0 || er.limit != 0 || !er.filter.Since.IsZero() || !er.filter.Until.IsZero() {
+			apiutils.WriteRestError(w, apierrors.NewBadRequest("event streams are resumed with Last-Event-ID"))
+			return
+		}
+		last := r.Header.Get("Last-Event-ID")
+		if last == "" {
+			last = r.URL.Query().Get("last_event_id")
+		}
+		var lastID int
+		if last != "" {
+			lastID, err = strconv.Atoi(last)
+			if err != nil || lastID < 0 {
+				apiutils.WriteRestError(w, apierrors.NewBadRequest("invalid last event id: "+last))
+				return
+			}
+		} else {
+			lastID, err = dbclients.DB.LatestPublishEventID()
+			if err != nil {
+				apiutils.WriteRestError(w, err)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		hw := w.(http.ResponseWriter)
+		flush := func() {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		flush()
+
+		var rows int
+		defer func() {
+			r.Env[exportRowsEnv] = rows
+		}()
+		tick := time.NewTicker(*eventsPollInterval)
+		defer tick.Stop()
+		idle := time.Now()
+		user, _ := r.Env["REMOTE_USER"].(string)
+		for {
+			if !authorized(user, r) {
+				lg.V(5).Infof("event stream of %s closed, %s is no longer authorized", r.URL.Path, user)
+				return
+			}
+			events, err := dbclients.DB.GetPublishEvents(lastID, er.filter, db.PageLength)
+			if err != nil {
+				lg.Errorf("event stream of %s failed: %v", r.URL.Path, err)
+				return
+			}
+			for _, e := range events {
+				data, err := json.Marshal(e)
+				if err != nil {
+					lg.Errorln(err)
+					return
+				}
+				_, err = fmt.Fprintf(hw, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Action, data)
+				if err != nil {
+					lg.V(5).Infof("event stream of %s stopped: %v", r.URL.Path, err)
+					return
+				}
+				if lastID, err = strconv.Atoi(e.ID); err != nil {
+					lg.Errorf("invalid publish event id %s: %v", e.ID, err)
+					return
+				}
+				rows++
+			}
+			if len(events) > 0 {
+				idle = time.Now()
+				flush()
+			} else if time.Since(idle) > eventsKeepalive {
+				if _, err := fmt.Fprint(hw, ": keepalive\n\n"); err != nil {
+					return
+				}
+				idle = time.Now()
+				flush()
+			}
+			if len(events) == db.PageLength {
+				continue
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package central
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+func TestEventsExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	defer func(v time.Duration) { *eventsPollInterval = v }(*eventsPollInterval)
+	*eventsPollInterval = 10 * time.Millisecond
+
+	for _, v := range []struct {
+		host, cc string
+	}{{"a.example.com", "SE"}, {"b.example.com", "IR"}, {"c.example.com", "SE"}} {
+		if err := d.PublishHostRule(v.host, v.cc, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var (
+		mu         sync.Mutex
+		authorized = true
+	)
+	authorize := func(v bool) {
+		mu.Lock()
+		authorized = v
+		mu.Unlock()
+	}
+	router, err := rest.MakeRouter(rest.Get("/v1/events/", GetEventsExport(db.Clients{DB: d},
+		func(userId string, request *rest.Request) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return authorized
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	// stream runs a request which is canceled after 200ms, during is called
+	// after 50ms if set.
+	stream := func(u, lastEventID string, during func()) string {
+		req := test.MakeSimpleRequest("GET", u, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), 200*time.Millisecond)
+		defer cancel()
+		done := make(chan struct{})
+		defer func() { <-done }()
+		go func() {
+			defer close(done)
+			if during != nil {
+				time.Sleep(50 * time.Millisecond)
+				during()
+			}
+		}()
+		rec := test.RunRequest(t, handler, req.WithContext(ctx))
+		rec.CodeIs(200)
+		rec.HeaderIs("Content-Type", "text/event-stream")
+		return rec.Recorder.Body.String()
+	}
+	publish := func(host string) func() {
+		return func() {
+			if err := d.PublishHostRule(host, "SE", 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	body := stream("http://localhost/v1/events/?country_code=SE", "0", nil)
+	if !strings.Contains(body, "id: 1\nevent: Add\ndata: {") || !strings.Contains(body, `"host":"c.example.com"`) ||
+		strings.Contains(body, "b.example.com") {
+		t.Errorf("unexpected stream: %q", body)
+	}
+	body = stream("http://localhost/v1/events/", "", publish("d.example.com"))
+	if strings.Contains(body, "a.example.com") || !strings.Contains(body, "id: 4\n") {
+		t.Errorf("unexpected stream: %q", body)
+	}
+
+	// the stream is closed once the credentials are no longer authorized.
+	start := time.Now()
+	stream("http://localhost/v1/events/", "", func() { authorize(false) })
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("expected the stream to be closed, took %s", d)
+	}
+	authorize(true)
+
+	rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/events/?cursor=1", nil))
+	rec.CodeIs(400)
+}
@@ -0,0 +1,273 @@
+package central
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
+	"github.com/alkasir/alkasir/pkg/shared/linkheader"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/thomasf/lg"
+)
+
+// exportFormat is the response format of an export api request.
+type exportFormat int
+
+const (
+	exportJSON   exportFormat = iota // one page as a json array
+	exportNDJSON                     // all entries streamed as newline delimited json
+	exportCSV                        // all entries streamed as csv with a header row
+)
+
+var exportContentTypes = map[exportFormat]string{
+	exportNDJSON: "application/x-ndjson",
+	exportCSV:    "text/csv; charset=utf-8",
+}
+
+// negotiateExportFormat returns the first streamed format in the Accept
+// header value accept, or exportJSON.
+func negotiateExportFormat(accept string) exportFormat {
+	for _, v := range strings.Split(accept, ",") {
+		mediatype, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		switch mediatype {
+		case "application/x-ndjson", "application/ndjson":
+			return exportNDJSON
+		case "text/csv":
+			return exportCSV
+		case "application/json":
+			return exportJSON
+		}
+	}
+	return exportJSON
+}
+
+// exportRequest is the parsed query of an export api request.
+type exportRequest struct {
+	cursor int // entries with lower ids are returned, 0 for the latest entry.
+	limit  int // json page length
+	filter shared.ExportFilter
+	format exportFormat
+}
+
+// parseExportRequest parses the pagination and filter parameters of r. The
+// filters the endpoint supports are listed in filters, the time range filters
+// since and until are supported by all endpoints.
+func parseExportRequest(r *rest.Request, filters ...string) (exportRequest, error) {
+	q := r.URL.Query()
+	er := exportRequest{
+		format: negotiateExportFormat(r.Header.Get("Accept")),
+	}
+
+	supported := map[string]bool{"since": true, "until": true}
+	for _, v := range filters {
+		supported[v] = true
+	}
+	for _, v := range []string{"country_code", "asn", "type", "host"} {
+		if q.Get(v) != "" && !supported[v] {
+			return er, apierrors.NewBadRequest(fmt.Sprintf("filter %s is not supported by %s", v, r.URL.Path))
+		}
+	}
+
+	cursor := q.Get("cursor")
+	if cursor == "" {
+		cursor = q.Get("id_max") // deprecated name of cursor
+	}
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 1 {
+			return er, apierrors.NewBadRequest("invalid cursor: " + cursor)
+		}
+		er.cursor = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > db.PageLength {
+			return er, apierrors.NewBadRequest(fmt.Sprintf("limit must be between 1 and %d", db.PageLength))
+		}
+		er.limit = n
+	}
+
+	er.filter.CountryCode = strings.ToUpper(q.Get("country_code"))
+	er.filter.Type = q.Get("type")
+	er.filter.Host = q.Get("host")
+	if v := q.Get("asn"); v != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(v), "AS"))
+		if err != nil {
+			return er, apierrors.NewBadRequest("invalid asn: " + v)
+		}
+		er.filter.ASN = n
+	}
+	var err error
+	if er.filter.Since, err = parseExportTime(q.Get("since")); err != nil {
+		return er, apierrors.NewBadRequest("invalid since: " + err.Error())
+	}
+	if er.filter.Until, err = parseExportTime(q.Get("until")); err != nil {
+		return er, apierrors.NewBadRequest("invalid until: " + err.Error())
+	}
+	return er, nil
+}
+
+// parseExportTime parses a RFC 3339 timestamp or a date.
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// exportPage returns a page of at most limit entries with ids lower than
+// idMax, or the latest entries if idMax is 0. entries is a slice of entry
+// structs. cursor is the idMax of the next page or empty if there are no more
+// entries.
+type exportPage func(idMax, limit int) (entries interface{}, cursor string, err error)
+
+// writeExport writes the response of an export api request. Json responses
+// contain one page and Link headers to the first and next page. Streamed
+// responses contain all entries from the requested cursor and are fetched
+// from the database one page at a time.
+func writeExport(w rest.ResponseWriter, r *rest.Request, er exportRequest, page exportPage) {
+	w.Header().Add("Vary", "Accept")
+	if er.format == exportJSON {
+		entries, cursor, err := page(er.cursor, er.limit)
+		if err != nil {
+			apiutils.WriteRestError(w, err)
+			return
+		}
+		u := *r.URL
+		q := u.Query()
+		q.Del("id_max")
+		u.RawQuery = q.Encode()
+		lh := linkheader.NewLinkHeader(&u, "cursor")
+		lh.First("")
+		if er.cursor != 0 {
+			lh.Current(strconv.Itoa(er.cursor))
+		}
+		if cursor != "" {
+			lh.Next(cursor)
+		}
+		lh.SetHeader(w.Header())
+		r.Env[exportRowsEnv] = reflect.ValueOf(entries).Len()
+		err = w.WriteJson(entries)
+		if err != nil {
+			lg.Warning(err)
+		}
+		return
+	}
+
+	entries, cursor, err := page(er.cursor, db.PageLength)
+	if err != nil {
+		apiutils.WriteRestError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", exportContentTypes[er.format])
+	w.WriteHeader(http.StatusOK)
+	hw := w.(http.ResponseWriter)
+	var enc exportEncoder
+	if er.format == exportCSV {
+		enc = newCSVExportEncoder(hw)
+	} else {
+		enc = json.NewEncoder(hw)
+	}
+	var rows int
+	defer func() {
+		r.Env[exportRowsEnv] = rows
+	}()
+	for {
+		v := reflect.ValueOf(entries)
+		for i := 0; i < v.Len(); i++ {
+			if err := enc.Encode(v.Index(i).Interface()); err != nil {
+				// most likely the client went away.
+				lg.V(5).Infof("export stream of %s stopped: %v", r.URL.Path, err)
+				return
+			}
+			rows++
+		}
+		if f, ok := enc.(*csvExportEncoder); ok {
+			f.w.Flush()
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if cursor == "" {
+			return
+		}
+		idMax, err := strconv.Atoi(cursor)
+		if err != nil {
+			lg.Errorf("invalid export cursor %s: %v", cursor, err)
+			return
+		}
+		entries, cursor, err = page(idMax, db.PageLength)
+		if err != nil {
+			// the status is already sent, the client sees a truncated stream.
+			lg.Errorf("export stream of %s failed: %v", r.URL.Path, err)
+			return
+		}
+	}
+}
+
+// exportEncoder writes one entry to a streamed export response.
+type exportEncoder interface {
+	Encode(v interface{}) error
+}
+
+// csvExportEncoder writes export entries as csv rows. The header row is
+// written before the first entry and uses the json names of the entry
+// fields.
+type csvExportEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVExportEncoder(w http.ResponseWriter) *csvExportEncoder {
+	return &csvExportEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvExportEncoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	rt := rv.Type()
+	if !e.wroteHeader {
+		var header []string
+		for i := 0; i < rt.NumField(); i++ {
+			name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = rt.Field(i).Name
+			}
+			header = append(header, name)
+		}
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	record := make([]string, rt.NumField())
+	for i := range record {
+		switch f := rv.Field(i).Interface().(type) {
+		case time.Time:
+			record[i] = f.UTC().Format(time.RFC3339Nano)
+		case float64:
+			record[i] = strconv.FormatFloat(f, 'g', -1, 64)
+		default:
+			record[i] = fmt.Sprint(f)
+		}
+	}
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+	return e.w.Error()
+}
@@ -1,8 +1,12 @@
 package central
 
 import (
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/alkasir/alkasir/pkg/central/db"
@@ -16,12 +20,56 @@ import (
 type centralMeasurer struct {
 	token     shared.SuggestionToken
 	measurers []measure.Measurer
+	shareKey  string // if set, the samples are reused by requests with the same key
 }
 
 var (
 	requestMeasurements = make(chan centralMeasurer, 5000)
+	recentMeasurements  = newSharedMeasurements()
 )
 
+var sharedMeasurementsTTL = flag.Duration("sharedMeasurementsTTL", time.Hour, "central measurements of a re-verified URL are reused by other re-verification sessions for this long")
+
+// sharedMeasurements holds recent central samples by share key so that
+// re-verifications of the same URL by many clients only trigger one central
+// measurement run.
+type sharedMeasurements struct {
+	mu      sync.Mutex
+	entries map[string]sharedMeasurement
+}
+
+type sharedMeasurement struct {
+	created time.Time
+	samples []db.Sample
+}
+
+func newSharedMeasurements() *sharedMeasurements {
+	return &sharedMeasurements{entries: make(map[string]sharedMeasurement, 0)}
+}
+
+// get returns the samples stored for key within ttl of now.
+func (s *sharedMeasurements) get(key string, ttl time.Duration, now time.Time) ([]db.Sample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || now.Sub(e.created) > ttl {
+		return nil, false
+	}
+	return e.samples, true
+}
+
+// put stores the samples for key and removes the entries older than ttl.
+func (s *sharedMeasurements) put(key string, samples []db.Sample, ttl time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.entries {
+		if now.Sub(v.created) > ttl {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = sharedMeasurement{created: now, samples: samples}
+}
+
 // PreparedSample .
 type PreparedSample struct {
 	lastUpdated time.Time
@@ -83,47 +131,111 @@ func startMeasurer(dbclients db.Clients) {
 					}
 
 				}
-			measurerLoop:
+				if r.shareKey != "" {
+					if samples, ok := recentMeasurements.get(r.shareKey, *sharedMeasurementsTTL, time.Now()); ok {
+						lg.V(15).Infof("reusing %d central samples for %s", len(samples), r.shareKey)
+						for _, s := range samples {
+							s.Token = r.token
+							if err := dbclients.DB.InsertSample(s); err != nil {
+								lg.Errorln(err.Error())
+							}
+						}
+						continue
+					}
+				}
+				var (
+					samples []db.Sample
+					results []measure.Measurement
+				)
 				for _, v := range r.measurers {
-					measurement, err := v.Measure()
-					if err != nil {
-						lg.Errorf("could not measure:%v error:%s", v, err.Error())
-						continue measurerLoop
+					v = measure.ResolvedAddresses(v, results)
+					measurement, sample := ps.measure(dbclients, r.token, v)
+					if measurement != nil {
+						results = append(results, measurement)
 					}
-					switch measurement.Type() {
-					case sampletypes.DNSQuery, sampletypes.HTTPHeader:
-
-						data, err := measurement.Marshal()
-						if err != nil {
-							lg.Errorf("could not decode %v error:%s", measurement, err.Error())
-							continue measurerLoop
-						}
-						err = dbclients.DB.InsertSample(db.Sample{
-							Host:        measurement.Host(),
-							CountryCode: ps.s.CountryCode,
-							Token:       r.token,
-							ASN:         ps.s.ASN,
-							Type:        measurement.Type().String(),
-							Origin:      sampleorigins.Central.String(),
-							Data:        data,
-						})
-						if err != nil {
-							lg.Errorln(err.Error())
-							continue measurerLoop
-						}
-					default:
-						lg.Errorf("could not measure:%v error:%s", v, err.Error())
-						continue measurerLoop
+					if sample != nil {
+						samples = append(samples, *sample)
 					}
 				}
+				if r.shareKey != "" {
+					recentMeasurements.put(r.shareKey, samples, *sharedMeasurementsTTL, time.Now())
+				}
 			}
 		}()
 	}
 }
 
-func queueMeasurements(token shared.SuggestionToken, measurers ...measure.Measurer) {
+// measure runs v and stores the measurement as a central sample of the session
+// token. The measurement is nil if it failed and the sample is nil if it was
+// not stored.
+func (p *PreparedSample) measure(dbclients db.Clients, token shared.SuggestionToken, v measure.Measurer) (measure.Measurement, *db.Sample) {
+	measurement, err := v.Measure()
+	if err != nil {
+		lg.Errorf("could not measure:%v error:%s", v, err.Error())
+		return nil, nil
+	}
+	switch measurement.Type() {
+	case sampletypes.DNSQuery, sampletypes.HTTPHeader,
+		sampletypes.TLSHandshake, sampletypes.TCPConnect, sampletypes.DNSInjection:
+	default:
+		lg.Errorf("unsupported central sample type: %s", measurement.Type().String())
+		return measurement, nil
+	}
+	data, err := measurement.Marshal()
+	if err != nil {
+		lg.Errorf("could not decode %v error:%s", measurement, err.Error())
+		return measurement, nil
+	}
+	sample := db.Sample{
+		Host:        measurement.Host(),
+		CountryCode: p.s.CountryCode,
+		Token:       token,
+		ASN:         p.s.ASN,
+		Type:        measurement.Type().String(),
+		Origin:      sampleorigins.Central.String(),
+		Data:        data,
+	}
+	if err := dbclients.DB.InsertSample(sample); err != nil {
+		lg.Errorln(err.Error())
+		return measurement, nil
+	}
+	return measurement, &sample
+}
+
+// queueMeasurements queues the central measurements of the session token. The
+// samples of requests with the same non empty shareKey are reused for
+// -sharedMeasurementsTTL instead of measuring again.
+func queueMeasurements(token shared.SuggestionToken, shareKey string, measurers ...measure.Measurer) {
 	requestMeasurements <- centralMeasurer{
 		token:     token,
 		measurers: measurers,
+		shareKey:  shareKey,
+	}
+}
+
+// anonymizeTTLProbe replaces the router addresses of a client TTLProbe
+// sample with the routers ASNs so that no raw addresses are stored.
+func anonymizeTTLProbe(internet db.InternetClient, data []byte) ([]byte, error) {
+	var r measure.TTLProbeResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	r.EventASN = 0
+	for i, h := range r.Hops {
+		h.RouterASN = 0
+		if ip := net.ParseIP(h.RouterAddr); ip != nil {
+			res, err := internet.IP2ASN(ip)
+			if err != nil {
+				lg.Warningf("could not resolve router asn: %v", err)
+			} else if res != nil {
+				h.RouterASN = res.ASN
+			}
+		}
+		h.RouterAddr = ""
+		r.Hops[i] = h
+		if r.EventHop != 0 && h.TTL < r.EventHop && h.RouterASN != 0 {
+			r.EventASN = h.RouterASN
+		}
 	}
+	return json.Marshal(r)
 }
@@ -0,0 +1,80 @@
+package central
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/measure"
+	"github.com/thomasf/internet"
+)
+
+type testInternet map[string]int
+
+func (t testInternet) IP2ASN(IP net.IP) (*internet.ASNResult, error) {
+	asn, ok := t[IP.String()]
+	if !ok {
+		return nil, nil
+	}
+	return &internet.ASNResult{ASN: asn}, nil
+}
+
+func TestAnonymizeTTLProbe(t *testing.T) {
+	data, err := json.Marshal(measure.TTLProbeResult{
+		URL: "http://example.com",
+		Hops: []measure.TTLProbeHop{
+			{TTL: 1, RouterAddr: "192.168.1.1"},
+			{TTL: 2, RouterAddr: "130.234.1.1"},
+			{TTL: 3, RouterAddr: "130.235.1.1"},
+			{TTL: 4, Event: measure.TTLProbeReset},
+		},
+		EventHop: 4,
+		Event:    measure.TTLProbeReset,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = anonymizeTTLProbe(testInternet{
+		"130.234.1.1": 1000,
+		"130.235.1.1": 2000,
+	}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "router_addr") || strings.Contains(string(data), "130.234") {
+		t.Fatalf("router addresses not removed: %s", data)
+	}
+	var r measure.TTLProbeResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Hops[0].RouterASN != 0 || r.Hops[1].RouterASN != 1000 || r.Hops[2].RouterASN != 2000 {
+		t.Errorf("unexpected router asns %+v", r.Hops)
+	}
+	if r.EventASN != 2000 {
+		t.Errorf("expected event asn 2000, got %d", r.EventASN)
+	}
+}
+
+func TestSharedMeasurements(t *testing.T) {
+	s := newSharedMeasurements()
+	now := time.Now()
+	if _, ok := s.get("http://a.com", time.Hour, now); ok {
+		t.Error("expected no samples")
+	}
+	s.put("http://a.com", []db.Sample{{Host: "a.com", Type: "HTTPHeader"}}, time.Hour, now)
+	samples, ok := s.get("http://a.com", time.Hour, now.Add(time.Minute))
+	if !ok || len(samples) != 1 || samples[0].Host != "a.com" {
+		t.Errorf("unexpected samples: %v %v", samples, ok)
+	}
+	if _, ok := s.get("http://a.com", time.Hour, now.Add(2*time.Hour)); ok {
+		t.Error("expected samples to expire")
+	}
+	s.put("http://b.com", nil, time.Hour, now.Add(2*time.Hour))
+	if len(s.entries) != 1 {
+		t.Errorf("expected expired entries to be removed, got %d", len(s.entries))
+	}
+}
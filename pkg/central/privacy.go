@@ -0,0 +1,270 @@
+package central
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	exportMinSessions = flag.Int("exportMinSessions", 5, "export api records from (country, ASN, city, day) buckets with fewer distinct reporters are generalised to country only or suppressed, 1 or less disables the filter")
+	statsNoiseEpsilon = flag.Float64("statsNoiseEpsilon", 0, "privacy budget of the laplace noise added to export api statistics counts, 0 disables noise")
+)
+
+var privacyFilteredRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "central_export_privacy_total",
+	Help: "Total export api records generalised or suppressed by the privacy filter",
+}, []string{"action"})
+
+func init() {
+	prometheus.MustRegister(privacyFilteredRecords)
+}
+
+// sessionKey is a session bucket without the day.
+type sessionKey struct {
+	countryCode string
+	asn         int
+	city        string
+}
+
+// countryDay is the country part of a session bucket.
+type countryDay struct {
+	countryCode string
+	day         time.Time
+}
+
+// privacyAction is what the privacy filter does with a record.
+type privacyAction int
+
+const (
+	privacyKeep       privacyAction = iota // the bucket has enough sessions
+	privacyGeneralise                      // remove all but the country
+	privacySuppress                        // leave the record out
+)
+
+// privacyFilter applies k-anonymity thresholds to export api records based on
+// the distinct reporters in the sessions statistics rollup. The session counts of each country and day
+// are loaded once per filter so a filter should only be used for a single
+// request.
+type privacyFilter struct {
+	dbclients db.Clients
+	k         int
+	sessions  map[countryDay]map[sessionKey]int
+	asns      map[countryDay]map[int]int
+	countries map[countryDay]int
+}
+
+// newPrivacyFilter returns a filter with threshold k.
+func newPrivacyFilter(dbclients db.Clients, k int) *privacyFilter {
+	return &privacyFilter{
+		dbclients: dbclients,
+		k:         k,
+		sessions:  make(map[countryDay]map[sessionKey]int, 0),
+		asns:      make(map[countryDay]map[int]int, 0),
+		countries: make(map[countryDay]int, 0),
+	}
+}
+
+// load reads the session counts of the country and day of cd.
+func (p *privacyFilter) load(cd countryDay) error {
+	if _, ok := p.sessions[cd]; ok {
+		return nil
+	}
+	entries, err := p.dbclients.DB.GetStats(shared.ExportStatsRequest{
+		Name: db.StatsSessions,
+		ExportFilter: shared.ExportFilter{
+			CountryCode: cd.countryCode,
+			Since:       cd.day,
+			Until:       cd.day.Add(24 * time.Hour),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	counts := make(map[sessionKey]int, len(entries))
+	asns := make(map[int]int, 0)
+	for _, e := range entries {
+		if e.CountryCode != cd.countryCode {
+			continue
+		}
+		counts[sessionKey{countryCode: e.CountryCode, asn: e.ASN, city: e.Label}] += e.Count
+		asns[e.ASN] += e.Count
+		p.countries[cd] += e.Count
+	}
+	p.sessions[cd] = counts
+	p.asns[cd] = asns
+	return nil
+}
+
+// action returns what to do with a record from a bucket with n sessions in
+// the country and day of cd.
+func (p *privacyFilter) action(cd countryDay, n int) privacyAction {
+	switch {
+	case n >= p.k:
+		return privacyKeep
+	case p.countries[cd] >= p.k:
+		privacyFilteredRecords.WithLabelValues("generalised").Inc()
+		return privacyGeneralise
+	default:
+		privacyFilteredRecords.WithLabelValues("suppressed").Inc()
+		return privacySuppress
+	}
+}
+
+// sessionActions returns the action for each record from the session tokens,
+// records of unknown sessions are suppressed.
+func (p *privacyFilter) sessionActions(tokens []string) ([]privacyAction, error) {
+	var values []shared.SuggestionToken
+	for _, t := range tokens {
+		if t != "" {
+			values = append(values, shared.SuggestionToken(t))
+		}
+	}
+	buckets, err := p.dbclients.DB.GetSessionBuckets(values)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]privacyAction, len(tokens))
+	for i, t := range tokens {
+		b, ok := buckets[shared.SuggestionToken(t)]
+		if !ok || t == "" {
+			privacyFilteredRecords.WithLabelValues("suppressed").Inc()
+			actions[i] = privacySuppress
+			continue
+		}
+		cd := countryDay{countryCode: b.CountryCode, day: b.Day}
+		if err := p.load(cd); err != nil {
+			return nil, err
+		}
+		actions[i] = p.action(cd, p.sessions[cd][sessionKey{countryCode: b.CountryCode, asn: b.ASN, city: b.City}])
+	}
+	return actions, nil
+}
+
+// filterSamples returns the samples whose session bucket has at least k
+// sessions. Samples of buckets below the threshold are generalised to country
+// only if the country has at least k sessions that day and are otherwise
+// suppressed, as are samples of unknown sessions.
+func (p *privacyFilter) filterSamples(samples []shared.ExportSampleEntry) ([]shared.ExportSampleEntry, error) {
+	if p.k <= 1 || len(samples) == 0 {
+		return samples, nil
+	}
+	tokens := make([]string, len(samples))
+	for i, s := range samples {
+		tokens[i] = s.Token
+	}
+	actions, err := p.sessionActions(tokens)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]shared.ExportSampleEntry, 0, len(samples))
+	for i, s := range samples {
+		switch actions[i] {
+		case privacySuppress:
+			continue
+		case privacyGeneralise:
+			s.ASN = ""
+			s.Token = ""
+			s.ExtraData = ""
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// filterAnalysisResults is filterSamples for analysis results.
+func (p *privacyFilter) filterAnalysisResults(results []shared.ExportAnalysisResultEntry) ([]shared.ExportAnalysisResultEntry, error) {
+	if p.k <= 1 || len(results) == 0 {
+		return results, nil
+	}
+	tokens := make([]string, len(results))
+	for i, r := range results {
+		tokens[i] = r.Token
+	}
+	actions, err := p.sessionActions(tokens)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]shared.ExportAnalysisResultEntry, 0, len(results))
+	for i, r := range results {
+		switch actions[i] {
+		case privacySuppress:
+			continue
+		case privacyGeneralise:
+			r.ASN = ""
+			r.Token = ""
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered, nil
+}
+
+// filterSimpleSamples returns the simple samples from a country, ASN and day
+// with at least k sessions. The origin id identifies a client for a week so
+// samples below the threshold are generalised to country only, or suppressed
+// if the country also has fewer than k sessions that day.
+func (p *privacyFilter) filterSimpleSamples(samples []shared.ExportSimpleSampleEntry) ([]shared.ExportSimpleSampleEntry, error) {
+	if p.k <= 1 || len(samples) == 0 {
+		return samples, nil
+	}
+	result := make([]shared.ExportSimpleSampleEntry, 0, len(samples))
+	for _, s := range samples {
+		cd := countryDay{countryCode: s.CountryCode, day: s.CreatedAt.UTC().Truncate(24 * time.Hour)}
+		if err := p.load(cd); err != nil {
+			return nil, err
+		}
+		var n int
+		if asn, err := strconv.Atoi(s.ASN); err == nil {
+			n = p.asns[cd][asn]
+		}
+		switch p.action(cd, n) {
+		case privacySuppress:
+			continue
+		case privacyGeneralise:
+			s.ASN = ""
+			s.OriginID = ""
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// addStatsNoise adds laplace noise with scale 1/epsilon to the counts of the
+// named statistics entries. The noise of each entry is derived from the
+// reporter hash key and the entry so that repeated requests can not be
+// averaged to remove it.
+func addStatsNoise(name string, entries []shared.ExportStatsEntry, epsilon float64) {
+	if epsilon <= 0 {
+		return
+	}
+	for i, e := range entries {
+		mac := hmac.New(sha256.New, reporterHashKey)
+		fmt.Fprintf(mac, "stats noise\x00%s\x00%s\x00%s\x00%d\x00%s",
+			name, e.Bucket.UTC().Format(time.RFC3339), e.CountryCode, e.ASN, e.Label)
+		n := e.Count + int(math.Floor(laplace(mac.Sum(nil), 1/epsilon)+0.5))
+		if n < 0 {
+			n = 0
+		}
+		entries[i].Count = n
+	}
+}
+
+// laplace returns a laplace distributed value with the given scale from the
+// first 8 bytes of seed.
+func laplace(seed []byte, scale float64) float64 {
+	// u is uniform in (-0.5, 0.5)
+	u := (float64(binary.BigEndian.Uint64(seed)>>11)+0.5)/(1<<53) - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
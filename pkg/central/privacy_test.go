@@ -0,0 +1,213 @@
+package central
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+func TestPrivacyFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-privacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	defer func(k int) { *exportMinSessions = k }(*exportMinSessions)
+	*exportMinSessions = 3
+
+	for i, v := range []struct {
+		cc       string
+		asn      int
+		city     uint
+		reporter string
+	}{
+		{"SE", 1, 100, "a"}, {"SE", 1, 100, "b"}, {"SE", 1, 100, "c"},
+		{"SE", 2, 0, "d"},
+		{"SE", 4, 300, "e"}, {"SE", 4, 300, "e"}, {"SE", 4, 300, "e"}, // one reporter
+		{"IR", 3, 200, "f"},
+	} {
+		token := shared.SuggestionToken(fmt.Sprintf("token%d", i))
+		host := fmt.Sprintf("host%d.example.com", i)
+		for _, typ := range []string{"NewClientToken", "HTTPHeader"} {
+			err := d.InsertSample(db.Sample{
+				Host:        host,
+				CountryCode: v.cc,
+				ASN:         v.asn,
+				Origin:      "Client",
+				Type:        typ,
+				Token:       token,
+				Data:        []byte(`{}`),
+				ExtraData:   []byte(fmt.Sprintf(`{"CityGeoNameID":%d}`, v.city)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.InsertSessionReporter(db.SessionReporter{Token: token, AddrHash: v.reporter}); err != nil {
+			t.Fatal(err)
+		}
+		err := d.InsertAnalysisResult(db.AnalysisResult{Host: host, CountryCode: v.cc, ASN: v.asn, Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.InsertSimpleSample(db.SimpleSample{
+			CountryCode: v.cc,
+			ASN:         v.asn,
+			Type:        "ClientBlocklistUpdate",
+			OriginID:    fmt.Sprintf("origin%d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = d.InsertSample(db.Sample{
+		Host:        "unknown.example.com",
+		CountryCode: "SE",
+		ASN:         1,
+		Origin:      "Client",
+		Type:        "HTTPHeader",
+		Token:       "unknown",
+		Data:        []byte(`{}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := db.Clients{DB: d}
+	refreshStats(clients)
+
+	router, err := rest.MakeRouter(
+		rest.Get("/v1/samples/", GetSamplesExport(clients)),
+		rest.Get("/v1/analysis/", GetAnalysisResultsExport(clients)),
+		rest.Get("/v1/simple_samples/", GetSimpleSamplesExport(clients)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	api.SetApp(router)
+	handler := api.MakeHandler()
+	get := func(u string, v interface{}) {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", u, nil))
+		rec.CodeIs(200)
+		if err := rec.DecodeJsonPayload(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hosts and ASNs of the records which are kept, an empty ASN means that
+	// the record was generalised.
+	expected := map[string]string{
+		"host0.example.com": "1",
+		"host1.example.com": "1",
+		"host2.example.com": "1",
+		"host3.example.com": "",
+		"host4.example.com": "",
+		"host5.example.com": "",
+		"host6.example.com": "",
+	}
+	var samples []shared.ExportSampleEntry
+	get("http://localhost/v1/samples/?type=HTTPHeader", &samples)
+	if len(samples) != len(expected) {
+		t.Fatalf("unexpected samples: %+v", samples)
+	}
+	for _, s := range samples {
+		asn, ok := expected[s.Host]
+		if !ok || s.ASN != asn || (asn == "" && s.Token != "") {
+			t.Errorf("unexpected sample: %+v", s)
+		}
+	}
+
+	var results []shared.ExportAnalysisResultEntry
+	get("http://localhost/v1/analysis/", &results)
+	if len(results) != len(expected) {
+		t.Fatalf("unexpected analysis results: %+v", results)
+	}
+	for _, r := range results {
+		asn, ok := expected[r.Host]
+		if !ok || r.ASN != asn || (asn == "" && r.Token != "") {
+			t.Errorf("unexpected analysis result: %+v", r)
+		}
+	}
+
+	var simple []shared.ExportSimpleSampleEntry
+	get("http://localhost/v1/simple_samples/", &simple)
+	if len(simple) != 7 {
+		t.Fatalf("unexpected simple samples: %+v", simple)
+	}
+	for _, s := range simple {
+		if s.CountryCode != "SE" || (s.ASN == "1") != (s.OriginID != "") || (s.ASN != "" && s.ASN != "1") {
+			t.Errorf("unexpected simple sample: %+v", s)
+		}
+	}
+}
+
+func TestStatsNoise(t *testing.T) {
+	entries := func() []shared.ExportStatsEntry {
+		var entries []shared.ExportStatsEntry
+		for i := 0; i < 100; i++ {
+			entries = append(entries, shared.ExportStatsEntry{
+				Bucket:      time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+				CountryCode: "SE",
+				Count:       10,
+			})
+		}
+		return entries
+	}
+	a, b := entries(), entries()
+	addStatsNoise(db.StatsSuggestions, a, 0)
+	for _, e := range a {
+		if e.Count != 10 {
+			t.Fatalf("expected no noise, got %+v", e)
+		}
+	}
+	addStatsNoise(db.StatsSuggestions, a, 0.5)
+	addStatsNoise(db.StatsSuggestions, b, 0.5)
+	var changed int
+	for i := range a {
+		if a[i].Count != b[i].Count {
+			t.Errorf("noise is not deterministic: %+v != %+v", a[i], b[i])
+		}
+		if a[i].Count < 0 {
+			t.Errorf("negative count: %+v", a[i])
+		}
+		if a[i].Count != 10 {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Error("expected noise")
+	}
+}
+
+func TestLaplace(t *testing.T) {
+	var sum, abs float64
+	n := 10000
+	for i := 0; i < n; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprint(i)))
+		v := laplace(seed[:], 2)
+		sum += v
+		abs += math.Abs(v)
+	}
+	// the mean is 0 and the mean absolute deviation equals the scale.
+	if mean := sum / float64(n); math.Abs(mean) > 0.1 {
+		t.Errorf("unexpected mean %f", mean)
+	}
+	if mad := abs / float64(n); math.Abs(mad-2) > 0.1 {
+		t.Errorf("unexpected mean absolute deviation %f", mad)
+	}
+}
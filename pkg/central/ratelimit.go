@@ -0,0 +1,169 @@
+package central
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "central_api_rate_limited_total",
+	Help: "Total api requests rejected by rate limits and sample caps",
+}, []string{"limit"})
+
+func init() {
+	prometheus.MustRegister(rateLimitedRequests)
+}
+
+// maxRateLimitBuckets is the maximum number of buckets of a rateLimiter. The
+// least recently used bucket is evicted to make room for a new key so that a
+// client with many addresses can neither exhaust the memory of central nor
+// lock out new clients.
+const maxRateLimitBuckets = 100000
+
+// bucket is a token bucket.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each key. Buckets are refilled with
+// rate tokens per second up to burst tokens.
+type rateLimiter struct {
+	name  string
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List // of *bucket, most recently used first
+}
+
+// newRateLimiter returns a rateLimiter allowing perHour requests per key, a
+// perHour value <= 0 disables the limit.
+func newRateLimiter(name string, perHour float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		name:    name,
+		rate:    perHour / 3600,
+		burst:   float64(burst),
+		buckets: make(map[string]*list.Element, 0),
+		lru:     list.New(),
+	}
+}
+
+// allow takes a token from the bucket for key. If the bucket is empty the
+// time until a token is available is returned.
+func (r *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if r.rate <= 0 {
+		return true, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.buckets[key]
+	if ok {
+		r.lru.MoveToFront(e)
+	} else {
+		if len(r.buckets) >= maxRateLimitBuckets {
+			r.expireLocked(now)
+		}
+		if len(r.buckets) >= maxRateLimitBuckets {
+			r.removeLocked(r.lru.Back())
+		}
+		e = r.lru.PushFront(&bucket{key: key, tokens: r.burst, last: now})
+		r.buckets[key] = e
+	}
+	b := e.Value.(*bucket)
+	b.tokens = math.Min(r.burst, b.tokens+now.Sub(b.last).Seconds()*r.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / r.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// expire removes the buckets which are full again since they are the same as
+// a new bucket.
+func (r *rateLimiter) expire(now time.Time) {
+	if r.rate <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.expireLocked(now)
+	r.mu.Unlock()
+}
+
+func (r *rateLimiter) expireLocked(now time.Time) {
+	full := time.Duration(r.burst / r.rate * float64(time.Second))
+	for e := r.lru.Back(); e != nil && now.Sub(e.Value.(*bucket).last) > full; e = r.lru.Back() {
+		r.removeLocked(e)
+	}
+}
+
+func (r *rateLimiter) removeLocked(e *list.Element) {
+	delete(r.buckets, e.Value.(*bucket).key)
+	r.lru.Remove(e)
+}
+
+// check writes a TooManyRequests response and returns false if the request
+// for key is not allowed.
+func (r *rateLimiter) check(w rest.ResponseWriter, key string) bool {
+	ok, wait := r.allow(key, time.Now())
+	if ok {
+		return true
+	}
+	rateLimitedRequests.WithLabelValues(r.name).Inc()
+	writeTooManyRequests(w, fmt.Sprintf("%s rate limit exceeded", r.name), wait)
+	return false
+}
+
+func writeTooManyRequests(w rest.ResponseWriter, message string, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+	}
+	apiutils.WriteRestError(w, apierrors.NewTooManyRequests(message, retryAfter))
+}
+
+// rateLimitKeySalt is random for each process so that the rate limit keys
+// cannot be used to recover client addresses.
+var rateLimitKeySalt = func() []byte {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return salt
+}()
+
+// rateLimitKey returns the hashed rate limit key for the client address and
+// ASN of a request. Clients connect to central through transports shared by
+// many clients so the address which central receives requests from can not be
+// used, the address forwarded by the client is used instead. IPv6 addresses
+// are limited by their /64 network since a single host usually has the whole
+// network. A client can choose the forwarded address, the limit only stops
+// clients which do not lie about it.
+func rateLimitKey(IP net.IP, ASN int) string {
+	if IP.To4() == nil {
+		IP = IP.Mask(net.CIDRMask(64, 128))
+	}
+	h := sha256.New()
+	h.Write(rateLimitKeySalt)
+	fmt.Fprintf(h, "%s/%d", IP.String(), ASN)
+	return hex.EncodeToString(h.Sum(nil))
+}
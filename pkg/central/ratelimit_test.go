@@ -0,0 +1,130 @@
+package central
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter("test", 3600, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := r.allow("a", now); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	ok, wait := r.allow("a", now)
+	if ok || wait != time.Second {
+		t.Errorf("expected 1s wait, got %v %s", ok, wait)
+	}
+	if ok, _ := r.allow("b", now); !ok {
+		t.Error("keys should have separate buckets")
+	}
+	if ok, _ := r.allow("a", now.Add(time.Second)); !ok {
+		t.Error("bucket should have been refilled")
+	}
+
+	r.expire(now.Add(time.Second))
+	if len(r.buckets) != 2 {
+		t.Errorf("expected no buckets to be expired, got %d", len(r.buckets))
+	}
+	r.expire(now.Add(time.Minute))
+	if len(r.buckets) != 0 {
+		t.Errorf("expected all buckets to be expired, got %d", len(r.buckets))
+	}
+
+	unlimited := newRateLimiter("test", 0, 0)
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.allow("a", now); !ok {
+			t.Fatal("a zero rate should disable the limit")
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	key := func(addr string, ASN int) string {
+		return rateLimitKey(net.ParseIP(addr), ASN)
+	}
+	if key("85.225.60.122", 1) != key("85.225.60.122", 1) {
+		t.Error("expected stable keys")
+	}
+	if key("85.225.60.122", 1) == key("85.225.60.123", 1) {
+		t.Error("expected IPv4 addresses to have separate keys")
+	}
+	if key("85.225.60.122", 1) == key("85.225.60.122", 2) {
+		t.Error("expected ASN to be part of the key")
+	}
+	if key("2001:db8::1", 1) != key("2001:db8::2", 1) {
+		t.Error("expected IPv6 addresses to be keyed by network")
+	}
+	if key("2001:db8::1", 1) == key("2001:db8:0:1::1", 1) {
+		t.Error("expected IPv6 networks to have separate keys")
+	}
+}
+
+func TestRateLimiterFull(t *testing.T) {
+	r := newRateLimiter("test", 3600, 1)
+	now := time.Now()
+	for i := 0; i < maxRateLimitBuckets; i++ {
+		if ok, _ := r.allow(fmt.Sprint(i), now.Add(time.Duration(i)*time.Microsecond)); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	now = now.Add(time.Second)
+	if ok, _ := r.allow("0", now); !ok {
+		t.Error("expected existing keys to be allowed when full")
+	}
+	if ok, _ := r.allow("new", now); !ok {
+		t.Error("expected new keys to be allowed when full")
+	}
+	if len(r.buckets) != maxRateLimitBuckets {
+		t.Errorf("expected %d buckets, got %d", maxRateLimitBuckets, len(r.buckets))
+	}
+	if _, ok := r.buckets["1"]; ok {
+		t.Error("expected the least recently used bucket to be evicted")
+	}
+	if _, ok := r.buckets["0"]; !ok {
+		t.Error("expected the recently used bucket to be kept")
+	}
+	if ok, _ := r.allow("new2", now.Add(time.Minute)); !ok {
+		t.Error("expected idle buckets to be expired when full")
+	}
+	if len(r.buckets) != 1 {
+		t.Errorf("expected 1 bucket, got %d", len(r.buckets))
+	}
+}
+
+func TestRateLimiterCheck(t *testing.T) {
+	limiter := newRateLimiter("test", 1, 1)
+	api := rest.NewApi()
+	router, err := rest.MakeRouter(rest.Post("/", func(w rest.ResponseWriter, r *rest.Request) {
+		if limiter.check(w, "key") {
+			w.WriteJson(map[string]bool{"Ok": true})
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	rec := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/", nil))
+	rec.CodeIs(200)
+	rec = test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/", nil))
+	rec.CodeIs(apierrors.StatusTooManyRequests)
+	rec.HeaderIs("Retry-After", "3600")
+	var status shared.Status
+	if err := rec.DecodeJsonPayload(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Reason != shared.StatusReasonTooManyRequests || status.Details.RetryAfterSeconds != 3600 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
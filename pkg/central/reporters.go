@@ -0,0 +1,29 @@
+package central
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+)
+
+// reporterHashKey is the key used by reporterAddrHash, it is loaded from the
+// database by Init so that all central processes share it.
+var reporterHashKey []byte
+
+// reporterAddrHash returns the keyed hash of the network of IP which is
+// stored instead of the address to tell suggestion sessions from different
+// reporters apart. The /24 network is used for IPv4 and the /48 network for
+// IPv6 addresses so that a reporter cannot appear as many by using several
+// addresses from the same network.
+func reporterAddrHash(IP net.IP) string {
+	var network net.IP
+	if v4 := IP.To4(); v4 != nil {
+		network = v4.Mask(net.CIDRMask(24, 32))
+	} else {
+		network = IP.Mask(net.CIDRMask(48, 128))
+	}
+	mac := hmac.New(sha256.New, reporterHashKey)
+	mac.Write(network)
+	return hex.EncodeToString(mac.Sum(nil))
+}
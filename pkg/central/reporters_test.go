@@ -0,0 +1,23 @@
+package central
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReporterAddrHash(t *testing.T) {
+	for _, v := range []struct {
+		a, b string
+		same bool
+	}{
+		{"85.225.60.122", "85.225.60.1", true},
+		{"85.225.60.122", "85.225.61.122", false},
+		{"2001:db8:1:2::1", "2001:db8:1:3::1", true},
+		{"2001:db8:1::1", "2001:db8:2::1", false},
+	} {
+		a, b := reporterAddrHash(net.ParseIP(v.a)), reporterAddrHash(net.ParseIP(v.b))
+		if (a == b) != v.same {
+			t.Errorf("%s %s: expected same hash %v", v.a, v.b, v.same)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package central
+
+import (
+	"flag"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/shared/apierrors"
+	"github.com/alkasir/alkasir/pkg/shared/apiutils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/thomasf/lg"
+)
+
+var (
+	statsRefreshInterval = flag.Duration("statsRefreshInterval", 15*time.Minute, "how often the statistics rollups of the export api are refreshed, 0 disables refreshing")
+	statsDefaultRange    = flag.Duration("statsDefaultRange", 90*24*time.Hour, "time range of export api statistics requests without a since parameter")
+)
+
+// refreshStats refreshes all statistics rollups, including the sessions
+// rollup used by the privacy filter.
+func refreshStats(clients db.Clients) {
+	for _, name := range append(db.StatsNames, db.StatsSessions) {
+		start := time.Now()
+		n, err := clients.DB.RefreshStats(name)
+		if err != nil {
+			lg.Errorf("could not refresh %s statistics: %v", name, err)
+			continue
+		}
+		lg.V(3).Infof("refreshed %d days of %s statistics in %s", n, name, time.Since(start))
+	}
+}
+
+// startStatsRefresher refreshes the statistics rollups every interval.
+func startStatsRefresher(clients db.Clients, interval time.Duration) {
+	if interval <= 0 {
+		lg.Warningln("statistics refreshing is disabled")
+		return
+	}
+	refreshStats(clients)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for range tick.C {
+		refreshStats(clients)
+	}
+}
+
+// GetStatsExport lists the daily counts of the named statistics rollup, with
+// noise added when -statsNoiseEpsilon is set.
+func GetStatsExport(dbclients db.Clients, name string) func(w rest.ResponseWriter, r *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		er, err := parseExportRequest(r, "country_code", "asn")
+		if err != nil {
+			apiutils.WriteRestError(w, err)
+			return
+		}
+		if er.cursor != 0 || er.limit != 0 {
+			apiutils.WriteRestError(w, apierrors.NewBadRequest("statistics are not paginated"))
+			return
+		}
+		if er.filter.Since.IsZero() {
+			er.filter.Since = time.Now().Add(-*statsDefaultRange)
+		}
+		writeExport(w, r, er, func(idMax, limit int) (interface{}, string, error) {
+			entries, err := dbclients.DB.GetStats(shared.ExportStatsRequest{
+				Name:         name,
+				ExportFilter: er.filter,
+			})
+			addStatsNoise(name, entries, *statsNoiseEpsilon)
+			return entries, "", err
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package central
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+func TestStatsExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, v := range []struct {
+		cc, origin string
+		asn        int
+	}{{"SE", "a", 1}, {"SE", "b", 1}, {"SE", "a", 1}, {"IR", "c", 2}} {
+		err := d.InsertSimpleSample(db.SimpleSample{
+			CountryCode: v.cc,
+			ASN:         v.asn,
+			Type:        "ClientBlocklistUpdate",
+			OriginID:    v.origin,
+			Data:        []byte(`{"version":"0.4.8"}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clients := db.Clients{DB: d}
+	refreshStats(clients)
+
+	router, err := rest.MakeRouter(
+		rest.Get("/v1/stats/active_clients/", GetStatsExport(clients, db.StatsActiveClients)),
+		rest.Get("/v1/stats/client_versions/", GetStatsExport(clients, db.StatsClientVersions)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	for u, expected := range map[string][]int{
+		"http://localhost/v1/stats/active_clients/":                        {1, 2},
+		"http://localhost/v1/stats/active_clients/?country_code=SE":        {2},
+		"http://localhost/v1/stats/active_clients/?until=2000-01-01":       {},
+		"http://localhost/v1/stats/client_versions/?country_code=IR&asn=0": {1},
+	} {
+		rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", u, nil))
+		rec.CodeIs(200)
+		var entries []shared.ExportStatsEntry
+		if err := rec.DecodeJsonPayload(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(expected) {
+			t.Errorf("%s: unexpected entries: %+v", u, entries)
+			continue
+		}
+		for i, e := range entries {
+			if e.Count != expected[i] || e.Bucket.IsZero() {
+				t.Errorf("%s: unexpected entry: %+v", u, e)
+			}
+		}
+	}
+	rec := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/stats/active_clients/?cursor=1", nil))
+	rec.CodeIs(400)
+}
@@ -0,0 +1,188 @@
+package central
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thomasf/lg"
+)
+
+var (
+	webhookInterval = flag.Duration("webhookInterval", 10*time.Second, "how often publish events are delivered to webhook subscriptions, 0 disables delivery")
+	webhookTimeout  = flag.Duration("webhookTimeout", 10*time.Second, "timeout of webhook deliveries")
+	webhookRetryMax = flag.Duration("webhookRetryMax", time.Hour, "maximum time between retries of failed webhook deliveries")
+)
+
+// webhookBatchSize is the maximum number of events in a webhook delivery.
+const webhookBatchSize = 100
+
+// Headers of webhook deliveries.
+const (
+	webhookTimestampHeader = "X-Alkasir-Timestamp"
+	webhookSignatureHeader = "X-Alkasir-Signature"
+)
+
+var webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "central_webhook_deliveries_total",
+	Help: "Total webhook deliveries of publish events",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries)
+}
+
+// webhookSignature returns the signature header value of a delivery, the hex
+// encoded HMAC-SHA256 of the timestamp, a dot and the body keyed with the
+// subscription secret.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp+".")
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the time to wait before retrying a subscription
+// which has failed failures times in a row. It doubles from base up to max.
+func webhookBackoff(base, max time.Duration, failures int) time.Duration {
+	d := base
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// webhookDispatcher delivers publish events to the webhook subscriptions.
+// Events are delivered in order, a subscription does not get newer events
+// until the oldest undelivered batch is accepted.
+type webhookDispatcher struct {
+	dbclients db.Clients
+	client    *http.Client
+	base, max time.Duration        // retry backoff
+	retryAt   map[uint64]time.Time // subscription id to next attempt after failures
+}
+
+// newWebhookDispatcher returns a dispatcher which retries failed deliveries
+// after base, doubling the time for each failure up to max.
+func newWebhookDispatcher(dbclients db.Clients, base, max time.Duration) *webhookDispatcher {
+	return &webhookDispatcher{
+		dbclients: dbclients,
+		client: &http.Client{
+			Timeout: *webhookTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		base:    base,
+		max:     max,
+		retryAt: make(map[uint64]time.Time, 0),
+	}
+}
+
+// dispatch delivers the next batch of events to each enabled subscription
+// that is not waiting to be retried.
+func (d *webhookDispatcher) dispatch() {
+	subs, err := d.dbclients.DB.ListWebhookSubscriptions()
+	if err != nil {
+		lg.Errorf("could not list webhook subscriptions: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, sub := range subs {
+		if !sub.Enabled || now.Before(d.retryAt[sub.ID]) {
+			continue
+		}
+		events, err := d.dbclients.DB.GetPublishEvents(sub.LastEventID,
+			shared.ExportFilter{CountryCode: sub.CountryCode}, webhookBatchSize)
+		if err != nil {
+			lg.Errorf("could not get publish events for webhook %d: %v", sub.ID, err)
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+		err = d.deliver(sub, events)
+		if err != nil {
+			sub.Failures++
+			sub.LastError = err.Error()
+			d.retryAt[sub.ID] = now.Add(webhookBackoff(d.base, d.max, sub.Failures))
+			webhookDeliveries.WithLabelValues("failed").Inc()
+			lg.Warningf("webhook %d delivery failed %d times: %v", sub.ID, sub.Failures, err)
+		} else {
+			id, err := strconv.Atoi(events[len(events)-1].ID)
+			if err != nil {
+				lg.Errorf("invalid publish event id %s: %v", events[len(events)-1].ID, err)
+				continue
+			}
+			sub.LastEventID = id
+			sub.Failures = 0
+			sub.LastError = ""
+			delete(d.retryAt, sub.ID)
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			lg.V(5).Infof("delivered %d publish events to webhook %d", len(events), sub.ID)
+		}
+		if err := d.dbclients.DB.UpdateWebhookSubscriptionState(sub); err != nil {
+			lg.Errorf("could not update webhook %d: %v", sub.ID, err)
+		}
+	}
+}
+
+// deliver posts events as a json array to the subscription url, any response
+// status other than 2xx is a failure.
+func (d *webhookDispatcher) deliver(sub db.WebhookSubscription, events []shared.HostsPublishLog) error {
+	if err := db.ValidateWebhookURL(sub.URL); err != nil {
+		return err
+	}
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, webhookSignature(sub.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// startWebhookDispatcher delivers publish events to the webhook subscriptions
+// every interval.
+func startWebhookDispatcher(clients db.Clients, interval time.Duration) {
+	if interval <= 0 {
+		lg.Warningln("webhook delivery is disabled")
+		return
+	}
+	d := newWebhookDispatcher(clients, interval, *webhookRetryMax)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for range tick.C {
+		d.dispatch()
+	}
+}
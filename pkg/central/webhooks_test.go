@@ -0,0 +1,126 @@
+package central
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/db"
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	for failures, expected := range []time.Duration{
+		time.Second, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	} {
+		if d := webhookBackoff(time.Second, 10*time.Second, failures); d != expected {
+			t.Errorf("%d failures: expected %s, got %s", failures, expected, d)
+		}
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alkasir-central-webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.OpenSQLite(filepath.Join(dir, "central.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var (
+		mu       sync.Mutex
+		status   = http.StatusInternalServerError
+		received [][]shared.HostsPublishLog
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		sig := webhookSignature("secret", r.Header.Get(webhookTimestampHeader), body)
+		if r.Header.Get(webhookSignatureHeader) != sig {
+			t.Errorf("invalid signature: %s", r.Header.Get(webhookSignatureHeader))
+		}
+		var events []shared.HostsPublishLog
+		if err := json.Unmarshal(body, &events); err != nil {
+			t.Error(err)
+		}
+		received = append(received, events)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if err := d.PublishHostRule("before.example.com", "SE", 1); err != nil {
+		t.Fatal(err)
+	}
+	id, err := d.InsertWebhookSubscription(db.WebhookSubscription{
+		URL:         server.URL + "/hook",
+		Secret:      "secret",
+		CountryCode: "SE",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		host, cc string
+	}{{"a.example.com", "SE"}, {"b.example.com", "IR"}, {"c.example.com", "SE"}} {
+		if err := d.PublishHostRule(v.host, v.cc, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatcher := newWebhookDispatcher(db.Clients{DB: d}, time.Hour, time.Hour)
+	dispatcher.client = server.Client()
+	state := func() db.WebhookSubscription {
+		subs, err := d.ListWebhookSubscriptions()
+		if err != nil || len(subs) != 1 || subs[0].ID != id {
+			t.Fatal(subs, err)
+		}
+		return subs[0]
+	}
+
+	// failed deliveries are not retried before the backoff.
+	dispatcher.dispatch()
+	dispatcher.dispatch()
+	if s := state(); s.Failures != 1 || s.LastError == "" {
+		t.Errorf("expected a failure, got %+v", s)
+	}
+	mu.Lock()
+	if len(received) != 1 {
+		t.Errorf("expected 1 delivery, got %d", len(received))
+	}
+	status = http.StatusOK
+	mu.Unlock()
+
+	dispatcher.retryAt[id] = time.Time{}
+	dispatcher.dispatch()
+	s := state()
+	if s.Failures != 0 || s.LastError != "" {
+		t.Errorf("expected delivery, got %+v", s)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(received))
+	}
+	events := received[1]
+	if len(events) != 2 || events[0].Host != "a.example.com" || events[1].Host != "c.example.com" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	if s.LastEventID == 0 || events[1].ID != strconv.Itoa(s.LastEventID) {
+		t.Errorf("unexpected last event id %d, events: %+v", s.LastEventID, events)
+	}
+}
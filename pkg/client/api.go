@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,8 +18,6 @@ import (
 	clientconfig "github.com/alkasir/alkasir/pkg/client/internal/config"
 	"github.com/alkasir/alkasir/pkg/client/ui"
 	"github.com/alkasir/alkasir/pkg/debugexport"
-	"github.com/alkasir/alkasir/pkg/measure"
-	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
 	"github.com/alkasir/alkasir/pkg/pac"
 	"github.com/alkasir/alkasir/pkg/service"
 	"github.com/alkasir/alkasir/pkg/shared"
@@ -93,6 +92,7 @@ type Notification struct {
 	Priority    int                  `json:"priority"`  // Priority ranges from -2 to 2. -2 is lowest priority. 2 is highest. Zero is default.
 	Title       string               `json:"title"`     // header
 	Message     string               `json:"message"`   // body
+	MessageArgs map[string]string    `json:"messageArgs,omitempty"` // placeholder values for Message
 	Actions     []NotificationAction `json:"actions"`
 	Dismissable bool                 `json:"dismissable"` // can the user dismiss this
 }
@@ -162,6 +162,21 @@ func GetNotifications(w rest.ResponseWriter, r *rest.Request) {
 		})
 	}
 
+	if delta := getLastBlocklistDelta(); time.Since(lastBlocklistChange) < 24*time.Hour &&
+		len(delta.Added)+len(delta.Removed) > 0 {
+		notifications = append(notifications, Notification{
+			EventTime: lastBlocklistChange,
+			Level:     "info",
+			Title:     "blocklist_changed_title",
+			Message:   "blocklist_changed_message",
+			MessageArgs: map[string]string{
+				"added":   strconv.Itoa(len(delta.Added)),
+				"removed": strconv.Itoa(len(delta.Removed)),
+			},
+			Dismissable: true,
+		})
+	}
+
 	notifications = prepareNotifications(notifications)
 	w.WriteJson(notifications)
 }
@@ -181,6 +196,7 @@ type UserSettings struct {
 	CountryCode         string   `json:"countryCode"`
 	ClientAutoUpdate    bool     `json:"clientAutoUpdate"`
 	BlocklistAutoUpdate bool     `json:"blocklistAutoUpdate"`
+	HTTPBodyCapture     bool     `json:"httpBodyCapture"`
 }
 
 func GetConnections(w rest.ResponseWriter, r *rest.Request) {
@@ -394,6 +410,7 @@ func GetUserSettings(w rest.ResponseWriter, r *rest.Request) {
 		CountryCode:         conf.Settings.Local.CountryCode,
 		ClientAutoUpdate:    conf.Settings.Local.ClientAutoUpdate,
 		BlocklistAutoUpdate: conf.Settings.Local.BlocklistAutoUpdate,
+		HTTPBodyCapture:     conf.Settings.Local.HTTPBodyCapture,
 	}
 	w.WriteJson(response)
 }
@@ -432,6 +449,10 @@ func PostUserSettings(w rest.ResponseWriter, r *rest.Request) {
 			s.BlocklistAutoUpdate = form.BlocklistAutoUpdate
 			changed = true
 		}
+		if s.HTTPBodyCapture != form.HTTPBodyCapture {
+			s.HTTPBodyCapture = form.HTTPBodyCapture
+			changed = true
+		}
 
 		return nil
 	})
@@ -543,30 +564,20 @@ func CreateSuggestion(w rest.ResponseWriter, r *rest.Request) {
 	}
 
 	s := client.NewSuggestion(u.String())
-	defer s.DoneAddingSamples()
-	measurers, err := measure.DefaultMeasurements(form.URL)
+	followUps, err := measureURL(form.URL, s.AddMeasurement)
 	if err != nil {
+		s.DoneAddingSamples()
 		apiutils.WriteRestError(w, apierrors.NewInternalError(err))
 		return
 	}
-
-	for _, v := range measurers {
-		m, err := v.Measure()
-		if err != nil {
-			lg.Errorf("could not measure: %s", err.Error())
-		} else {
-			switch m.Type() {
-			case sampletypes.DNSQuery, sampletypes.HTTPHeader:
-				err = s.AddMeasurement(m)
-				if err != nil {
-					lg.Errorln(err.Error())
-					return
-				}
-			default:
-				lg.Warningf("unsupported sample type: %s", m.Type().String())
-			}
+	// follow up measurements can take minutes, their samples are sent by
+	// SubmitSuggestion once the suggestion is prepared.
+	go func() {
+		defer s.DoneAddingSamples()
+		if _, err := runMeasurements(followUps, s.AddMeasurement); err != nil {
+			lg.Errorln(err.Error())
 		}
-	}
+	}()
 }
 
 func GetSuggestion(w rest.ResponseWriter, r *rest.Request) {
@@ -633,7 +644,7 @@ func SubmitSuggestion(w rest.ResponseWriter, r *rest.Request) {
 	}
 
 	tokenResp, err := suggestion.RequestToken(
-		restclient, wanip, conf.Settings.Local.CountryCode)
+		restclient, wanip, conf.Settings.Local.CountryCode, conf.Settings.UpdateID)
 	if err != nil {
 		if apiutils.IsNetError(err) {
 			apiutils.WriteRestError(w, apierrors.NewServerTimeout("alkasir-central", "request-submission-token", 0))
@@ -693,7 +704,9 @@ func SubmitSuggestion(w rest.ResponseWriter, r *rest.Request) {
 		lg.Errorln(err)
 	} else {
 		err := clientconfig.Update(func(conf *clientconfig.Config) error {
-			conf.BlockedHosts.Add(u.Host)
+			if err := conf.BlockedHosts.Add(u.Hostname()); err != nil {
+				return err
+			}
 			lastBlocklistChange = time.Now()
 
 			pac.UpdateBlockedList(conf.BlockedHostsCentral.Hosts,
@@ -906,7 +919,11 @@ func GetAllHostPatterns(w rest.ResponseWriter, r *rest.Request) {
 	}
 
 	for _, value := range conf.BlockedHostsCentral.Hosts {
-		items = append(items, toHostPatternListItem(value, "blocked-central"))
+		if scope, ok := conf.Settings.BlocklistScopes[value]; ok {
+			items = append(items, toHostPatternListItem(value, "blocked-central", "scope-"+scope))
+		} else {
+			items = append(items, toHostPatternListItem(value, "blocked-central"))
+		}
 	}
 
 	w.WriteJson(items)
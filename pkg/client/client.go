@@ -33,6 +33,7 @@ var (
 	clientAuthKeyFlag string
 	centralAddrFlag   string
 	bindAddrFlag      string
+	blocklistPubKey   string
 
 	upgradeDiffsBaseURL string // this value is overridden on release builds, also the full url will be provided by the server.
 )
@@ -47,6 +48,7 @@ func init() {
 	flag.StringVar(&clientAuthKeyFlag, "authKey", "", "Override generated client<->browser authentication key")
 	flag.StringVar(&centralAddrFlag, "centralAddr", "", "Override the URL to where the central server is expected to exist")
 	flag.StringVar(&bindAddrFlag, "bindAddr", "", "Override the configured client bindAddr")
+	flag.StringVar(&blocklistPubKey, "blocklistPubKey", "", "Path to a public key file which overrides the built in hosts list verification key (development feature)")
 }
 
 var (
@@ -226,6 +228,7 @@ func Run() {
 		lastBlocklistChange = time.Now()
 
 		go StartBlocklistUpgrader()
+		go StartBlocklistReverifier()
 		if upgradeDiffsBaseURL != "" {
 			lg.V(19).Infoln("upgradeDiffsBaseURL is ", upgradeDiffsBaseURL)
 			go StartBinaryUpgradeChecker(upgradeDiffsBaseURL)
@@ -206,11 +206,16 @@ func ConfigPath(file ...string) string {
 // Settings is the in memory representation of the settings file which usually
 // is loaded/saved from disk.
 type Settings struct {
-	Version     int // settings version
-	LastID      int // last (week numbr % 3 ) + 1 an id counter was sent.
-	Local       localSettings
-	Connections []shared.Connection
-	Transports  map[string]shared.Transport
+	Version            int               // settings version
+	LastID             int               // last (week numbr % 3 ) + 1 an id counter was sent.
+	UpdateID           string            // the update id sent for LastID, also sent with suggestions
+	BlocklistRevision  string            // revision of BlockedHostsCentral as reported by central
+	BlocklistTimestamp time.Time         // signature timestamp of the last accepted hosts list
+	BlocklistASN       int               // ASN of the last accepted hosts list
+	BlocklistScopes    map[string]string // scope of each host in BlockedHostsCentral
+	Local              localSettings
+	Connections        []shared.Connection
+	Transports         map[string]shared.Transport
 }
 
 type localSettings struct {
@@ -220,6 +225,7 @@ type localSettings struct {
 	Language            string // Defaults to en under testing.
 	ClientAutoUpdate    bool
 	BlocklistAutoUpdate bool
+	HTTPBodyCapture     bool   // If true, response body fingerprints are included in suggestion samples
 	CentralAddr         string // The base address for alakasir central server
 }
 
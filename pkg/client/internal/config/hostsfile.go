@@ -8,10 +8,12 @@ import (
 	"path"
 	"strings"
 
+	"github.com/alkasir/alkasir/pkg/shared"
 	"github.com/thomasf/lg"
 )
 
-// HostsFile represents a text file with one url per line.
+// HostsFile represents a text file with one host rule per line, see
+// shared.HostRule for the format.
 type HostsFile struct {
 	Name        string
 	Hosts       []string
@@ -19,21 +21,28 @@ type HostsFile struct {
 }
 
 // Add an entry to a HostsFile
-func (h *HostsFile) Add(host string) {
-	// todo.. this is a good place to check the format of the string
-	host = strings.TrimSpace(host)
+func (h *HostsFile) Add(host string) error {
+	rule, err := shared.ParseHostRule(host)
+	if err != nil {
+		return err
+	}
+	host = rule.String()
 	for _, h := range h.Hosts {
 		if h == host {
-			return
+			return nil
 		}
 	}
 	h.Hosts = append(h.Hosts, host)
+	return nil
 }
 
 // Remove an entry from an HostsFile
 func (h *HostsFile) Remove(host string) {
 	var hosts []string
 	host = strings.TrimSpace(host)
+	if rule, err := shared.ParseHostRule(host); err == nil {
+		host = rule.String()
+	}
 	for _, h := range h.Hosts {
 		if h != host {
 			hosts = append(hosts, h)
@@ -42,6 +51,21 @@ func (h *HostsFile) Remove(host string) {
 	h.Hosts = hosts
 }
 
+// Rules returns the parsed host rules, invalid entries are skipped.
+func (h *HostsFile) Rules() shared.HostRules {
+	rules, errs := shared.ParseHostRules(h.Hosts)
+	for _, err := range errs {
+		lg.V(5).Infof("%s: %v", h.Name, err)
+	}
+	return rules
+}
+
+// Match returns true if host and path are matched by the rules in the
+// HostsFile.
+func (h *HostsFile) Match(host, path string) bool {
+	return h.Rules().Match(host, path)
+}
+
 func (h *HostsFile) fullpath(basedir string) string {
 	return path.Join(basedir, "hostlists", h.CountryCode, h.Name+".txt")
 }
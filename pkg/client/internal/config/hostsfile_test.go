@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 
 	. "github.com/alkasir/alkasir/pkg/client/internal/config"
@@ -101,3 +102,53 @@ func TestReadHostsfile(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestAddHostRules(t *testing.T) {
+	hf := &HostsFile{
+		Name:        "testfile",
+		CountryCode: "SE",
+	}
+	for _, v := range []string{"*.Example.com", " !cdn.example.com", "10.0.0.1/8", "example.com/news/", "*.example.com"} {
+		if err := hf.Add(v); err != nil {
+			t.Errorf("could not add %s: %v", v, err)
+		}
+	}
+	expected := []string{"*.example.com", "!cdn.example.com", "10.0.0.0/8", "example.com/news/"}
+	if !reflect.DeepEqual(hf.Hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hf.Hosts)
+	}
+	for _, v := range []string{"", "!", "*.", "a..com", "exa mple.com", "*.1.2.3.4"} {
+		if err := hf.Add(v); err == nil {
+			t.Errorf("expected %s to be rejected", v)
+		}
+	}
+	hf.Remove("!CDN.example.com")
+	if len(hf.Hosts) != 3 {
+		t.Errorf("expected exclusion to be removed: %v", hf.Hosts)
+	}
+}
+
+func TestMatchHostRules(t *testing.T) {
+	hf := &HostsFile{
+		Name:  "testfile",
+		Hosts: []string{"*.example.com", "!cdn.example.com", "news.com/world/", "10.0.0.0/8", "invalid rule"},
+	}
+	tests := []struct {
+		host, path string
+		match      bool
+	}{
+		{"example.com", "", true},
+		{"www.example.com", "/", true},
+		{"cdn.example.com", "/", false},
+		{"notexample.com", "/", false},
+		{"news.com", "/world/europe", true},
+		{"news.com", "/sports/", false},
+		{"10.2.3.4", "/", true},
+		{"11.2.3.4", "/", false},
+	}
+	for _, tt := range tests {
+		if m := hf.Match(tt.host, tt.path); m != tt.match {
+			t.Errorf("%s%s: expected %v, got %v", tt.host, tt.path, tt.match, m)
+		}
+	}
+}
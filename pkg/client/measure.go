@@ -0,0 +1,53 @@
+package client
+
+import (
+	clientconfig "github.com/alkasir/alkasir/pkg/client/internal/config"
+	"github.com/alkasir/alkasir/pkg/measure"
+	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
+	"github.com/thomasf/lg"
+)
+
+// measureURL runs the default measurements for URL, TCP connections are made
+// to the addresses which the DNS measurements resolved. add is called with
+// every measurement of a sample type which central accepts, measuring stops
+// if add returns an error. The returned follow up measurers locate the
+// blocking of failed results and can take minutes to run, see
+// runMeasurements.
+func measureURL(URL string, add func(m measure.Measurement) error) ([]measure.Measurer, error) {
+	measurers, err := measure.DefaultMeasurements(URL)
+	if err != nil {
+		return nil, err
+	}
+	if clientconfig.Get().Settings.Local.HTTPBodyCapture {
+		measure.EnableBodyCapture(measurers)
+	}
+	return runMeasurements(measurers, add)
+}
+
+// runMeasurements runs measurers in order and calls add like measureURL. It
+// returns the follow up measurers of the results.
+func runMeasurements(measurers []measure.Measurer, add func(m measure.Measurement) error) ([]measure.Measurer, error) {
+	var (
+		results   []measure.Measurement
+		followUps []measure.Measurer
+	)
+	for _, v := range measurers {
+		m, err := measure.ResolvedAddresses(v, results).Measure()
+		if err != nil {
+			lg.Errorf("could not measure: %s", err.Error())
+			continue
+		}
+		results = append(results, m)
+		followUps = append(followUps, measure.FollowUpMeasurements([]measure.Measurement{m})...)
+		switch m.Type() {
+		case sampletypes.DNSQuery, sampletypes.HTTPHeader, sampletypes.TLSHandshake,
+			sampletypes.TCPConnect, sampletypes.DNSInjection, sampletypes.TTLProbe:
+			if err := add(m); err != nil {
+				return followUps, err
+			}
+		default:
+			lg.Warningf("unsupported sample type: %s", m.Type().String())
+		}
+	}
+	return followUps, nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/measure"
+)
+
+type testMeasurer struct {
+	m   measure.Measurement
+	err error
+}
+
+func (t testMeasurer) Measure() (measure.Measurement, error) {
+	return t.m, t.err
+}
+
+func TestRunMeasurements(t *testing.T) {
+	var added []measure.Measurement
+	add := func(m measure.Measurement) error {
+		added = append(added, m)
+		return nil
+	}
+	followUps, err := runMeasurements([]measure.Measurer{
+		testMeasurer{m: measure.DNSQueryResult{Hostname: "a.com"}},
+		testMeasurer{err: errors.New("failed")},
+		testMeasurer{m: measure.HTTPHeaderResult{URL: "http://a.com", Error: "reset"}},
+	}, add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 2 {
+		t.Errorf("expected 2 measurements, got %v", added)
+	}
+	if len(followUps) != 1 {
+		t.Fatalf("expected a follow up measurer, got %v", followUps)
+	}
+	if p, ok := followUps[0].(measure.TTLProbe); !ok || p.URL != "http://a.com" {
+		t.Errorf("unexpected follow up measurer %+v", followUps[0])
+	}
+
+	_, err = runMeasurements([]measure.Measurer{
+		testMeasurer{m: measure.DNSQueryResult{Hostname: "a.com"}},
+	}, func(m measure.Measurement) error { return errors.New("full") })
+	if err == nil {
+		t.Error("expected the add error")
+	}
+}
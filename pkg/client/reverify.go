@@ -0,0 +1,220 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/central/client"
+	clientconfig "github.com/alkasir/alkasir/pkg/client/internal/config"
+	"github.com/alkasir/alkasir/pkg/measure"
+	"github.com/alkasir/alkasir/pkg/pac"
+	"github.com/alkasir/alkasir/pkg/service"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/thomasf/lg"
+)
+
+const (
+	reverifyInterval   = 12 * time.Hour  // minimum time between re-verification runs
+	reverifyHostJitter = 5 * time.Minute // maximum random delay between each measured host
+)
+
+// StartBlocklistReverifier periodically measures all locally and centrally
+// blocked hosts and submits the results to central as new suggestion
+// sessions. Hosts which are found to be reachable are removed from the local
+// blocked hosts list, the central list follows the central analysis of these
+// sessions through the regular blocklist updates.
+//
+// This function runs in it's own goroutine.
+func StartBlocklistReverifier() {
+	for {
+		<-time.After(reverifyInterval +
+			time.Duration(rand.Int63n(int64(reverifyInterval))))
+
+		conf := clientconfig.Get()
+		if !conf.Settings.Local.BlocklistAutoUpdate {
+			lg.V(9).Infoln("blocklist auto update disabled, skipping re-verification")
+			continue
+		}
+		if conf.Settings.Local.CountryCode == "__" {
+			lg.V(9).Infoln("Country is __, skipping re-verification")
+			continue
+		}
+		if !service.TransportOk() {
+			lg.V(9).Infoln("transport not ok, skipping re-verification")
+			continue
+		}
+
+		hosts := reverifyHosts(conf.BlockedHosts.Hosts, conf.BlockedHostsCentral.Hosts)
+		lg.V(5).Infof("re-verifying %d blocked hosts", len(hosts))
+		for _, host := range hosts {
+			ok, err := reverifyHost(host, conf.Settings.Local.CountryCode)
+			if err != nil {
+				lg.Warningf("could not re-verify %s: %v", host, err)
+			}
+			if ok {
+				if err := unblockHost(host); err != nil {
+					lg.Errorln(err)
+				}
+			}
+			<-time.After(time.Duration(rand.Int63n(int64(reverifyHostJitter))))
+		}
+	}
+}
+
+// reverifyHosts returns the union of all host lists in random order. Host
+// rules are converted to a host and an optional path, exclusions and network
+// rules are skipped since they do not point to anything to measure.
+func reverifyHosts(lists ...[]string) []string {
+	seen := make(map[string]bool, 0)
+	var hosts []string
+	for _, list := range lists {
+		for _, h := range list {
+			rule, err := shared.ParseHostRule(h)
+			if err != nil || rule.Exclude || rule.Net != nil {
+				continue
+			}
+			h = rule.Host + rule.Path
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	for i := range hosts {
+		j := rand.Intn(i + 1)
+		hosts[i], hosts[j] = hosts[j], hosts[i]
+	}
+	return hosts
+}
+
+// reverifyHost runs the default measurements for host and sends them to
+// central under a new suggestion token which is marked as a re-verification.
+// It returns true if the measurements show that host is reachable, also when
+// the results could not be sent to central.
+func reverifyHost(host, countryCode string) (bool, error) {
+	URL := "http://" + host
+	var measurements []measure.Measurement
+	add := func(m measure.Measurement) error {
+		measurements = append(measurements, m)
+		return nil
+	}
+	followUps, err := measureURL(URL, add)
+	if err != nil {
+		return false, err
+	}
+	if _, err := runMeasurements(followUps, add); err != nil {
+		return false, err
+	}
+	if len(measurements) == 0 {
+		return false, errors.New("no measurements")
+	}
+	ok := reachable(measurements)
+
+	wanip := getPublicIPAddr()
+	if wanip == nil {
+		return ok, errors.New("could not resolve public ip addr")
+	}
+	restclient, err := NewRestClient()
+	if err != nil {
+		return ok, err
+	}
+	tokenResp, err := restclient.CreateSuggestionToken(shared.SuggestionTokenRequest{
+		URL:         URL,
+		ClientAddr:  wanip,
+		CountryCode: countryCode,
+		UpdateID:    clientconfig.Get().Settings.UpdateID,
+		Reverify:    true,
+	})
+	if err != nil {
+		return ok, err
+	}
+	if !tokenResp.Ok {
+		return ok, fmt.Errorf("suggestion token not accepted: %s", tokenResp.Error)
+	}
+
+	return ok, sendReverifySamples(restclient, tokenResp.Token, URL, wanip.String(), measurements)
+}
+
+// reachable returns true if the HTTP request succeeded, no DNS injection was
+// seen and every TLS handshake completed with a certificate which is valid
+// for the host. Block pages are often served with a successful HTTP status so
+// the TLS handshake has to succeed as well.
+func reachable(measurements []measure.Measurement) bool {
+	var httpOk, tlsOk bool
+	for _, v := range measurements {
+		switch m := v.(type) {
+		case measure.HTTPHeaderResult:
+			if m.Error != "" || m.StatusCode >= 400 {
+				return false
+			}
+			httpOk = true
+		case measure.TLSHandshakeResult:
+			if m.Error != "" || m.VerifyError != "" {
+				return false
+			}
+			tlsOk = true
+		case measure.DNSInjectionResult:
+			if m.Injected {
+				return false
+			}
+		}
+	}
+	return httpOk && tlsOk
+}
+
+// unblockHost removes the rules for host from the local blocked hosts list.
+func unblockHost(host string) error {
+	removed := false
+	err := clientconfig.Update(func(conf *clientconfig.Config) error {
+		for _, h := range conf.BlockedHosts.Hosts {
+			rule, err := shared.ParseHostRule(h)
+			if err != nil || rule.Exclude || rule.Net != nil || rule.Host+rule.Path != host {
+				continue
+			}
+			conf.BlockedHosts.Remove(h)
+			removed = true
+		}
+		if removed {
+			lastBlocklistChange = time.Now()
+			pac.UpdateBlockedList(conf.BlockedHostsCentral.Hosts,
+				conf.BlockedHosts.Hosts)
+		}
+		return nil
+	})
+	if err != nil || !removed {
+		return err
+	}
+	lg.V(5).Infof("%s is reachable, removed from the blocked hosts list", host)
+	return clientconfig.Write()
+}
+
+func sendReverifySamples(restclient *client.Client, token shared.SuggestionToken, URL, clientAddr string, measurements []measure.Measurement) error {
+	n := 0
+	for _, m := range measurements {
+		data, err := m.Marshal()
+		if err != nil {
+			return err
+		}
+		r, err := restclient.CreateSample(shared.StoreSampleRequest{
+			Sample: &shared.Sample{
+				Token:      token,
+				URL:        URL,
+				SampleType: m.Type().String(),
+				Data:       string(data),
+			},
+			ClientAddr: clientAddr,
+		})
+		if err != nil {
+			return err
+		}
+		if !r.Ok {
+			return fmt.Errorf("sample not accepted: %s", r.Error)
+		}
+		n++
+	}
+	lg.V(5).Infof("sent %d re-verification samples for %s", n, URL)
+	return nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/alkasir/alkasir/pkg/central/client"
+	"github.com/alkasir/alkasir/pkg/measure"
+	"github.com/alkasir/alkasir/pkg/shared"
+)
+
+func TestReverifyHosts(t *testing.T) {
+	hosts := reverifyHosts(
+		[]string{"a.com", "b.com"},
+		[]string{"b.com", "c.com", ""},
+	)
+	sort.Strings(hosts)
+	if len(hosts) != 3 || hosts[0] != "a.com" || hosts[1] != "b.com" || hosts[2] != "c.com" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+
+	hosts = reverifyHosts(
+		[]string{"*.a.com", "b.com/news/", "!c.com", "10.0.0.0/8"},
+	)
+	sort.Strings(hosts)
+	if len(hosts) != 2 || hosts[0] != "a.com" || hosts[1] != "b.com/news/" {
+		t.Errorf("unexpected hosts from rules: %v", hosts)
+	}
+}
+
+func TestSendReverifySamples(t *testing.T) {
+	var received []shared.StoreSampleRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/samples/", func(w http.ResponseWriter, r *http.Request) {
+		var req shared.StoreSampleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared.SampleResponse{Ok: true})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	err := sendReverifySamples(client.NewClient(ts.URL, nil),
+		"token", "http://a.com", "130.234.12.2",
+		[]measure.Measurement{
+			measure.DNSQueryResult{Hostname: "a.com", Addrs: []string{"1.1.1.1"}},
+			measure.HTTPHeaderResult{URL: "http://a.com", StatusCode: 200},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(received))
+	}
+	for _, v := range received {
+		if v.Token != "token" || v.URL != "http://a.com" || v.ClientAddr != "130.234.12.2" {
+			t.Errorf("unexpected sample: %+v", v.Sample)
+		}
+	}
+	if received[0].SampleType != "DNSQuery" || received[1].SampleType != "HTTPHeader" {
+		t.Errorf("unexpected sample types %s %s", received[0].SampleType, received[1].SampleType)
+	}
+}
+
+func TestReachable(t *testing.T) {
+	httpOk := measure.HTTPHeaderResult{URL: "http://a.com", StatusCode: 200}
+	tlsOk := measure.TLSHandshakeResult{Hostname: "a.com"}
+	tests := []struct {
+		name         string
+		measurements []measure.Measurement
+		ok           bool
+	}{
+		{"reachable", []measure.Measurement{httpOk, tlsOk, measure.DNSInjectionResult{}}, true},
+		{"no tls", []measure.Measurement{httpOk}, false},
+		{"http error", []measure.Measurement{measure.HTTPHeaderResult{Error: "reset"}, tlsOk}, false},
+		{"http status", []measure.Measurement{measure.HTTPHeaderResult{StatusCode: 403}, tlsOk}, false},
+		{"tls error", []measure.Measurement{httpOk, measure.TLSHandshakeResult{Error: "reset"}}, false},
+		{"invalid certificate", []measure.Measurement{httpOk, measure.TLSHandshakeResult{VerifyError: "x509"}}, false},
+		{"injected", []measure.Measurement{httpOk, tlsOk, measure.DNSInjectionResult{Injected: true}}, false},
+	}
+	for _, tt := range tests {
+		if ok := reachable(tt.measurements); ok != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.ok, ok)
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/agl/ed25519"
 	"github.com/alkasir/alkasir/pkg/client/internal/config"
 	"github.com/alkasir/alkasir/pkg/client/ui"
 	"github.com/alkasir/alkasir/pkg/pac"
 	"github.com/alkasir/alkasir/pkg/service"
 	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/upgradebin"
 	"github.com/nu7hatch/gouuid"
 	"github.com/thomasf/lg"
 )
@@ -97,14 +102,31 @@ func upgradeBlockList() (int, error) {
 		UpdateID:      updateID,
 		ClientVersion: VERSION,
 	}
+	// only ask for changes if the current list is for the same country
+	if conf.BlockedHostsCentral.CountryCode == conf.Settings.Local.CountryCode {
+		req.Revision = conf.Settings.BlocklistRevision
+	}
 	resp, err := restclient.UpdateHostlist(req)
-	n := len(resp.Hosts)
 	if err != nil {
-		return n, err
+		return 0, err
+	}
+	pubKey, err := blocklistVerificationKey()
+	if err != nil {
+		return 0, err
+	}
+	err = verifyHostlistResponse(resp, pubKey, conf.Settings.Local.CountryCode, req.Revision, conf.Settings.BlocklistASN, conf.Settings.BlocklistTimestamp, time.Now())
+	if err != nil {
+		return 0, err
 	}
+	prevHosts := append([]string(nil), conf.BlockedHostsCentral.Hosts...)
+	sort.Strings(prevHosts)
+	newHosts := applyHostlistResponse(prevHosts, resp)
+	newScopes := applyHostlistScopes(conf.Settings.BlocklistScopes, newHosts, resp)
+	n := len(newHosts)
 	if nowID != savedID {
 		err := clientconfig.Update(func(conf *clientconfig.Config) error {
 			conf.Settings.LastID = nowID
+			conf.Settings.UpdateID = updateID
 			return nil
 		})
 
@@ -118,17 +140,17 @@ func upgradeBlockList() (int, error) {
 
 	}
 
-	newHosts := resp.Hosts
-	prevHosts := conf.BlockedHostsCentral.Hosts
-
-	sort.Strings(newHosts)
-	sort.Strings(prevHosts)
-
 	if !reflect.DeepEqual(newHosts, prevHosts) {
 		err := clientconfig.Update(func(conf *clientconfig.Config) error {
 			lg.V(2).Infoln("hosts list updated and changed")
 			lg.V(20).Infoln("hosts received: %v", newHosts)
+			if conf.BlockedHostsCentral.CountryCode == conf.Settings.Local.CountryCode {
+				setLastBlocklistDelta(diffHostlists(prevHosts, newHosts))
+			} else {
+				setLastBlocklistDelta(blocklistDelta{})
+			}
 			conf.BlockedHostsCentral.Hosts = newHosts
+			conf.BlockedHostsCentral.CountryCode = conf.Settings.Local.CountryCode
 			pac.UpdateBlockedList(conf.BlockedHostsCentral.Hosts, conf.BlockedHosts.Hosts)
 			lastBlocklistChange = time.Now()
 			return nil
@@ -139,5 +161,175 @@ func upgradeBlockList() (int, error) {
 	} else {
 		lg.V(19).Infoln("Hostlists equal after update")
 	}
+	if resp.Revision != conf.Settings.BlocklistRevision ||
+		!resp.Timestamp.Equal(conf.Settings.BlocklistTimestamp) ||
+		resp.ASN != conf.Settings.BlocklistASN ||
+		!reflect.DeepEqual(newScopes, conf.Settings.BlocklistScopes) {
+		err := clientconfig.Update(func(conf *clientconfig.Config) error {
+			conf.Settings.BlocklistRevision = resp.Revision
+			conf.Settings.BlocklistTimestamp = resp.Timestamp
+			conf.Settings.BlocklistASN = resp.ASN
+			conf.Settings.BlocklistScopes = newScopes
+			return nil
+		})
+		if err != nil {
+			lg.Errorln(err)
+		}
+		err = clientconfig.Write()
+		if err != nil {
+			return n, err
+		}
+	}
 	return n, nil
 }
+
+const (
+	// maxBlocklistAge is the maximum age of a signed hosts list.
+	maxBlocklistAge = 7 * 24 * time.Hour
+	// maxBlocklistClockSkew is how far into the future a signed hosts list
+	// timestamp is allowed to be.
+	maxBlocklistClockSkew = 24 * time.Hour
+)
+
+// blocklistVerificationKey returns the key used to verify hosts lists from
+// central.
+func blocklistVerificationKey() (*[32]byte, error) {
+	if blocklistPubKey != "" {
+		pubPem, err := ioutil.ReadFile(blocklistPubKey)
+		if err != nil {
+			return nil, err
+		}
+		return upgradebin.DecodeNamedPublicKey(upgradebin.BlocklistKeyName, pubPem)
+	}
+	return upgradebin.DecodeNamedPublicKey(upgradebin.BlocklistKeyName, []byte(shared.BlocklistVerificationPublicKey))
+}
+
+// verifyHostlistResponse checks that resp is signed by central, that it is
+// for the country the client is configured for, that it is not older than the
+// previously accepted list and that a delta is relative to the revision sent
+// in the request and to the list for the same ASN.
+func verifyHostlistResponse(resp shared.UpdateHostlistResponse, pubKey *[32]byte, countryCode, revision string, ASN int, lastTimestamp, now time.Time) error {
+	if resp.Signature == "" {
+		return errors.New("hosts list is not signed")
+	}
+	sig, err := upgradebin.DecodeSignature(resp.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid hosts list signature: %v", err)
+	}
+	data, err := resp.SignedData()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pubKey, data, sig) {
+		return errors.New("hosts list signature verification failed")
+	}
+	if resp.CountryCode != countryCode {
+		return fmt.Errorf("hosts list is for country '%s', expected '%s'", resp.CountryCode, countryCode)
+	}
+	if resp.Timestamp.Before(lastTimestamp) {
+		return fmt.Errorf("hosts list from %s is older than the current list from %s", resp.Timestamp, lastTimestamp)
+	}
+	if now.Sub(resp.Timestamp) > maxBlocklistAge {
+		return fmt.Errorf("hosts list from %s has expired", resp.Timestamp)
+	}
+	if resp.Timestamp.Sub(now) > maxBlocklistClockSkew {
+		return fmt.Errorf("hosts list from %s is from the future", resp.Timestamp)
+	}
+	if resp.Delta && (revision == "" || resp.BaseRevision != revision) {
+		return fmt.Errorf("hosts list delta is based on revision '%s', expected '%s'", resp.BaseRevision, revision)
+	}
+	if resp.Delta && resp.ASN != ASN {
+		return fmt.Errorf("hosts list delta is for ASN %d, expected %d", resp.ASN, ASN)
+	}
+	return nil
+}
+
+// applyHostlistResponse returns the sorted hosts list which results from
+// applying resp to the sorted list prev.
+func applyHostlistResponse(prev []string, resp shared.UpdateHostlistResponse) []string {
+	if !resp.Delta {
+		hosts := append([]string(nil), resp.Hosts...)
+		sort.Strings(hosts)
+		return hosts
+	}
+	lg.V(5).Infof("got blocklist delta, %d added, %d removed", len(resp.Added), len(resp.Removed))
+	removed := make(map[string]bool, len(resp.Removed))
+	for _, h := range resp.Removed {
+		removed[h] = true
+	}
+	hosts := make(map[string]bool, len(prev)+len(resp.Added))
+	for _, h := range prev {
+		if !removed[h] {
+			hosts[h] = true
+		}
+	}
+	for _, h := range resp.Added {
+		hosts[h] = true
+	}
+	result := make([]string, 0, len(hosts))
+	for h := range hosts {
+		result = append(result, h)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// applyHostlistScopes returns the scopes of hosts after applying resp to the
+// previous scopes.
+func applyHostlistScopes(prev map[string]string, hosts []string, resp shared.UpdateHostlistResponse) map[string]string {
+	scopes := make(map[string]string, len(hosts))
+	for _, h := range hosts {
+		if s, ok := resp.Scopes[h]; ok {
+			scopes[h] = s
+		} else if s, ok := prev[h]; ok && resp.Delta {
+			scopes[h] = s
+		}
+	}
+	return scopes
+}
+
+// blocklistDelta describes what changed in the last blocklist update.
+type blocklistDelta struct {
+	Added   []string
+	Removed []string
+}
+
+var (
+	lastBlocklistDelta   blocklistDelta
+	lastBlocklistDeltaMu sync.Mutex
+)
+
+func setLastBlocklistDelta(d blocklistDelta) {
+	lastBlocklistDeltaMu.Lock()
+	defer lastBlocklistDeltaMu.Unlock()
+	lastBlocklistDelta = d
+}
+
+func getLastBlocklistDelta() blocklistDelta {
+	lastBlocklistDeltaMu.Lock()
+	defer lastBlocklistDeltaMu.Unlock()
+	return lastBlocklistDelta
+}
+
+// diffHostlists returns the hosts added and removed between prev and next.
+func diffHostlists(prev, next []string) blocklistDelta {
+	return blocklistDelta{
+		Added:   removedHosts(next, prev),
+		Removed: removedHosts(prev, next),
+	}
+}
+
+// removedHosts returns the hosts in prev which are not in next.
+func removedHosts(prev, next []string) []string {
+	nextHosts := make(map[string]bool, len(next))
+	for _, h := range next {
+		nextHosts[h] = true
+	}
+	var removed []string
+	for _, h := range prev {
+		if !nextHosts[h] {
+			removed = append(removed, h)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/alkasir/alkasir/pkg/shared"
+	"github.com/alkasir/alkasir/pkg/upgradebin"
+)
+
+func TestRemovedHosts(t *testing.T) {
+	removed := removedHosts(
+		[]string{"a.com", "b.com", "c.com"},
+		[]string{"b.com", "d.com"},
+	)
+	if !reflect.DeepEqual(removed, []string{"a.com", "c.com"}) {
+		t.Errorf("unexpected removed hosts: %v", removed)
+	}
+	if removed := removedHosts(nil, []string{"a.com"}); len(removed) != 0 {
+		t.Errorf("expected no removed hosts, got %v", removed)
+	}
+}
+
+func TestApplyHostlistResponse(t *testing.T) {
+	prev := []string{"a.com", "b.com", "c.com"}
+	hosts := applyHostlistResponse(prev, shared.UpdateHostlistResponse{
+		Delta:   true,
+		Added:   []string{"d.com", "a.com"},
+		Removed: []string{"b.com", "x.com"},
+	})
+	if !reflect.DeepEqual(hosts, []string{"a.com", "c.com", "d.com"}) {
+		t.Errorf("unexpected hosts after delta: %v", hosts)
+	}
+	if !reflect.DeepEqual(prev, []string{"a.com", "b.com", "c.com"}) {
+		t.Errorf("previous hosts modified: %v", prev)
+	}
+
+	hosts = applyHostlistResponse(prev, shared.UpdateHostlistResponse{
+		Hosts: []string{"z.com", "y.com"},
+	})
+	if !reflect.DeepEqual(hosts, []string{"y.com", "z.com"}) {
+		t.Errorf("unexpected hosts after full update: %v", hosts)
+	}
+}
+
+func TestDiffHostlists(t *testing.T) {
+	d := diffHostlists([]string{"a.com", "b.com"}, []string{"b.com", "c.com"})
+	if !reflect.DeepEqual(d.Added, []string{"c.com"}) {
+		t.Errorf("unexpected added hosts: %v", d.Added)
+	}
+	if !reflect.DeepEqual(d.Removed, []string{"a.com"}) {
+		t.Errorf("unexpected removed hosts: %v", d.Removed)
+	}
+}
+
+func signedHostlistResponse(t *testing.T, priv *[64]byte, resp shared.UpdateHostlistResponse) shared.UpdateHostlistResponse {
+	data, err := resp.SignedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(priv, data)
+	resp.Signature = base64.RawURLEncoding.EncodeToString(sig[:])
+	return resp
+}
+
+func TestVerifyHostlistResponse(t *testing.T) {
+	priv, pub := upgradebin.GenerateKeys(rand.Reader)
+	_, otherPub := upgradebin.GenerateKeys(rand.Reader)
+	now := time.Now().Truncate(time.Second)
+
+	full := signedHostlistResponse(t, priv, shared.UpdateHostlistResponse{
+		Hosts:       []string{"a.com"},
+		Revision:    "r2",
+		CountryCode: "SE",
+		Timestamp:   now,
+	})
+	delta := signedHostlistResponse(t, priv, shared.UpdateHostlistResponse{
+		Delta:        true,
+		Added:        []string{"b.com"},
+		Revision:     "r2",
+		BaseRevision: "r1",
+		CountryCode:  "SE",
+		ASN:          3301,
+		Timestamp:    now,
+	})
+	otherCountry := signedHostlistResponse(t, priv, shared.UpdateHostlistResponse{
+		Hosts:       []string{"a.com"},
+		Revision:    "r2",
+		CountryCode: "IR",
+		Timestamp:   now,
+	})
+	tampered := full
+	tampered.Hosts = []string{"a.com", "evil.com"}
+	otherASN := delta
+	otherASN.ASN = 1257
+	unsigned := full
+	unsigned.Signature = ""
+
+	// JSON round trip drops empty slices
+	var roundtrip shared.UpdateHostlistResponse
+	data, err := json.Marshal(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &roundtrip); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		resp     shared.UpdateHostlistResponse
+		pub      *[32]byte
+		revision string
+		asn      int
+		last     time.Time
+		ok       bool
+	}{
+		{"full", full, pub, "", 3301, time.Time{}, true},
+		{"full with revision", full, pub, "r0", 3301, now.Add(-time.Hour), true},
+		{"delta", delta, pub, "r1", 3301, time.Time{}, true},
+		{"roundtrip", roundtrip, pub, "r1", 3301, time.Time{}, true},
+		{"delta wrong base", delta, pub, "r0", 3301, time.Time{}, false},
+		{"delta no base", delta, pub, "", 3301, time.Time{}, false},
+		{"tampered", tampered, pub, "", 3301, time.Time{}, false},
+		{"unsigned", unsigned, pub, "", 3301, time.Time{}, false},
+		{"wrong key", full, otherPub, "", 3301, time.Time{}, false},
+		{"replayed", full, pub, "", 3301, now.Add(time.Hour), false},
+		{"other country", otherCountry, pub, "", 3301, time.Time{}, false},
+		{"full other asn", full, pub, "", 1257, time.Time{}, true},
+		{"delta other asn", delta, pub, "r1", 1257, time.Time{}, false},
+		{"tampered asn", otherASN, pub, "r1", 1257, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		err := verifyHostlistResponse(tt.resp, tt.pub, "SE", tt.revision, tt.asn, tt.last, now)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got err=%v", tt.name, tt.ok, err)
+		}
+	}
+
+	if err := verifyHostlistResponse(full, pub, "SE", "", 0, time.Time{}, now.Add(maxBlocklistAge+time.Hour)); err == nil {
+		t.Error("expected expired hosts list to fail")
+	}
+	if err := verifyHostlistResponse(full, pub, "SE", "", 0, time.Time{}, now.Add(-maxBlocklistClockSkew-time.Hour)); err == nil {
+		t.Error("expected hosts list from the future to fail")
+	}
+}
+
+func TestBlocklistVerificationKey(t *testing.T) {
+	if _, err := blocklistVerificationKey(); err != nil {
+		t.Errorf("invalid built in blocklist verification key: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "alkasir-client-blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	priv, pub := upgradebin.GenerateKeys(rand.Reader)
+	_, pubPem := upgradebin.EncodeNamedKeys(upgradebin.BlocklistKeyName, priv, pub)
+	_, upgradesPem := upgradebin.EncodeKeys(priv, pub)
+	defer func(v string) { blocklistPubKey = v }(blocklistPubKey)
+	blocklistPubKey = filepath.Join(dir, "blocklist-public-key.pem")
+	for _, v := range []struct {
+		pem []byte
+		ok  bool
+	}{{pubPem, true}, {upgradesPem, false}} {
+		if err := ioutil.WriteFile(blocklistPubKey, v.pem, 0600); err != nil {
+			t.Fatal(err)
+		}
+		key, err := blocklistVerificationKey()
+		if (err == nil) != v.ok || (v.ok && *key != *pub) {
+			t.Errorf("unexpected key from %s: %v", v.pem, err)
+		}
+	}
+}
+
+func TestApplyHostlistScopes(t *testing.T) {
+	prev := map[string]string{
+		"a.com": shared.HostScopeASN,
+		"b.com": shared.HostScopeASN,
+		"c.com": shared.HostScopeASN,
+	}
+	resp := shared.UpdateHostlistResponse{
+		Delta:   true,
+		Added:   []string{"b.com", "d.com"},
+		Removed: []string{"c.com"},
+		Scopes: map[string]string{
+			"b.com": shared.HostScopeCountry,
+			"d.com": shared.HostScopeCountry,
+		},
+	}
+	hosts := applyHostlistResponse([]string{"a.com", "b.com", "c.com"}, resp)
+	scopes := applyHostlistScopes(prev, hosts, resp)
+	expected := map[string]string{
+		"a.com": shared.HostScopeASN,
+		"b.com": shared.HostScopeCountry,
+		"d.com": shared.HostScopeCountry,
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("expected %v, got %v", expected, scopes)
+	}
+
+	resp = shared.UpdateHostlistResponse{
+		Hosts:  []string{"x.com", "y.com"},
+		Scopes: map[string]string{"x.com": shared.HostScopeASN},
+	}
+	scopes = applyHostlistScopes(expected, applyHostlistResponse(hosts, resp), resp)
+	if !reflect.DeepEqual(scopes, map[string]string{"x.com": shared.HostScopeASN}) {
+		t.Errorf("unexpected scopes after full update: %v", scopes)
+	}
+}
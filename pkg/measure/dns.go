@@ -1,7 +1,13 @@
 package measure
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -12,10 +18,19 @@ import (
 	"github.com/miekg/dns"
 )
 
+// DNS resolver transports.
+const (
+	DNSTransportUDP   = "udp"   // plain DNS over UDP with TCP fallback on truncated responses
+	DNSTransportTCP   = "tcp"   // plain DNS over TCP
+	DNSTransportTLS   = "tls"   // DNS over TLS
+	DNSTransportHTTPS = "https" // DNS over HTTPS, Resolver is the query URL
+)
+
 type DNSQuery struct {
-	Hostname string        `json:"hostname"` // Hostnames to test against
-	Resolver string        `json:"resolver"` // Resolver to use, an empty string means to use the default system resolver.
-	Timeout  time.Duration `json:"-"`        // Measurement timeout, defaults to 45 seconds unless specified
+	Hostname  string        `json:"hostname"`  // Hostnames to test against
+	Resolver  string        `json:"resolver"`  // Resolver to use, an empty string means to use the default system resolver.
+	Transport string        `json:"transport"` // One of the DNSTransport constants, defaults to udp. Ignored for the system resolver.
+	Timeout   time.Duration `json:"-"`         // Measurement timeout, defaults to 45 seconds unless specified
 }
 
 func (d DNSQueryResult) Type() sampletypes.SampleType {
@@ -33,34 +48,180 @@ func (d DNSQueryResult) Host() string {
 // DNSQueryResult .
 type DNSQueryResult struct {
 	// Resolver net.IP
-	Addrs    []string `json:"addrs"`
-	Error    string   `json:"error"`
-	Hostname string   `json:"hostname"`
-	Resolver string   `json:"resolver"`
+	Addrs       []string    `json:"addrs"` // A and AAAA addresses
+	Error       string      `json:"error"`
+	Hostname    string      `json:"hostname"`
+	Resolver    string      `json:"resolver"`
+	Transport   string      `json:"transport,omitempty"`
+	Rcode       string      `json:"rcode,omitempty"`        // Response code of the A query, empty for the system resolver
+	CNAMEs      []string    `json:"cnames,omitempty"`       // CNAME chain in resolution order
+	Answers     []DNSAnswer `json:"answers,omitempty"`      // All A, AAAA and CNAME answer records
+	TCPFallback bool        `json:"tcp_fallback,omitempty"` // true if a truncated UDP response was retried over TCP
+}
+
+// DNSAnswer is a single answer resource record.
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// Encrypted returns true if the query was sent over an encrypted transport.
+func (d DNSQueryResult) Encrypted() bool {
+	return d.Transport == DNSTransportTLS || d.Transport == DNSTransportHTTPS
+}
+
+// lookupSystem resolves hostname using the built in resolver config.
+func lookupSystem(hostname string, qr *DNSQueryResult) error {
+	addrs, err := net.LookupHost(hostname)
+	if err != nil {
+		return err
+	}
+	qr.Addrs = addrs
+	if cname, err := net.LookupCNAME(hostname); err == nil {
+		if strings.TrimSuffix(cname, ".") != strings.TrimSuffix(hostname, ".") {
+			qr.CNAMEs = []string{cname}
+		}
+	}
+	return nil
+}
+
+func (d DNSQuery) transport() string {
+	if d.Transport == "" {
+		return DNSTransportUDP
+	}
+	return d.Transport
+}
+
+// exchange sends m to the resolver using the configured transport, returns
+// true if a truncated UDP response was retried over TCP.
+func (d DNSQuery) exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, bool, error) {
+	switch d.transport() {
+	case DNSTransportHTTPS:
+		r, err := exchangeHTTPS(m, d.Resolver, timeout)
+		return r, false, err
+	case DNSTransportTLS:
+		host, _, err := net.SplitHostPort(d.Resolver)
+		if err != nil {
+			return nil, false, err
+		}
+		c := &dns.Client{
+			Net:       "tcp-tls",
+			Timeout:   timeout,
+			TLSConfig: &tls.Config{ServerName: host},
+		}
+		r, _, err := c.Exchange(m, d.Resolver)
+		return r, false, err
+	case DNSTransportTCP:
+		c := &dns.Client{Net: "tcp", Timeout: timeout}
+		r, _, err := c.Exchange(m, d.Resolver)
+		return r, false, err
+	case DNSTransportUDP:
+		c := &dns.Client{Timeout: timeout}
+		r, _, err := c.Exchange(m, d.Resolver)
+		truncated := err == dns.ErrTruncated || (err == nil && r.Truncated)
+		if !truncated {
+			return r, false, err
+		}
+		c.Net = "tcp"
+		r, _, err = c.Exchange(m, d.Resolver)
+		return r, true, err
+	}
+	return nil, false, fmt.Errorf("unknown dns transport: %s", d.Transport)
 }
 
-func lookup(lookupaddr, nameserveraddr string) ([]string, error) {
+// dnsHTTPTransport is used for DNS over HTTPS requests.
+var dnsHTTPTransport http.RoundTripper = http.DefaultTransport
 
-	// if no nameserver addr is supplied, use the built in resolver config
-	if nameserveraddr == "" {
-		return net.LookupHost(lookupaddr)
+// exchangeHTTPS sends m as a RFC 8484 DNS over HTTPS POST request.
+func exchangeHTTPS(m *dns.Msg, resolverURL string, timeout time.Duration) (*dns.Msg, error) {
+	u, err := url.Parse(resolverURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("dns over https resolver must be a https url: %s", resolverURL)
+	}
+	m.Id = 0
+	data, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := http.Client{Timeout: timeout, Transport: dnsHTTPTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	var results []string
-	if !strings.HasSuffix(lookupaddr, ".") {
-		lookupaddr += "."
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns over https: unexpected status %s", resp.Status)
 	}
-	m := new(dns.Msg)
-	m.SetQuestion(lookupaddr, dns.TypeA)
-	ret, err := dns.Exchange(m, nameserveraddr)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	for _, a := range ret.Answer {
-		if t, ok := a.(*dns.A); ok {
-			results = append(results, t.A.String())
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lookup queries the resolver for A and AAAA records. A failed AAAA query is
+// not considered an error since many resolvers and networks lacks IPv6
+// support.
+func (d DNSQuery) lookup(qr *DNSQueryResult, timeout time.Duration) error {
+	name := d.Hostname
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		ret, fallback, err := d.exchange(m, timeout)
+		if err != nil {
+			if qtype == dns.TypeA {
+				return err
+			}
+			continue
+		}
+		qr.TCPFallback = qr.TCPFallback || fallback
+		if qtype == dns.TypeA {
+			qr.Rcode = dns.RcodeToString[ret.Rcode]
+		}
+		for _, rr := range ret.Answer {
+			h := rr.Header()
+			answer := DNSAnswer{
+				Name: h.Name,
+				Type: dns.TypeToString[h.Rrtype],
+				TTL:  h.Ttl,
+			}
+			switch t := rr.(type) {
+			case *dns.A:
+				answer.Data = t.A.String()
+				qr.Addrs = append(qr.Addrs, answer.Data)
+			case *dns.AAAA:
+				answer.Data = t.AAAA.String()
+				qr.Addrs = append(qr.Addrs, answer.Data)
+			case *dns.CNAME:
+				answer.Data = t.Target
+				if qtype == dns.TypeA {
+					qr.CNAMEs = append(qr.CNAMEs, t.Target)
+				}
+			default:
+				continue
+			}
+			qr.Answers = append(qr.Answers, answer)
 		}
 	}
-	return results, nil
+	return nil
 }
 
 func (d DNSQuery) Measure() (Measurement, error) {
@@ -73,19 +234,27 @@ func (d DNSQuery) Measure() (Measurement, error) {
 		Addrs:    []string{},
 		Resolver: d.Resolver,
 	}
-	lookupresult := make(chan []string, 0)
-	lookuperror := make(chan error, 0)
-	go func(d DNSQuery) {
-		addrs, err := lookup(d.Hostname, d.Resolver)
+	if d.Resolver != "" {
+		qr.Transport = d.transport()
+	}
+	lookupresult := make(chan DNSQueryResult, 1)
+	lookuperror := make(chan error, 1)
+	go func(d DNSQuery, qr DNSQueryResult) {
+		var err error
+		if d.Resolver == "" {
+			err = lookupSystem(d.Hostname, &qr)
+		} else {
+			err = d.lookup(&qr, timeout)
+		}
 		if err != nil {
 			lookuperror <- err
 			return
 		}
-		lookupresult <- addrs
-	}(d)
+		lookupresult <- qr
+	}(d, qr)
 	select {
 	case lr := <-lookupresult:
-		qr.Addrs = lr
+		qr = lr
 	case err := <-lookuperror:
 		qr.Error = err.Error()
 	case <-time.After(timeout):
@@ -0,0 +1,134 @@
+package measure
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
+	"github.com/miekg/dns"
+)
+
+// DefaultDNSInjectionTarget is a TEST-NET-1 address which never runs a DNS
+// server.
+const DefaultDNSInjectionTarget = "192.0.2.1:53"
+
+// DNSInjection sends a DNS query to an address which does not run a DNS
+// server. Any answer that comes back has been injected by the network.
+type DNSInjection struct {
+	Hostname string        `json:"hostname"` // Hostname to query for
+	Target   string        `json:"target"`   // Address without a DNS server, defaults to DefaultDNSInjectionTarget
+	Timeout  time.Duration `json:"-"`        // Time to wait for injected answers, defaults to 5 seconds unless specified
+}
+
+// DNSInjectionResult .
+type DNSInjectionResult struct {
+	Hostname  string      `json:"hostname"`
+	Target    string      `json:"target"`
+	Injected  bool        `json:"injected"`  // true if any answer was received
+	Responses int         `json:"responses"` // number of answers received, injectors sometimes send more than one
+	Addrs     []string    `json:"addrs"`     // injected A records
+	Answers   []DNSAnswer `json:"answers,omitempty"`
+	Error     string      `json:"error"`
+}
+
+func (d DNSInjectionResult) Type() sampletypes.SampleType {
+	return sampletypes.DNSInjection
+}
+
+func (d DNSInjectionResult) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d DNSInjectionResult) Host() string {
+	return d.Hostname
+}
+
+func (d DNSInjection) Measure() (Measurement, error) {
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	target := d.Target
+	if target == "" {
+		target = DefaultDNSInjectionTarget
+	}
+	result := DNSInjectionResult{
+		Hostname: d.Hostname,
+		Target:   target,
+		Addrs:    []string{},
+	}
+
+	name := d.Hostname
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	query, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	if _, err := conn.Write(query); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
+	// Keep reading until the deadline since an injector may send several
+	// answers and the real response, if any, must not hide them.
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			if classifyError(err) == ErrorClassRefused {
+				// ICMP port unreachable from the target host itself.
+				continue
+			}
+			result.Error = err.Error()
+			break
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		if r.Id != m.Id || len(r.Question) == 0 || !strings.EqualFold(r.Question[0].Name, name) {
+			continue
+		}
+		result.Injected = true
+		result.Responses++
+		for _, rr := range r.Answer {
+			h := rr.Header()
+			answer := DNSAnswer{
+				Name: h.Name,
+				Type: dns.TypeToString[h.Rrtype],
+				TTL:  h.Ttl,
+			}
+			switch t := rr.(type) {
+			case *dns.A:
+				answer.Data = t.A.String()
+				result.Addrs = append(result.Addrs, answer.Data)
+			case *dns.CNAME:
+				answer.Data = t.Target
+			default:
+				continue
+			}
+			result.Answers = append(result.Answers, answer)
+		}
+	}
+	return result, nil
+}
@@ -3,9 +3,13 @@ package measure
 import (
 	"encoding/json"
 	"errors"
+	"html"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,8 +19,9 @@ import (
 
 // HTTPHeader .
 type HTTPHeader struct {
-	URL     string        `json:"url"` // The url to mesaure against
-	Timeout time.Duration `json:"-"`   // Measurement timeout, defaults to 45 seconds unless specified.
+	URL         string        `json:"url"`          // The url to mesaure against
+	CaptureBody bool          `json:"capture_body"` // If true, a fingerprint and a prefix of the response body is recorded.
+	Timeout     time.Duration `json:"-"`            // Measurement timeout, defaults to 45 seconds unless specified.
 }
 
 // HTTPHeaderResult .
@@ -26,6 +31,54 @@ type HTTPHeaderResult struct {
 	Redirects      []Redirect        `json:"redirects,omitempty"`
 	Error          string            `json:"error"`
 	StatusCode     int               `json:"status_code"`
+	Body           *HTTPBody         `json:"body,omitempty"`
+}
+
+const (
+	maxBodyCapture   = 1024 * 1024 // max number of body bytes read when capturing
+	bodyPrefixLength = 1024        // max length of HTTPBody.Prefix
+	maxTitleLength   = 256         // max length of HTTPBody.Title
+)
+
+// HTTPBody is recorded when HTTPHeader.CaptureBody is enabled.
+type HTTPBody struct {
+	Length    int     `json:"length"`    // number of bytes read
+	Truncated bool    `json:"truncated"` // true if the body was larger than maxBodyCapture
+	SimHash   SimHash `json:"simhash"`   // fingerprint of the body text
+	Title     string  `json:"title"`     // content of the html title element
+	Prefix    string  `json:"prefix"`    // the start of the body
+}
+
+var htmlTitleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+func newHTTPBody(r io.Reader) (*HTTPBody, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxBodyCapture+1))
+	if err != nil {
+		return nil, err
+	}
+	body := &HTTPBody{}
+	if len(data) > maxBodyCapture {
+		data = data[:maxBodyCapture]
+		body.Truncated = true
+	}
+	body.Length = len(data)
+	doc := strings.ToValidUTF8(string(data), "")
+	body.SimHash = NewSimHash(doc)
+	if m := htmlTitleRe.FindStringSubmatch(doc); m != nil {
+		body.Title = truncateUTF8(
+			strings.Join(strings.Fields(html.UnescapeString(m[1])), " "),
+			maxTitleLength)
+	}
+	body.Prefix = truncateUTF8(doc, bodyPrefixLength)
+	return body, nil
+}
+
+// truncateUTF8 returns at most n bytes of s without splitting any runes.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
 }
 
 // Redirect is recorded on HTTP redirects.
@@ -76,6 +129,13 @@ func (h HTTPHeader) Measure() (Measurement, error) {
 		for k := range resp.Header {
 			respHeaders[k] = resp.Header.Get(k)
 		}
+		var body *HTTPBody
+		if h.CaptureBody {
+			body, err = newHTTPBody(resp.Body)
+			if err != nil {
+				lg.Warningf("could not read body of %s: %v", h.URL, err)
+			}
+		}
 		if err := resp.Body.Close(); err != nil {
 			lg.Errorln(err)
 		}
@@ -84,6 +144,7 @@ func (h HTTPHeader) Measure() (Measurement, error) {
 			ResponseHeader: respHeaders,
 			StatusCode:     resp.StatusCode,
 			Redirects:      rr.Redirects,
+			Body:           body,
 		}
 	}()
 	select {
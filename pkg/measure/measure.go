@@ -4,9 +4,11 @@ package measure
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strings"
+	"syscall"
 
 	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
 	"github.com/alkasir/alkasir/pkg/shared"
@@ -50,10 +52,15 @@ func DefaultMeasurements(URL string) ([]Measurer, error) {
 		host, _, err = net.SplitHostPort(u.Host)
 	}
 
-	for _, resolver := range []string{"", "8.8.8.8:53"} {
+	for _, resolver := range []struct{ addr, transport string }{
+		{"", ""},
+		{"8.8.8.8:53", DNSTransportUDP},
+		{"https://8.8.8.8/dns-query", DNSTransportHTTPS},
+	} {
 		dnsm := DNSQuery{
-			Hostname: host,
-			Resolver: resolver,
+			Hostname:  host,
+			Resolver:  resolver.addr,
+			Transport: resolver.transport,
 		}
 		result = append(result, dnsm)
 	}
@@ -63,5 +70,99 @@ func DefaultMeasurements(URL string) ([]Measurer, error) {
 	}
 	result = append(result, httphm)
 
+	tlsm := TLSHandshake{
+		Hostname: host,
+	}
+	result = append(result, tlsm)
+
+	tcpm := TCPConnect{
+		Hostname: host,
+	}
+	result = append(result, tcpm)
+
+	injm := DNSInjection{
+		Hostname: host,
+	}
+	result = append(result, injm)
+
 	return result, nil
 }
+
+// FollowUpMeasurements returns measurers which locates the blocking for
+// failed results.
+func FollowUpMeasurements(results []Measurement) []Measurer {
+	var result []Measurer
+	for _, v := range results {
+		if h, ok := v.(HTTPHeaderResult); ok && (h.Error != "" || h.StatusCode >= 400) {
+			result = append(result, TTLProbe{
+				URL: h.URL,
+			})
+		}
+	}
+	return result
+}
+
+// EnableBodyCapture turns on response body capture for all HTTPHeader
+// measurers.
+func EnableBodyCapture(measurers []Measurer) {
+	for i, v := range measurers {
+		if h, ok := v.(HTTPHeader); ok {
+			h.CaptureBody = true
+			measurers[i] = h
+		}
+	}
+}
+
+// Error classes recorded by measurements which establish network connections.
+const (
+	ErrorClassReset       = "reset"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassEOF         = "eof"
+	ErrorClassRefused     = "refused"
+	ErrorClassUnreachable = "unreachable"
+	ErrorClassNoRoute     = "noroute" // the measuring host has no route to the network
+	ErrorClassOther       = "other"
+)
+
+// classifyError returns a coarse classification of a network error which
+// makes it possible to tell injected resets and silently dropped packets
+// apart from other failures.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassEOF
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return ErrorClassReset
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorClassRefused
+	}
+	if errors.Is(err, syscall.ENETUNREACH) {
+		return ErrorClassNoRoute
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) {
+		return ErrorClassUnreachable
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrorClassTimeout
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection reset"):
+		return ErrorClassReset
+	case strings.Contains(msg, "connection refused"):
+		return ErrorClassRefused
+	case strings.Contains(msg, "network is unreachable"):
+		return ErrorClassNoRoute
+	case strings.Contains(msg, "unreachable"), strings.Contains(msg, "no route to host"):
+		return ErrorClassUnreachable
+	case strings.Contains(msg, "timeout"):
+		return ErrorClassTimeout
+	case strings.HasSuffix(msg, "EOF"):
+		return ErrorClassEOF
+	}
+	return ErrorClassOther
+}
@@ -1,12 +1,17 @@
 package measure
 
 import (
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestDNSMeasurer(t *testing.T) {
@@ -76,3 +81,413 @@ func TestHTTPHeaderTimeout(t *testing.T) {
 		t.Fatal("expected httpheaderresult")
 	}
 }
+
+func TestTLSHandshake(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	th := TLSHandshake{
+		Hostname: "example.com",
+		Addr:     ts.Listener.Addr().String(),
+		Timeout:  5 * time.Second,
+	}
+	r, err := th.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(TLSHandshakeResult)
+	if d.Error != "" {
+		t.Fatalf("unexpected error %s", d.Error)
+	}
+	if d.ServerName != "example.com" || d.Version == "" || d.CipherSuite == "" {
+		t.Fatalf("incomplete result %+v", d)
+	}
+	if len(d.Certificates) == 0 || len(d.Certificates[0].Fingerprint) != 64 {
+		t.Fatalf("expected certificate fingerprints, got %+v", d.Certificates)
+	}
+	// the httptest certificate is not signed by a system root
+	if d.VerifyError == "" {
+		t.Fatal("expected verify error")
+	}
+}
+
+func TestTLSHandshakeEOF(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	th := TLSHandshake{
+		Hostname: "example.com",
+		Addr:     ln.Addr().String(),
+		Timeout:  5 * time.Second,
+	}
+	r, err := th.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(TLSHandshakeResult)
+	if d.ErrorClass != ErrorClassEOF && d.ErrorClass != ErrorClassReset {
+		t.Fatalf("expected eof or reset error class, got %s (%s)", d.ErrorClass, d.Error)
+	}
+}
+
+func TestTCPConnect(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	tc := TCPConnect{
+		Addrs:   []string{"127.0.0.1"},
+		Ports:   []int{ln.Addr().(*net.TCPAddr).Port, port},
+		Timeout: 5 * time.Second,
+	}
+	r, err := tc.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(TCPConnectResult)
+	if len(d.Connects) != 2 {
+		t.Fatalf("expected 2 connection attempts, got %d", len(d.Connects))
+	}
+	if !d.Connects[0].Success {
+		t.Errorf("expected successful connect, got %s", d.Connects[0].Error)
+	}
+	if d.Connects[1].Success || d.Connects[1].ErrorClass != ErrorClassRefused {
+		t.Errorf("expected refused connect, got %+v", d.Connects[1])
+	}
+}
+
+func TestHTTPHeaderCaptureBody(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><title>Blocked</title><body>This site is blocked</body></html>"))
+	}))
+	defer ts.Close()
+	for _, capture := range []bool{false, true} {
+		ht := HTTPHeader{
+			URL:         ts.URL,
+			CaptureBody: capture,
+		}
+		r, err := ht.Measure()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := r.(HTTPHeaderResult)
+		if !capture {
+			if d.Body != nil {
+				t.Error("expected no body without capture")
+			}
+			continue
+		}
+		if d.Body == nil || d.Body.Title != "Blocked" || d.Body.SimHash == 0 {
+			t.Errorf("unexpected body %+v", d.Body)
+		}
+	}
+}
+
+// newTestDNSHandler returns a handler which answers example.com with a CNAME
+// chain and A/AAAA records. UDP responses are truncated if truncate is set.
+func newTestDNSHandler(truncate bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if req.Question[0].Name != "example.com." {
+			m.Rcode = dns.RcodeNameError
+			w.WriteMsg(m)
+			return
+		}
+		if truncate && w.LocalAddr().Network() == "udp" {
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		cname, _ := dns.NewRR("example.com. 300 IN CNAME www.example.net.")
+		m.Answer = append(m.Answer, cname)
+		switch req.Question[0].Qtype {
+		case dns.TypeA:
+			rr, _ := dns.NewRR("www.example.net. 60 IN A 93.184.216.34")
+			m.Answer = append(m.Answer, rr)
+		case dns.TypeAAAA:
+			rr, _ := dns.NewRR("www.example.net. 60 IN AAAA 2606:2800:220:1:248:1893:25c8:1946")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func startTestDNSServer(t *testing.T, handler dns.Handler) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skip("could not listen on tcp and udp on the same port", err)
+	}
+	udp := &dns.Server{PacketConn: pc, Handler: handler}
+	tcp := &dns.Server{Listener: ln, Handler: handler}
+	go udp.ActivateAndServe()
+	go tcp.ActivateAndServe()
+	return pc.LocalAddr().String(), func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	}
+}
+
+func TestDNSQueryRecords(t *testing.T) {
+	addr, stop := startTestDNSServer(t, newTestDNSHandler(true))
+	defer stop()
+	dq := DNSQuery{
+		Hostname: "example.com",
+		Resolver: addr,
+		Timeout:  5 * time.Second,
+	}
+	r, err := dq.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(DNSQueryResult)
+	if d.Error != "" {
+		t.Fatal(d.Error)
+	}
+	if !d.TCPFallback || d.Transport != DNSTransportUDP || d.Rcode != "NOERROR" {
+		t.Errorf("unexpected result %+v", d)
+	}
+	if len(d.Addrs) != 2 || d.Addrs[0] != "93.184.216.34" || d.Addrs[1] != "2606:2800:220:1:248:1893:25c8:1946" {
+		t.Errorf("unexpected addrs %v", d.Addrs)
+	}
+	if len(d.CNAMEs) != 1 || d.CNAMEs[0] != "www.example.net." {
+		t.Errorf("unexpected cnames %v", d.CNAMEs)
+	}
+	for _, a := range d.Answers {
+		if (a.Type == "CNAME" && a.TTL != 300) || (a.Type != "CNAME" && a.TTL != 60) {
+			t.Errorf("unexpected ttl %+v", a)
+		}
+	}
+
+	dq.Hostname = "nonexisting.com"
+	r, err = dq.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.(DNSQueryResult); d.Rcode != "NXDOMAIN" || len(d.Addrs) != 0 {
+		t.Errorf("expected NXDOMAIN, got %+v", d)
+	}
+}
+
+func TestDNSQueryHTTPS(t *testing.T) {
+	handler := newTestDNSHandler(false)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rw := &testDNSResponseWriter{}
+		handler(rw, req)
+		data, _ := rw.msg.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(data)
+	}))
+	defer ts.Close()
+	prev := dnsHTTPTransport
+	dnsHTTPTransport = ts.Client().Transport
+	defer func() { dnsHTTPTransport = prev }()
+
+	dq := DNSQuery{
+		Hostname:  "example.com",
+		Resolver:  ts.URL + "/dns-query",
+		Transport: DNSTransportHTTPS,
+		Timeout:   5 * time.Second,
+	}
+	r, err := dq.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(DNSQueryResult)
+	if d.Error != "" || len(d.Addrs) != 2 || !d.Encrypted() {
+		t.Errorf("unexpected result %+v", d)
+	}
+}
+
+type testDNSResponseWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *testDNSResponseWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (w *testDNSResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+// startFakeInjector listens on a loopback address and answers every query
+// with a forged A record, like an on-path injector would.
+func startFakeInjector(t *testing.T, answers int) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if answers == 0 {
+				continue
+			}
+			req := new(dns.Msg)
+			if err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			m := new(dns.Msg)
+			m.SetReply(req)
+			rr, _ := dns.NewRR(req.Question[0].Name + " 60 IN A 10.10.34.34")
+			m.Answer = append(m.Answer, rr)
+			data, _ := m.Pack()
+			for i := 0; i < answers; i++ {
+				pc.WriteTo(data, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String(), func() { pc.Close() }
+}
+
+func TestDNSInjection(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		answers  int
+		injected bool
+	}{
+		{0, false},
+		{2, true},
+	}
+	for _, tt := range tests {
+		addr, stop := startFakeInjector(t, tt.answers)
+		di := DNSInjection{
+			Hostname: "example.com",
+			Target:   addr,
+			Timeout:  200 * time.Millisecond,
+		}
+		r, err := di.Measure()
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := r.(DNSInjectionResult)
+		if d.Injected != tt.injected || d.Responses != tt.answers || d.Error != "" {
+			t.Errorf("unexpected result %+v", d)
+		}
+		if tt.injected && (len(d.Addrs) != tt.answers || d.Addrs[0] != "10.10.34.34") {
+			t.Errorf("unexpected injected addrs %v", d.Addrs)
+		}
+	}
+}
+
+func TestTTLProbe(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	tp := TTLProbe{
+		URL:        ts.URL,
+		MaxTTL:     3,
+		HopTimeout: 2 * time.Second,
+	}
+	r, err := tp.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := r.(TTLProbeResult)
+	// loopback is reached at the first hop
+	if d.Error != "" || d.EventHop != 1 || d.Event != TTLProbeResponse || d.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected result %+v", d)
+	}
+}
+
+func TestFollowUpMeasurements(t *testing.T) {
+	m := FollowUpMeasurements([]Measurement{
+		HTTPHeaderResult{URL: "http://a.com", StatusCode: 200},
+		HTTPHeaderResult{URL: "http://b.com", Error: "connection reset"},
+		DNSQueryResult{Hostname: "c.com"},
+	})
+	if len(m) != 1 || m[0].(TTLProbe).URL != "http://b.com" {
+		t.Errorf("unexpected follow up measurements %v", m)
+	}
+}
+
+func TestResolvedAddresses(t *testing.T) {
+	results := []Measurement{
+		DNSQueryResult{Hostname: "a.com", Addrs: []string{"1.1.1.1", "::1"}},
+		DNSQueryResult{Hostname: "a.com", Addrs: []string{"1.1.1.1", "2.2.2.2"}, Resolver: "8.8.8.8:53"},
+		DNSQueryResult{Hostname: "b.com", Addrs: []string{"3.3.3.3"}},
+		DNSQueryResult{Hostname: "c.com", Error: "no such host"},
+		HTTPHeaderResult{URL: "http://a.com"},
+	}
+	tc := ResolvedAddresses(TCPConnect{Hostname: "a.com"}, results).(TCPConnect)
+	if !reflect.DeepEqual(tc.Addrs, []string{"1.1.1.1", "::1", "2.2.2.2"}) {
+		t.Errorf("unexpected measurement %+v", tc)
+	}
+	tc = ResolvedAddresses(TCPConnect{Hostname: "a.com", Addrs: []string{"4.4.4.4"}}, results).(TCPConnect)
+	if !reflect.DeepEqual(tc.Addrs, []string{"4.4.4.4"}) {
+		t.Errorf("expected addresses to be kept, got %+v", tc)
+	}
+	if tc = ResolvedAddresses(TCPConnect{Hostname: "c.com"}, results).(TCPConnect); len(tc.Addrs) != 0 {
+		t.Errorf("unexpected addresses %+v", tc)
+	}
+	if m := ResolvedAddresses(HTTPHeader{URL: "http://a.com"}, results); m != (HTTPHeader{URL: "http://a.com"}) {
+		t.Errorf("unexpected measurer %+v", m)
+	}
+
+	r, err := TCPConnect{Hostname: "localhost", Ports: []int{1}, Timeout: time.Second}.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.(TCPConnectResult); d.Error != "" || len(d.Addrs) == 0 || len(d.Connects) != len(d.Addrs) {
+		t.Errorf("expected localhost to be resolved, got %+v", d)
+	}
+}
+
+func TestTimeExceededPort(t *testing.T) {
+	msg := make([]byte, 8+20+8)
+	msg[0] = 11
+	msg[8] = 0x45
+	msg[28], msg[29] = 0x9c, 0x40
+	if port, ok := timeExceededPort(msg, false); !ok || port != 40000 {
+		t.Errorf("expected port 40000, got %d", port)
+	}
+	msg[0] = 0
+	if _, ok := timeExceededPort(msg, false); ok {
+		t.Error("expected non time exceeded message to be ignored")
+	}
+}
@@ -2,6 +2,7 @@ package measure
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -65,3 +66,60 @@ func TestMarshalDNSQuery(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMarshalTLSHandshake(t *testing.T) {
+	h := TLSHandshakeResult{
+		Hostname:     "test",
+		Certificates: []TLSCertificate{{Fingerprint: "aa", Issuer: "CN=test"}},
+	}
+	b1, err := h.Marshal()
+	if err != nil {
+		t.Error(err)
+	}
+	var m Measurement = h
+	b2, err := m.Marshal()
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(b1, b2) {
+		t.Fail()
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	page := `<html><head><title>Access denied</title></head><body>
+<p>The requested site has been blocked by order of the ministry of information.</p></body></html>`
+	similar := `<html><head><title>Access denied</title></head><body>
+<p>The requested site has been blocked by order of the ministry of information!!</p><!-- node 2 --></body></html>`
+	other := `<html><head><title>Example Domain</title></head><body>
+<p>This domain is for use in illustrative examples in documents.</p></body></html>`
+	a, b, c := NewSimHash(page), NewSimHash(similar), NewSimHash(other)
+	if d := a.Distance(b); d > 3 {
+		t.Errorf("expected similar documents to be close, distance %d", d)
+	}
+	if d := a.Distance(c); d < 10 {
+		t.Errorf("expected different documents to be far apart, distance %d", d)
+	}
+	p, err := ParseSimHash(a.String())
+	if err != nil || p != a {
+		t.Errorf("could not round trip %s: %v", a, err)
+	}
+}
+
+func TestNewHTTPBody(t *testing.T) {
+	doc := "<html><head><title>\n  Blocked &amp; filtered\n</title></head><body>" +
+		strings.Repeat("x", 2*bodyPrefixLength) + "</body></html>"
+	body, err := newHTTPBody(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Title != "Blocked & filtered" {
+		t.Errorf("unexpected title %q", body.Title)
+	}
+	if body.Length != len(doc) || body.Truncated {
+		t.Errorf("unexpected length %d", body.Length)
+	}
+	if len(body.Prefix) != bodyPrefixLength {
+		t.Errorf("unexpected prefix length %d", len(body.Prefix))
+	}
+}
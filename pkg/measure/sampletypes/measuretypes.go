@@ -9,4 +9,8 @@ const (
 	BrowserExtension
 	NewClientToken
 	DNSQuery
+	TLSHandshake
+	TCPConnect
+	DNSInjection
+	TTLProbe
 )
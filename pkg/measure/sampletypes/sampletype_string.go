@@ -4,9 +4,9 @@ package sampletypes
 
 import "fmt"
 
-const _SampleType_name = "NoneHTTPHeaderBrowserExtensionNewClientTokenDNSQuery"
+const _SampleType_name = "NoneHTTPHeaderBrowserExtensionNewClientTokenDNSQueryTLSHandshakeTCPConnectDNSInjectionTTLProbe"
 
-var _SampleType_index = [...]uint8{0, 4, 14, 30, 44, 52}
+var _SampleType_index = [...]uint8{0, 4, 14, 30, 44, 52, 64, 74, 86, 94}
 
 func (i SampleType) String() string {
 	if i < 0 || i >= SampleType(len(_SampleType_index)-1) {
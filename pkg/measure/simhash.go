@@ -0,0 +1,94 @@
+package measure
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SimHash is a 64 bit locality sensitive fingerprint where similar documents
+// get fingerprints with a small hamming distance.
+type SimHash uint64
+
+// MarshalText encodes the fingerprint as a 16 character hex string since
+// JSON numbers can not hold 64 bit integers reliably.
+func (s SimHash) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *SimHash) UnmarshalText(text []byte) error {
+	v, err := ParseSimHash(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s SimHash) String() string {
+	return fmt.Sprintf("%016x", uint64(s))
+}
+
+// Distance returns the hamming distance between two fingerprints.
+func (s SimHash) Distance(other SimHash) int {
+	x := uint64(s ^ other)
+	n := 0
+	for x != 0 {
+		x &= x - 1
+		n++
+	}
+	return n
+}
+
+// ParseSimHash parses a hex encoded fingerprint.
+func ParseSimHash(s string) (SimHash, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return SimHash(v), nil
+}
+
+var (
+	htmlTagRe    = regexp.MustCompile(`(?s)<[^>]*>`)
+	nonWordRe    = regexp.MustCompile(`[^\pL\pN]+`)
+	simHashWidth = 3 // number of words per shingle
+)
+
+// NewSimHash calculates the fingerprint of the visible words of a HTML or
+// text document.
+func NewSimHash(doc string) SimHash {
+	text := htmlTagRe.ReplaceAllString(doc, " ")
+	words := strings.Fields(nonWordRe.ReplaceAllString(strings.ToLower(text), " "))
+	if len(words) == 0 {
+		return 0
+	}
+	var v [64]int
+	add := func(shingle string) {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		sum := h.Sum64()
+		for i := uint(0); i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				v[i]++
+			} else {
+				v[i]--
+			}
+		}
+	}
+	if len(words) < simHashWidth {
+		add(strings.Join(words, " "))
+	}
+	for i := 0; i+simHashWidth <= len(words); i++ {
+		add(strings.Join(words[i:i+simHashWidth], " "))
+	}
+	var result uint64
+	for i := uint(0); i < 64; i++ {
+		if v[i] > 0 {
+			result |= 1 << i
+		}
+	}
+	return SimHash(result)
+}
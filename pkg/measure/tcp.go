@@ -0,0 +1,132 @@
+package measure
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
+)
+
+// TCPConnect connects to the addresses of Hostname, see ResolvedAddresses.
+type TCPConnect struct {
+	Hostname string        `json:"hostname"` // Hostname to resolve using the system resolver
+	Addrs    []string      `json:"addrs"`    // Addresses to connect to, Hostname is resolved if empty
+	Ports    []int         `json:"ports"`    // Ports to connect to, defaults to 80 and 443
+	Timeout  time.Duration `json:"-"`        // Timeout per connection attempt, defaults to 15 seconds unless specified
+}
+
+// TCPConnectResult .
+type TCPConnectResult struct {
+	Hostname string              `json:"hostname"`
+	Addrs    []string            `json:"addrs"`
+	Connects []TCPConnectAttempt `json:"connects"`
+	Error    string              `json:"error"` // address lookup error
+}
+
+// TCPConnectAttempt is recorded for every address and port combination.
+type TCPConnectAttempt struct {
+	Addr       string        `json:"addr"`
+	Port       int           `json:"port"`
+	Success    bool          `json:"success"`
+	Latency    time.Duration `json:"latency"` // time until connected or failed
+	Error      string        `json:"error"`
+	ErrorClass string        `json:"error_class"` // one of the ErrorClass constants
+}
+
+func (t TCPConnectResult) Type() sampletypes.SampleType {
+	return sampletypes.TCPConnect
+}
+
+func (t TCPConnectResult) Marshal() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t TCPConnectResult) Host() string {
+	return t.Hostname
+}
+
+func (t TCPConnect) Measure() (Measurement, error) {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	ports := t.Ports
+	if len(ports) == 0 {
+		ports = []int{80, 443}
+	}
+	result := TCPConnectResult{
+		Hostname: t.Hostname,
+		Addrs:    t.Addrs,
+		Connects: []TCPConnectAttempt{},
+	}
+	if len(result.Addrs) == 0 {
+		addrs, err := net.LookupHost(t.Hostname)
+		if err != nil {
+			result.Addrs = []string{}
+			result.Error = err.Error()
+			return result, nil
+		}
+		result.Addrs = addrs
+	}
+
+	attempts := make([]TCPConnectAttempt, len(result.Addrs)*len(ports))
+	var wg sync.WaitGroup
+	for i, addr := range result.Addrs {
+		for j, port := range ports {
+			wg.Add(1)
+			go func(n int, addr string, port int) {
+				defer wg.Done()
+				attempts[n] = tcpConnect(addr, port, timeout)
+			}(i*len(ports)+j, addr, port)
+		}
+	}
+	wg.Wait()
+	result.Connects = attempts
+	return result, nil
+}
+
+// ResolvedAddresses returns m with the addresses which the DNSQuery results
+// resolved for its hostname if m is a TCPConnect without addresses. The
+// connections are then made to the addresses of the session's own DNS
+// measurements instead of resolving the hostname again.
+func ResolvedAddresses(m Measurer, results []Measurement) Measurer {
+	t, ok := m.(TCPConnect)
+	if !ok || len(t.Addrs) > 0 {
+		return m
+	}
+	seen := make(map[string]bool, 0)
+	for _, v := range results {
+		r, ok := v.(DNSQueryResult)
+		if !ok || r.Hostname != t.Hostname {
+			continue
+		}
+		for _, addr := range r.Addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				t.Addrs = append(t.Addrs, addr)
+			}
+		}
+	}
+	return t
+}
+
+func tcpConnect(addr string, port int, timeout time.Duration) TCPConnectAttempt {
+	attempt := TCPConnectAttempt{
+		Addr: addr,
+		Port: port,
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, strconv.Itoa(port)), timeout)
+	attempt.Latency = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorClass = classifyError(err)
+		return attempt
+	}
+	conn.Close()
+	attempt.Success = true
+	return attempt
+}
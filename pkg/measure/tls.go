@@ -0,0 +1,149 @@
+package measure
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
+)
+
+// TLSHandshake .
+type TLSHandshake struct {
+	Hostname string        `json:"hostname"` // Hostname to send as SNI
+	Addr     string        `json:"addr"`     // Address to connect to, defaults to Hostname:443
+	Timeout  time.Duration `json:"-"`        // Measurement timeout, defaults to 45 seconds unless specified
+}
+
+// TLSHandshakeResult .
+type TLSHandshakeResult struct {
+	Hostname      string           `json:"hostname"`
+	Addr          string           `json:"addr"`
+	ServerName    string           `json:"server_name"`  // SNI sent in the client hello
+	Version       string           `json:"version"`      // negotiated protocol version
+	CipherSuite   string           `json:"cipher_suite"` // negotiated cipher suite
+	Certificates  []TLSCertificate `json:"certificates"` // peer certificate chain, leaf first
+	VerifyError   string           `json:"verify_error"` // chain verification error, empty if the chain is valid for Hostname
+	Error         string           `json:"error"`
+	ErrorClass    string           `json:"error_class"`    // one of the ErrorClass constants
+	HandshakeTime time.Duration    `json:"handshake_time"` // time from connection established to handshake completed
+}
+
+// TLSCertificate describes a single certificate from a peer certificate
+// chain.
+type TLSCertificate struct {
+	Fingerprint string `json:"fingerprint"` // hex encoded SHA-256 of the DER encoded certificate
+	Subject     string `json:"subject"`
+	Issuer      string `json:"issuer"`
+}
+
+func (t TLSHandshakeResult) Type() sampletypes.SampleType {
+	return sampletypes.TLSHandshake
+}
+
+func (t TLSHandshakeResult) Marshal() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t TLSHandshakeResult) Host() string {
+	return t.Hostname
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+	tls.VersionTLS13: "TLS1.3",
+}
+
+func tlsVersionName(v uint16) string {
+	if name, ok := tlsVersions[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func (t TLSHandshake) Measure() (Measurement, error) {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 45 * time.Second
+	}
+	addr := t.Addr
+	if addr == "" {
+		addr = net.JoinHostPort(t.Hostname, "443")
+	}
+	result := TLSHandshakeResult{
+		Hostname:     t.Hostname,
+		Addr:         addr,
+		ServerName:   t.Hostname,
+		Certificates: []TLSCertificate{},
+	}
+	setError := func(err error) {
+		result.Error = err.Error()
+		result.ErrorClass = classifyError(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		setError(err)
+		return result, nil
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		setError(err)
+		return result, nil
+	}
+
+	// Verification is done separately after the handshake so that the
+	// certificates of a substituted chain are still recorded.
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         t.Hostname,
+		InsecureSkipVerify: true,
+	})
+	start := time.Now()
+	err = tlsConn.Handshake()
+	result.HandshakeTime = time.Since(start)
+	if err != nil {
+		setError(err)
+		return result, nil
+	}
+
+	state := tlsConn.ConnectionState()
+	result.Version = tlsVersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	for _, c := range state.PeerCertificates {
+		sum := sha256.Sum256(c.Raw)
+		result.Certificates = append(result.Certificates, TLSCertificate{
+			Fingerprint: hex.EncodeToString(sum[:]),
+			Subject:     c.Subject.String(),
+			Issuer:      c.Issuer.String(),
+		})
+	}
+	if err := verifyPeerCertificates(t.Hostname, state.PeerCertificates); err != nil {
+		result.VerifyError = err.Error()
+	}
+	return result, nil
+}
+
+// verifyPeerCertificates verifies a peer chain against the system roots.
+func verifyPeerCertificates(hostname string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificates")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: intermediates,
+	})
+	return err
+}
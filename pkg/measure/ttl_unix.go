@@ -0,0 +1,29 @@
+// +build !windows
+
+package measure
+
+import (
+	"net"
+	"syscall"
+)
+
+// setTTL sets the IP TTL, or the hop limit for IPv6, of outgoing packets on
+// conn.
+func setTTL(conn *net.TCPConn, ttl int, ipv6 bool) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
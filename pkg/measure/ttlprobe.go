@@ -0,0 +1,293 @@
+package measure
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alkasir/alkasir/pkg/measure/sampletypes"
+	"github.com/thomasf/lg"
+)
+
+// TTL probe events.
+const (
+	TTLProbeNone     = ""         // nothing came back before the hop timeout
+	TTLProbeResponse = "response" // a HTTP response or TLS server hello came back
+	TTLProbeReset    = "reset"    // the connection was reset
+	TTLProbeEOF      = "eof"      // the connection was closed
+)
+
+// TTLProbe repeats a HTTP request, or a TLS handshake for https URLs, with
+// increasing IP TTLs to find the hop at which a reset or a response first
+// appears. A response at a hop closer than the server means that the
+// request was answered by something on the path.
+type TTLProbe struct {
+	URL        string        `json:"url"`
+	MaxTTL     int           `json:"max_ttl"` // defaults to 30
+	HopTimeout time.Duration `json:"-"`       // time to wait for an answer per hop, defaults to 3 seconds unless specified
+}
+
+// TTLProbeResult .
+type TTLProbeResult struct {
+	URL        string        `json:"url"`
+	Hops       []TTLProbeHop `json:"hops"`
+	EventHop   int           `json:"event_hop"`           // the hop at which Event first appeared, 0 if nothing appeared
+	Event      string        `json:"event"`               // one of the TTLProbe events
+	StatusCode int           `json:"status_code"`         // HTTP status at EventHop if Event is a response
+	EventASN   int           `json:"event_asn,omitempty"` // ASN of the last router before EventHop, resolved by central
+	Error      string        `json:"error"`
+}
+
+// TTLProbeHop is recorded for each TTL.
+type TTLProbeHop struct {
+	TTL   int    `json:"ttl"`
+	Event string `json:"event"`
+	// Address of the router which reported that the TTL expired, only
+	// available if ICMP can be received. It is sent to central which
+	// replaces it with RouterASN before the sample is stored.
+	RouterAddr string `json:"router_addr,omitempty"`
+	RouterASN  int    `json:"router_asn,omitempty"`
+}
+
+func (t TTLProbeResult) Type() sampletypes.SampleType {
+	return sampletypes.TTLProbe
+}
+
+func (t TTLProbeResult) Marshal() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t TTLProbeResult) Host() string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return ""
+	}
+	host := u.Host
+	if strings.Contains(host, ":") {
+		host, _, _ = net.SplitHostPort(u.Host)
+	}
+	return host
+}
+
+// icmpRouters collects the source address of ICMP time exceeded messages by
+// the local TCP port of the expired packet.
+type icmpRouters struct {
+	sync.Mutex
+	conn    net.PacketConn
+	routers map[int]string
+}
+
+// listenICMP starts collecting ICMP time exceeded messages. Receiving ICMP
+// requires elevated privileges so failure is expected and not an error.
+func listenICMP(ipv6 bool) *icmpRouters {
+	network := "ip4:icmp"
+	if ipv6 {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		lg.V(10).Infof("icmp not available: %v", err)
+		return nil
+	}
+	r := &icmpRouters{
+		conn:    conn,
+		routers: make(map[int]string, 0),
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			port, ok := timeExceededPort(buf[:n], ipv6)
+			if !ok {
+				continue
+			}
+			r.Lock()
+			r.routers[port] = addr.String()
+			r.Unlock()
+		}
+	}()
+	return r
+}
+
+func (r *icmpRouters) Get(port int) string {
+	if r == nil {
+		return ""
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.routers[port]
+}
+
+func (r *icmpRouters) Close() {
+	if r != nil {
+		r.conn.Close()
+	}
+}
+
+// timeExceededPort returns the TCP source port of the packet quoted in an
+// ICMP time exceeded message.
+func timeExceededPort(msg []byte, ipv6 bool) (int, bool) {
+	const icmpHeaderLen = 8
+	if len(msg) < icmpHeaderLen {
+		return 0, false
+	}
+	var ipHeaderLen int
+	if ipv6 {
+		if msg[0] != 3 { // ICMPv6 time exceeded
+			return 0, false
+		}
+		ipHeaderLen = 40
+	} else {
+		if msg[0] != 11 || len(msg) < icmpHeaderLen+20 { // ICMP time exceeded
+			return 0, false
+		}
+		ipHeaderLen = int(msg[icmpHeaderLen]&0x0f) * 4
+	}
+	offset := icmpHeaderLen + ipHeaderLen
+	if len(msg) < offset+2 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(msg[offset : offset+2])), true
+}
+
+func (t TTLProbe) Measure() (Measurement, error) {
+	maxTTL := t.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = 30
+	}
+	hopTimeout := t.HopTimeout
+	if hopTimeout == 0 {
+		hopTimeout = 3 * time.Second
+	}
+	result := TTLProbeResult{
+		URL:  t.URL,
+		Hops: []TTLProbeHop{},
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, err
+	}
+	hostname, port := u.Host, ""
+	if strings.Contains(u.Host, ":") {
+		hostname, port, err = net.SplitHostPort(u.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addrs, err := net.LookupHost(hostname)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	ip := net.ParseIP(addrs[0])
+	if ip == nil {
+		result.Error = fmt.Sprintf("invalid address: %s", addrs[0])
+		return result, nil
+	}
+	ipv6 := ip.To4() == nil
+	routers := listenICMP(ipv6)
+	defer routers.Close()
+
+	for ttl := 1; ttl <= maxTTL; ttl++ {
+		hop, statusCode, localPort, err := probeHop(u, hostname, net.JoinHostPort(addrs[0], port), ttl, ipv6, hopTimeout)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		hop.RouterAddr = routers.Get(localPort)
+		result.Hops = append(result.Hops, hop)
+		if hop.Event != TTLProbeNone {
+			result.EventHop = ttl
+			result.Event = hop.Event
+			result.StatusCode = statusCode
+			break
+		}
+	}
+	return result, nil
+}
+
+// probeHop connects to addr and sends a request in packets limited to ttl
+// hops.
+func probeHop(u *url.URL, hostname, addr string, ttl int, ipv6 bool, timeout time.Duration) (TTLProbeHop, int, int, error) {
+	hop := TTLProbeHop{TTL: ttl}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return hop, 0, 0, err
+	}
+	defer conn.Close()
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return hop, 0, 0, errors.New("not a tcp connection")
+	}
+	localPort := tcpConn.LocalAddr().(*net.TCPAddr).Port
+	if err := setTTL(tcpConn, ttl, ipv6); err != nil {
+		return hop, 0, localPort, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return hop, 0, localPort, err
+	}
+
+	var statusCode int
+	if u.Scheme == "https" {
+		err = tls.Client(conn, &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+		}).Handshake()
+	} else {
+		statusCode, err = probeHTTP(conn, u)
+	}
+	switch classifyError(err) {
+	case "":
+		hop.Event = TTLProbeResponse
+	case ErrorClassReset:
+		hop.Event = TTLProbeReset
+	case ErrorClassEOF:
+		hop.Event = TTLProbeEOF
+	case ErrorClassTimeout:
+		hop.Event = TTLProbeNone
+	default:
+		// Any other error from the far end, such as a TLS alert, is
+		// still an answer.
+		if _, ok := err.(net.Error); ok {
+			return hop, 0, localPort, err
+		}
+		hop.Event = TTLProbeResponse
+	}
+	return hop, statusCode, localPort, nil
+}
+
+// probeHTTP writes a GET request for u and reads the response status.
+func probeHTTP(conn net.Conn, u *url.URL) (int, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Connection", "close")
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
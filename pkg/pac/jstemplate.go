@@ -22,6 +22,11 @@ var topLevel = {
     {{.TopLevel}}
 };
 
+var rules = {
+    direct: {{.DirectRules}},
+    blocked: {{.BlockedRules}}
+};
+
 var directAcc = {};
 for (var i = 0; i < hosts.direct.length; i += 1) {
     directAcc[hosts.direct[i]] = true;
@@ -93,18 +98,72 @@ function host2Domain(host) {
     return host.substring(dot2ndLast+1);
 }
 
+// ip2Number converts an IPv4 address to an unsigned 32 bit number.
+function ip2Number(ip) {
+    var part = ip.split('.');
+    return ((Number(part[0]) << 24) | (Number(part[1]) << 16) |
+            (Number(part[2]) << 8) | Number(part[3])) >>> 0;
+}
+
+// urlPath returns the path part of an url, browsers strips the path from
+// https urls so path rules only works for plain http.
+function urlPath(url) {
+    var start = url.indexOf("://");
+    start = start === -1 ? 0 : start + 3;
+    var idx = url.indexOf("/", start);
+    if (idx === -1) {
+        return "/";
+    }
+    return url.substring(idx);
+}
+
+function ruleMatch(rule, url, host, isIP) {
+    if (rule.net) {
+        return isIP && ((ip2Number(host) & rule.net[1]) >>> 0) === rule.net[0];
+    }
+    if (host !== rule.host) {
+        var suffix = "." + rule.host;
+        if (!rule.wildcard || host.length <= suffix.length ||
+            host.substring(host.length - suffix.length) !== suffix) {
+            return false;
+        }
+    }
+    if (rule.path) {
+        return urlPath(url).indexOf(rule.path) === 0;
+    }
+    return true;
+}
+
+// matchRules returns 1 if any rule matches, -1 if an exclusion matches and 0
+// if nothing matches.
+function matchRules(list, url, host, isIP) {
+    var matched = 0;
+    for (var i = 0; i < list.length; i += 1) {
+        if (ruleMatch(list[i], url, host, isIP)) {
+            if (list[i].exclude) {
+                return -1;
+            }
+            matched = 1;
+        }
+    }
+    return matched;
+}
+
 function FindProxyForURL(url, host) {
     if (url.substring(0,4) == "ftp:")
         return methods.direct;
+    host = host.toLowerCase();
     var domain = host2Domain(host);
+    var isIP = hostIsIP(host)[0];
 
-
-    if (directAcc[host] || directAcc[domain]) {
+    var direct = matchRules(rules.direct, url, host, isIP);
+    if (direct !== -1 && (direct === 1 || directAcc[host] || directAcc[domain])) {
         return methods.direct;
-    } else if (blockedAcc[host] || blockedAcc[domain]) {
+    }
+    var blocked = matchRules(rules.blocked, url, host, isIP);
+    if (blocked !== -1 && (blocked === 1 || blockedAcc[host] || blockedAcc[domain])) {
         return methods.blocked;
-    } else
-        return methods.default;
-
+    }
+    return methods.default;
 }
 `
@@ -4,13 +4,17 @@ package pac
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 	"text/template"
 
 	"github.com/alkasir/alkasir/pkg/service"
+	"github.com/alkasir/alkasir/pkg/shared"
 	"github.com/thomasf/lg"
 )
 
@@ -19,6 +23,8 @@ var pac struct {
 	topLevelDomain string
 	directList     string
 	blockedList    string
+	directRules    string
+	blockedRules   string
 	defaultMethod  string
 	blockedMethod  string
 	dLRWMutex      sync.RWMutex
@@ -39,6 +45,8 @@ func init() {
 	var err error
 	pac.template, err = template.New("pac").Parse(pacRawTmpl)
 	pac.defaultMethod = "DIRECT"
+	pac.directRules = "[]"
+	pac.blockedRules = "[]"
 	if err != nil {
 		panic(err)
 	}
@@ -78,6 +86,10 @@ func GenPAC() []byte {
 
 	direct := getDirectList()
 	blocked := getBlockedList()
+	pac.dLRWMutex.RLock()
+	directRules := pac.directRules
+	blockedRules := pac.blockedRules
+	pac.dLRWMutex.RUnlock()
 
 	data := struct {
 		BlockedMethod  string
@@ -85,12 +97,16 @@ func GenPAC() []byte {
 		BlockedDomains string
 		TopLevel       string
 		DefaultMethod  string
+		DirectRules    string
+		BlockedRules   string
 	}{
 		pac.blockedMethod,
 		direct,
 		blocked,
 		pac.topLevelDomain,
 		pac.defaultMethod,
+		directRules,
+		blockedRules,
 	}
 
 	if err := pac.template.Execute(buf, data); err != nil {
@@ -107,16 +123,68 @@ func getDirectList() string {
 	return dl
 }
 
+// pacRule is the javascript representation of a host rule.
+type pacRule struct {
+	Exclude  bool       `json:"exclude,omitempty"`
+	Host     string     `json:"host,omitempty"`
+	Wildcard bool       `json:"wildcard,omitempty"`
+	Path     string     `json:"path,omitempty"`
+	Net      *[2]uint32 `json:"net,omitempty"` // IPv4 network and mask
+}
+
+// splitRules separates plain hosts, which are looked up directly by the pac
+// script, from the rules which has to be matched one by one.
+func splitRules(hosts []string) ([]string, []pacRule) {
+	var plain []string
+	rules := make([]pacRule, 0)
+	for _, v := range hosts {
+		r, err := shared.ParseHostRule(v)
+		if err != nil || r.Plain() {
+			plain = append(plain, v)
+			continue
+		}
+		pr := pacRule{
+			Exclude:  r.Exclude,
+			Host:     r.Host,
+			Wildcard: r.Wildcard,
+			Path:     r.Path,
+		}
+		if r.Net != nil {
+			ip, mask := r.Net.IP.To4(), r.Net.Mask
+			if ip == nil || len(mask) != net.IPv4len {
+				lg.V(10).Infof("ipv6 rule %s is not supported in pac", v)
+				continue
+			}
+			pr.Net = &[2]uint32{binary.BigEndian.Uint32(ip), binary.BigEndian.Uint32(mask)}
+		}
+		rules = append(rules, pr)
+	}
+	return plain, rules
+}
+
+// encodeRules returns rules as a javascript array.
+func encodeRules(rules []pacRule) string {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		lg.Errorln(err)
+		return "[]"
+	}
+	return string(data)
+}
+
 // UpdateBlockedList updates the list of hosts that are not going through any
 // proxy.
 func UpdateDirectList(hosts []string) {
+	plain, rules := splitRules(hosts)
 	var escaped []string
-	for _, v := range hosts {
+	for _, v := range plain {
 		escaped = append(escaped, template.JSEscapeString(v))
 	}
 	dl := strings.Join(escaped, "\",\n\"")
+	dr := encodeRules(rules)
 	pac.dLRWMutex.Lock()
 	pac.directList = dl
+	pac.directRules = dr
 	pac.dLRWMutex.Unlock()
 }
 
@@ -136,14 +204,17 @@ func UpdateBlockedList(hosts ...[]string) {
 		allHosts = append(allHosts, h...)
 	}
 
+	plain, rules := splitRules(allHosts)
 	var escaped []string
-	for _, v := range allHosts {
+	for _, v := range plain {
 		escaped = append(escaped, template.JSEscapeString(v))
 	}
 	dl := strings.Join(escaped, "\",\n\"")
+	dr := encodeRules(rules)
 
 	pac.dLRWMutex.Lock()
 	pac.blockedList = dl
+	pac.blockedRules = dr
 	pac.dLRWMutex.Unlock()
 }
 
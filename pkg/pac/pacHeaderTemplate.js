@@ -18,3 +18,8 @@ var hosts = {
 var topLevel = {
     {{.TopLevel}}
 };
+
+var rules = {
+    direct: {{.DirectRules}},
+    blocked: {{.BlockedRules}}
+};
@@ -20,6 +20,22 @@ var hosts = {
     ],
 }
 
+var rules = {
+    direct: [
+        {host: "direct.some.domain", wildcard: true},
+        {exclude: true, host: "other.taobao.com"}
+    ],
+    blocked: [
+        {host: "wild.domain", wildcard: true},
+        {host: "paths.domain", path: "/news/"},
+        {net: [167772160, 4278190080]}, // 10.0.0.0/8
+        {net: [1249705984, 4294901760]}, // 74.125.0.0/16
+        {exclude: true, host: "cdn.wild.domain"},
+        {exclude: true, host: "excluded.some.domain"},
+        {exclude: true, net: [1249744896, 4294967040]} // 74.125.152.0/24
+    ]
+};
+
 var topLevel = {
     "ac": true,
     "co": true,
@@ -86,6 +86,43 @@ for (i = 0; i < testData.length; i += 1) {
     }
 }
 
+testData = [
+    // wildcards
+    { url: 'http://wild.domain/', host: 'wild.domain', mode: methods.blocked},
+    { url: 'http://a.b.wild.domain/', host: 'a.b.wild.domain', mode: methods.blocked},
+    { url: 'http://A.Wild.Domain/', host: 'A.Wild.Domain', mode: methods.blocked},
+    { url: 'http://notwild.domain/', host: 'notwild.domain', mode: methods.default},
+    { url: 'http://a.direct.some.domain/', host: 'a.direct.some.domain', mode: methods.direct},
+
+    // exclusions
+    { url: 'http://cdn.wild.domain/', host: 'cdn.wild.domain', mode: methods.default},
+    { url: 'http://excluded.some.domain/', host: 'excluded.some.domain', mode: methods.default},
+    { url: 'http://other.some.domain/', host: 'other.some.domain', mode: methods.blocked},
+    { url: 'http://other.taobao.com/', host: 'other.taobao.com', mode: methods.default},
+    { url: 'http://www.taobao.com/', host: 'www.taobao.com', mode: methods.direct},
+
+    // paths
+    { url: 'http://paths.domain/news/today', host: 'paths.domain', mode: methods.blocked},
+    { url: 'http://paths.domain/sports/', host: 'paths.domain', mode: methods.default},
+    { url: 'http://paths.domain', host: 'paths.domain', mode: methods.default},
+    { url: 'http://www.paths.domain/news/', host: 'www.paths.domain', mode: methods.default},
+
+    // networks
+    { url: 'http://74.125.1.1/', host: '74.125.1.1', mode: methods.blocked},
+    { url: 'http://74.126.1.1/', host: '74.126.1.1', mode: methods.default},
+    { url: 'http://74.125.152.1/', host: '74.125.152.1', mode: methods.default},
+    { url: 'http://10.1.1.1/', host: '10.1.1.1', mode: methods.direct}
+];
+
+for (i = 0; i < testData.length; i += 1) {
+    td = testData[i];
+    var res = FindProxyForURL(td.url, td.host)
+    if (res !== td.mode) {
+        console.log(td.host + " should return " + td.mode + " but did return " + res);
+        testsFailed = true ;
+    }
+}
+
 if (testsFailed) {
     console.log("Tests failed!");
     process.exit(1);
@@ -1,6 +1,10 @@
 package pac
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestPACEscape(t *testing.T) {
 	t.Parallel()
@@ -41,3 +45,53 @@ func TestPACEscape(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitRules(t *testing.T) {
+	plain, rules := splitRules([]string{
+		"example.com",
+		"*.wild.com",
+		"paths.com/news/",
+		"!cdn.wild.com",
+		"74.125.0.0/16",
+		"2001:db8::/32",
+		"\"buu\"",
+	})
+	if !reflect.DeepEqual(plain, []string{"example.com", "\"buu\""}) {
+		t.Errorf("unexpected plain hosts: %v", plain)
+	}
+	expected := []pacRule{
+		{Host: "wild.com", Wildcard: true},
+		{Host: "paths.com", Path: "/news/"},
+		{Exclude: true, Host: "cdn.wild.com"},
+		{Net: &[2]uint32{1249705984, 4294901760}},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("unexpected rules: %v", rules)
+	}
+}
+
+func TestGenPACRules(t *testing.T) {
+	pac.dLRWMutex.RLock()
+	saved := []string{pac.directList, pac.blockedList, pac.directRules, pac.blockedRules, pac.blockedMethod}
+	pac.dLRWMutex.RUnlock()
+	defer func() {
+		pac.dLRWMutex.Lock()
+		pac.directList, pac.blockedList, pac.directRules, pac.blockedRules, pac.blockedMethod =
+			saved[0], saved[1], saved[2], saved[3], saved[4]
+		pac.dLRWMutex.Unlock()
+	}()
+
+	UpdateDirectList([]string{"*.direct.com"})
+	UpdateBlockedList([]string{"blocked.com", "!cdn.blocked.com", "10.0.0.0/8"})
+	SetBlockedMethod("DIRECT", "")
+	pacJS := string(GenPAC())
+	for _, s := range []string{
+		`direct: [{"host":"direct.com","wildcard":true}]`,
+		`blocked: [{"exclude":true,"host":"cdn.blocked.com"},{"net":[167772160,4278190080]}]`,
+		`"blocked.com"`,
+	} {
+		if !strings.Contains(pacJS, s) {
+			t.Errorf("generated pac does not contain %s", s)
+		}
+	}
+}
@@ -221,6 +221,21 @@ func NewServerTimeout(kind, operation string, retryAfterSeconds int) error {
 	}}
 }
 
+// NewTooManyRequests returns an error indicating that the client has sent too
+// many requests and should retry after retryAfterSeconds. A zero
+// retryAfterSeconds means that retrying will not help.
+func NewTooManyRequests(message string, retryAfterSeconds int) error {
+	return &StatusError{api.Status{
+		Status:  api.StatusFailure,
+		Code:    StatusTooManyRequests,
+		Reason:  api.StatusReasonTooManyRequests,
+		Message: fmt.Sprintf("Too many requests: %s", message),
+		Details: &api.StatusDetails{
+			RetryAfterSeconds: retryAfterSeconds,
+		},
+	}}
+}
+
 // NewInternalError returns an error indicating the item is invalid and cannot be processed.
 func NewInternalError(err error) error {
 	return &StatusError{api.Status{
@@ -282,7 +297,7 @@ func NewGenericServerResponse(code int, verb, kind, name, serverMessage string,
 		reason = api.StatusReasonServerTimeout
 		message = "the server cannot complete the requested operation at this time, try again later"
 	case StatusTooManyRequests:
-		reason = api.StatusReasonTimeout
+		reason = api.StatusReasonTooManyRequests
 		message = "the server has received too many requests and has asked us to try again later"
 	default:
 		if code >= 500 {
@@ -371,6 +386,12 @@ func IsServerTimeout(err error) bool {
 	return reasonForError(err) == api.StatusReasonServerTimeout
 }
 
+// IsTooManyRequests determines if err is an error which indicates that there are too many requests
+// that the server cannot handle.
+func IsTooManyRequests(err error) bool {
+	return reasonForError(err) == api.StatusReasonTooManyRequests
+}
+
 // IsUnexpectedServerError returns true if the server response was not in the expected API format,
 // and may be the result of another HTTP actor.
 func IsUnexpectedServerError(err error) bool {
@@ -402,6 +423,10 @@ func SuggestsClientDelay(err error) (int, bool) {
 			switch t.Status().Reason {
 			case api.StatusReasonServerTimeout, api.StatusReasonTimeout:
 				return t.Status().Details.RetryAfterSeconds, true
+			case api.StatusReasonTooManyRequests:
+				if t.Status().Details.RetryAfterSeconds > 0 {
+					return t.Status().Details.RetryAfterSeconds, true
+				}
 			}
 		}
 	}
@@ -80,6 +80,15 @@ func TestErrorNew(t *testing.T) {
 	if time, ok := SuggestsClientDelay(NewTimeoutError("test reason", 10)); time != 10 || !ok {
 		t.Errorf("expected to be %s", api.StatusReasonTimeout)
 	}
+	if time, ok := SuggestsClientDelay(NewTooManyRequests("test reason", 10)); time != 10 || !ok {
+		t.Errorf("expected to be %s", api.StatusReasonTooManyRequests)
+	}
+	if _, ok := SuggestsClientDelay(NewTooManyRequests("test reason", 0)); ok {
+		t.Errorf("expected no client delay")
+	}
+	if !IsTooManyRequests(NewGenericServerResponse(StatusTooManyRequests, "POST", "", "", "", 1, false)) {
+		t.Errorf("expected to be %s", api.StatusReasonTooManyRequests)
+	}
 	if !IsMethodNotSupported(NewMethodNotSupported("foo", "delete")) {
 		t.Errorf("expected to be %s", api.StatusReasonMethodNotAllowed)
 	}
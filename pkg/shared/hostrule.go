@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HostRule is a single entry in a hosts list.
+//
+// The supported formats are:
+//
+//	example.com          the host example.com
+//	*.example.com        example.com and all of its subdomains
+//	example.com/news/    URLs on example.com with a path starting with /news/
+//	10.0.0.0/8           IP address hosts inside the network
+//	!cdn.example.com     exclusion, overrides all other rules in the same list
+//
+// Exclusions can be combined with wildcards, paths and networks.
+type HostRule struct {
+	Exclude  bool
+	Host     string     // lower case host name or IP address, empty for network rules
+	Wildcard bool       // match subdomains of Host as well
+	Path     string     // URL path prefix, empty matches all paths
+	Net      *net.IPNet // network rule
+}
+
+// ParseHostRule parses a single host list entry.
+func ParseHostRule(s string) (HostRule, error) {
+	var r HostRule
+	rule := strings.TrimSpace(s)
+	if strings.HasPrefix(rule, "!") {
+		r.Exclude = true
+		rule = strings.TrimSpace(rule[1:])
+	}
+	if rule == "" {
+		return r, fmt.Errorf("empty host rule: '%s'", s)
+	}
+
+	if strings.Contains(rule, "/") {
+		if _, n, err := net.ParseCIDR(rule); err == nil {
+			r.Net = n
+			return r, nil
+		}
+		idx := strings.Index(rule, "/")
+		r.Path = rule[idx:]
+		rule = rule[:idx]
+	}
+	if strings.HasPrefix(rule, "*.") {
+		r.Wildcard = true
+		rule = rule[2:]
+	}
+	rule = strings.ToLower(strings.TrimSuffix(rule, "."))
+	if err := validateRuleHost(rule); err != nil {
+		return r, fmt.Errorf("invalid host rule '%s': %v", s, err)
+	}
+	if r.Wildcard && net.ParseIP(rule) != nil {
+		return r, fmt.Errorf("invalid host rule '%s': wildcard ip address", s)
+	}
+	r.Host = rule
+	return r, nil
+}
+
+func validateRuleHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("empty host")
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return fmt.Errorf("empty label")
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return fmt.Errorf("invalid character '%c'", c)
+			}
+		}
+	}
+	return nil
+}
+
+// String returns the rule in the hosts list format.
+func (r HostRule) String() string {
+	var s string
+	if r.Exclude {
+		s = "!"
+	}
+	if r.Net != nil {
+		return s + r.Net.String()
+	}
+	if r.Wildcard {
+		s += "*."
+	}
+	return s + r.Host + r.Path
+}
+
+// Plain returns true if the rule is a single host without path or exclusion.
+func (r HostRule) Plain() bool {
+	return !r.Exclude && !r.Wildcard && r.Net == nil && r.Path == ""
+}
+
+// Match returns true if the rule matches a host and an URL path, Exclude is
+// not taken into account.
+func (r HostRule) Match(host, path string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if r.Net != nil {
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		return ip != nil && r.Net.Contains(ip)
+	}
+	if host != r.Host && !(r.Wildcard && strings.HasSuffix(host, "."+r.Host)) {
+		return false
+	}
+	if r.Path == "" {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	return strings.HasPrefix(path, r.Path)
+}
+
+// HostRules is a list of host rules where exclusions have precedence.
+type HostRules []HostRule
+
+// ParseHostRules parses all entries in list, invalid entries are skipped and
+// returned as errors.
+func ParseHostRules(list []string) (HostRules, []error) {
+	var rules HostRules
+	var errs []error
+	for _, v := range list {
+		r, err := ParseHostRule(v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, errs
+}
+
+// Match returns true if any rule matches host and path and no exclusion
+// matches.
+func (h HostRules) Match(host, path string) bool {
+	var matched bool
+	for _, r := range h {
+		if !r.Match(host, path) {
+			continue
+		}
+		if r.Exclude {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
@@ -0,0 +1,62 @@
+package shared
+
+import "testing"
+
+func TestParseHostRule(t *testing.T) {
+	t.Parallel()
+	for _, v := range []struct{ in, out string }{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{"*.example.com", "*.example.com"},
+		{"!*.cdn.example.com", "!*.cdn.example.com"},
+		{"example.com/News/", "example.com/News/"},
+		{"1.2.3.4", "1.2.3.4"},
+		{"1.2.3.4/path", "1.2.3.4/path"},
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"!2001:db8::/32", "!2001:db8::/32"},
+	} {
+		r, err := ParseHostRule(v.in)
+		if err != nil {
+			t.Errorf("%s: %v", v.in, err)
+			continue
+		}
+		if r.String() != v.out {
+			t.Errorf("%s: expected %s, got %s", v.in, v.out, r.String())
+		}
+	}
+	for _, v := range []string{"", "!", "*.", "*", "a..com", "a.*.com", "exa mple.com", "*.1.2.3.4", "/path"} {
+		if _, err := ParseHostRule(v); err == nil {
+			t.Errorf("expected %s to be invalid", v)
+		}
+	}
+}
+
+func TestHostRulesMatch(t *testing.T) {
+	t.Parallel()
+	rules, errs := ParseHostRules([]string{
+		"plain.com", "*.wild.com", "!cdn.wild.com", "paths.com/a/", "10.0.0.0/8", "!10.1.0.0/16", "2001:db8::/32",
+	})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for _, v := range []struct {
+		host, path string
+		match      bool
+	}{
+		{"plain.com", "/", true},
+		{"www.plain.com", "/", false},
+		{"wild.com", "/", true},
+		{"a.b.WILD.com", "/", true},
+		{"cdn.wild.com", "/", false},
+		{"paths.com", "/a/b", true},
+		{"paths.com", "/b", false},
+		{"paths.com", "", false},
+		{"10.2.0.1", "/", true},
+		{"10.1.0.1", "/", false},
+		{"[2001:db8::1]", "/", true},
+	} {
+		if m := rules.Match(v.host, v.path); m != v.match {
+			t.Errorf("%s%s: expected %v, got %v", v.host, v.path, v.match, m)
+		}
+	}
+}
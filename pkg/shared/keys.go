@@ -39,3 +39,9 @@ ngYM+7hvdGLa5G7tij+c7R0n4fEhL1xA5qofMznynm8g2vMQxw0WDu0ErpCx7OYS
 =tvUE
 -----END PGP PUBLIC KEY BLOCK-----
 `
+
+// BlocklistVerificationPublicKey is a ED25519 public key to verify hosts lists
+// sent from central.
+var BlocklistVerificationPublicKey = `-----BEGIN ALKASIR BLOCKLIST PUBLIC KEY-----
+10eVVPDitVo6rvHGDgwhBCy3v+NAcrb2HkjhDcO/2s0=
+-----END ALKASIR BLOCKLIST PUBLIC KEY-----`
@@ -65,10 +65,16 @@ func (l *LinkHeader) SetHeader(header http.Header) {
 	header.Add("Link", Format(l.links))
 }
 
+// pageString returns the url for pageValue, an empty pageValue removes the
+// page parameter.
 func (l *LinkHeader) pageString(pageValue string) string {
 	u := l.url
 	q := u.Query()
-	q.Set(l.pageParam, pageValue)
+	if pageValue == "" {
+		q.Del(l.pageParam)
+	} else {
+		q.Set(l.pageParam, pageValue)
+	}
 	u.RawQuery = q.Encode()
 	return u.String()
 }
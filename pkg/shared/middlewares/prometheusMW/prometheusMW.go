@@ -47,6 +47,13 @@ func (mw *PrometheusMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.Ha
 
 	prometheus.MustRegister(status404)
 
+	status429 := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("%s_api_req_429", mw.ServiceName),
+		Help: "Total api status 429 (too many requests) responses",
+	})
+
+	prometheus.MustRegister(status429)
+
 	status4xx := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: fmt.Sprintf("%s_api_req_4xx", mw.ServiceName),
 		Help: "Total api status 4xx responses",
@@ -68,6 +75,8 @@ func (mw *PrometheusMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.Ha
 				status400.Inc()
 			case s == 404:
 				status404.Inc()
+			case s == 429:
+				status429.Inc()
 			case s > 400:
 				status4xx.Inc()
 			}
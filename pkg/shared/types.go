@@ -1,8 +1,10 @@
 package shared
 
 import (
+	"encoding/json"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
@@ -18,6 +20,8 @@ type SuggestionTokenRequest struct {
 	URL         string // The url in question
 	ClientAddr  net.IP // the public ip address of the client
 	CountryCode string // the client country code setting
+	UpdateID    string `json:",omitempty"` // the update id last sent by the client, see UpdateHostlistRequest
+	Reverify    bool   `json:",omitempty"` // true if the client re-verifies a host it already lists as blocked
 }
 
 // SuggestionTokenResponse is sent back to the client after processing the SuggestionTokenRequest.
@@ -63,6 +67,7 @@ type SampleResponse struct {
 type NewClientTokenSample struct {
 	URL         string // The url
 	CountryCode string // The clients configured country code, ie. not the one derived by geoip.
+	Reverify    bool   `json:",omitempty"` // The session re-verifies an already listed host, see SuggestionTokenRequest.
 }
 
 // BrowserExtensionSample represents how the browser saw the url and related when it was submitted.
@@ -82,20 +87,112 @@ type IPExtraData struct {
 // UpdateHostlistRequest .
 type UpdateHostlistRequest struct {
 	ClientAddr    net.IP // the public ip address of the client
-	UpdateID      string `json:"update_id"`  // unique installation identifer
-	ClientVersion string `json:"client_ver"` // client version
+	UpdateID      string `json:"update_id"`          // unique installation identifer
+	ClientVersion string `json:"client_ver"`         // client version
+	Revision      string `json:"revision,omitempty"` // the revision of the clients current hosts list
 }
 
+// Host list scopes.
+const (
+	HostScopeASN     = "asn"     // blocked for the clients ASN
+	HostScopeCountry = "country" // blocked for enough ASNs to be considered blocked in the whole country
+)
+
 // UpdateHostlistRequest .
 type UpdateHostlistResponse struct {
-	Ok    bool
-	Error string
-	Hosts []string // All hosts listed as blocked in the current region
+	Ok       bool
+	Error    string
+	Hosts    []string // All hosts listed as blocked in the current region, empty if Delta is true
+	Revision string   `json:",omitempty"` // revision of the hosts list
+	Delta    bool     `json:",omitempty"` // if true, only Added and Removed are set relative to BaseRevision
+	Added    []string `json:",omitempty"`
+	Removed  []string `json:",omitempty"`
+	// Scope of each host in Hosts, or in Added if Delta is true. One of the
+	// HostScope constants.
+	Scopes map[string]string `json:",omitempty"`
+
+	BaseRevision string    `json:",omitempty"` // the revision sent in the request if Delta is true
+	CountryCode  string    `json:",omitempty"` // country code which central resolved the client to
+	ASN          int       `json:",omitempty"` // ASN which central resolved the client to
+	Timestamp    time.Time // time when the list was signed
+	Signature    string    `json:",omitempty"` // ED25519 signature of SignedData, base64 raw url encoded
+}
+
+// SignedData returns the parts of the response which are covered by the
+// signature.
+func (u UpdateHostlistResponse) SignedData() ([]byte, error) {
+	return json.Marshal(struct {
+		Hosts        []string
+		Revision     string
+		Delta        bool
+		Added        []string
+		Removed      []string
+		Scopes       map[string]string
+		BaseRevision string
+		CountryCode  string
+		ASN          int
+		Timestamp    int64
+	}{
+		Hosts:        nilIfEmpty(u.Hosts),
+		Revision:     u.Revision,
+		Delta:        u.Delta,
+		Added:        nilIfEmpty(u.Added),
+		Removed:      nilIfEmpty(u.Removed),
+		Scopes:       u.Scopes,
+		BaseRevision: u.BaseRevision,
+		CountryCode:  u.CountryCode,
+		ASN:          u.ASN,
+		Timestamp:    u.Timestamp.Unix(),
+	})
+}
+
+// nilIfEmpty makes empty and nil slices sign the same since empty slices does
+// not survive a JSON round trip when omitempty is used.
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// ExportFilter narrows down the entries returned by the export api. Zero
+// values are not filtered on.
+type ExportFilter struct {
+	CountryCode string    `json:"country_code"`
+	ASN         int       `json:"asn"`
+	Type        string    `json:"type"`
+	Host        string    `json:"host"`
+	Since       time.Time `json:"since"` // inclusive
+	Until       time.Time `json:"until"` // exclusive
+}
+
+// AddParams adds the set filters to the url query q.
+func (f ExportFilter) AddParams(q url.Values) {
+	if f.CountryCode != "" {
+		q.Set("country_code", f.CountryCode)
+	}
+	if f.ASN != 0 {
+		q.Set("asn", strconv.Itoa(f.ASN))
+	}
+	if f.Type != "" {
+		q.Set("type", f.Type)
+	}
+	if f.Host != "" {
+		q.Set("host", f.Host)
+	}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		q.Set("until", f.Until.UTC().Format(time.RFC3339))
+	}
 }
 
 // BlockedContentRequest .
 type BlockedContentRequest struct {
 	IDMax int `json:"id_max"` // TODO: maybe move
+	Limit int `json:"limit"`  // page length, PageLength if 0
+	ExportFilter
 }
 
 func (b BlockedContentRequest) AddParams(req *http.Request) {
@@ -104,6 +201,10 @@ func (b BlockedContentRequest) AddParams(req *http.Request) {
 	if b.IDMax != 0 {
 		q.Add("id_max", strconv.Itoa(b.IDMax))
 	}
+	if b.Limit != 0 {
+		q.Add("limit", strconv.Itoa(b.Limit))
+	}
+	b.ExportFilter.AddParams(q)
 	req.URL.RawQuery = q.Encode()
 }
 
@@ -120,10 +221,14 @@ type HostsPublishLog struct {
 // SampleRequest .
 type ExportSampleRequest struct {
 	IDMax int `json:"id_max"` // TODO: maybe move
+	Limit int `json:"limit"`  // page length, PageLength if 0
+	ExportFilter
 }
 
 type ExportSimpleSampleRequest struct {
 	IDMax int `json:"id_max"` // TODO: maybe move
+	Limit int `json:"limit"`  // page length, PageLength if 0
+	ExportFilter
 }
 
 // Sample is the core data structure representing a network test.
@@ -151,6 +256,44 @@ type ExportSimpleSampleEntry struct {
 	Data        string    `json:"data"`
 }
 
+// ExportAnalysisResultRequest .
+type ExportAnalysisResultRequest struct {
+	IDMax int `json:"id_max"` // TODO: maybe move
+	Limit int `json:"limit"`  // page length, PageLength if 0
+	ExportFilter
+}
+
+// ExportAnalysisResultEntry is the outcome of analysing one suggestion session.
+type ExportAnalysisResultEntry struct {
+	ID              string    `json:"id"`
+	Host            string    `json:"host"`
+	CountryCode     string    `json:"country_code"`
+	ASN             string    `json:"asn"`
+	CreatedAt       time.Time `json:"created_at"`
+	Token           string    `json:"token"`
+	StatusCodeScore float64   `json:"status_code_score"`
+	RedirectsScore  float64   `json:"redirects_score"`
+	ErrorScore      float64   `json:"error_score"`
+	Score           float64   `json:"score"`
+	Published       bool      `json:"published"`
+	Scores          string    `json:"scores"`
+}
+
+// ExportStatsRequest .
+type ExportStatsRequest struct {
+	Name string `json:"name"` // name of the statistics
+	ExportFilter
+}
+
+// ExportStatsEntry is a count for one day of a statistics rollup.
+type ExportStatsEntry struct {
+	Bucket      time.Time `json:"bucket"` // start of the day in UTC
+	CountryCode string    `json:"country_code"`
+	ASN         int       `json:"asn"`
+	Label       string    `json:"label"` // the publish action or client version
+	Count       int       `json:"count"`
+}
+
 // BinaryUpgradeRequest .
 type BinaryUpgradeRequest struct {
 	Artifact    string `json:"artifact"`
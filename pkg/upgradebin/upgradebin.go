@@ -38,6 +38,13 @@ func NewUpdaterOptions(meta shared.BinaryUpgradeResponse, publicKey string) (upd
 	}, nil
 }
 
+// Names of the PEM key types, the PEM block types are the name followed by
+// PUBLIC KEY or PRIVATE KEY.
+const (
+	UpgradesKeyName  = "ALKASIR UPGRADES"
+	BlocklistKeyName = "ALKASIR BLOCKLIST"
+)
+
 // KeyPair .
 type KeyPair struct {
 	Public  *[32]byte
@@ -45,11 +52,16 @@ type KeyPair struct {
 }
 
 func DecodeKeys(priv, pub []byte) (*KeyPair, error) {
-	pubK, err := DecodePublicKey(pub)
+	return DecodeNamedKeys(UpgradesKeyName, priv, pub)
+}
+
+// DecodeNamedKeys decodes a key pair whose PEM block types start with name.
+func DecodeNamedKeys(name string, priv, pub []byte) (*KeyPair, error) {
+	pubK, err := DecodeNamedPublicKey(name, pub)
 	if err != nil {
 		return nil, err
 	}
-	privK, err := DecodePrivateKey(priv)
+	privK, err := DecodeNamedPrivateKey(name, priv)
 	if err != nil {
 		return nil, err
 	}
@@ -61,11 +73,17 @@ func DecodeKeys(priv, pub []byte) (*KeyPair, error) {
 }
 
 func DecodePrivateKey(priv []byte) (*[64]byte, error) {
+	return DecodeNamedPrivateKey(UpgradesKeyName, priv)
+}
+
+// DecodeNamedPrivateKey decodes a private key whose PEM block type starts with
+// name.
+func DecodeNamedPrivateKey(name string, priv []byte) (*[64]byte, error) {
 	privblk, _ := pem.Decode(priv)
 	if privblk == nil {
 		return nil, fmt.Errorf("could not decode private key")
 	}
-	if privblk.Type != "ALKASIR UPGRADES PRIVATE KEY" {
+	if privblk.Type != name+" PRIVATE KEY" {
 		return nil, fmt.Errorf("invalid key type")
 	}
 	if len(privblk.Bytes) != 64 {
@@ -79,11 +97,17 @@ func DecodePrivateKey(priv []byte) (*[64]byte, error) {
 }
 
 func DecodePublicKey(pub []byte) (*[32]byte, error) {
+	return DecodeNamedPublicKey(UpgradesKeyName, pub)
+}
+
+// DecodeNamedPublicKey decodes a public key whose PEM block type starts with
+// name.
+func DecodeNamedPublicKey(name string, pub []byte) (*[32]byte, error) {
 	pubblk, _ := pem.Decode(pub)
 	if pubblk == nil {
 		return nil, fmt.Errorf("could not decode public key")
 	}
-	if pubblk.Type != "ALKASIR UPGRADES PUBLIC KEY" {
+	if pubblk.Type != name+" PUBLIC KEY" {
 		return nil, fmt.Errorf("invalid key type")
 	}
 	if len(pubblk.Bytes) != 32 {
@@ -113,13 +137,19 @@ func DecodeSignature(sig string) (*[64]byte, error) {
 }
 
 func EncodeKeys(privKey *[64]byte, pubKey *[32]byte) ([]byte, []byte) {
+	return EncodeNamedKeys(UpgradesKeyName, privKey, pubKey)
+}
+
+// EncodeNamedKeys encodes a key pair as PEM blocks whose types starts with
+// name.
+func EncodeNamedKeys(name string, privKey *[64]byte, pubKey *[32]byte) ([]byte, []byte) {
 	priv := pem.EncodeToMemory(&pem.Block{
-		Type:  "ALKASIR UPGRADES PRIVATE KEY",
+		Type:  name + " PRIVATE KEY",
 		Bytes: privKey[:],
 	})
 
 	pub := pem.EncodeToMemory(&pem.Block{
-		Type:  "ALKASIR UPGRADES PUBLIC KEY",
+		Type:  name + " PUBLIC KEY",
 		Bytes: pubKey[:],
 	})
 	return priv, pub